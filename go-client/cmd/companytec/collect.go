@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/journal"
+)
+
+// runCollect implements "companytec collect": it captures every supply
+// exactly once into a JSON lines file or a journal, acknowledging each one on
+// the device only after it was written to disk.
+func runCollect(args []string) {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	host := fs.String("host", "127.0.0.1", "Device host IP")
+	port := fs.Int("port", 2001, "Device port")
+	timeout := fs.Duration("timeout", 5*time.Second, "Device dial/read/write timeout")
+	out := fs.String("out", "supplies.jsonl", "File the supplies are appended to")
+	journalPath := fs.String("journal", "", "Journal file to store the supplies in, instead of -out")
+	interval := fs.Duration("interval", 2*time.Second, "Poll interval when no supply is pending")
+	once := fs.Bool("once", false, "Collect the pending supplies and exit")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var sink companytec.SupplySink
+	if *journalPath != "" {
+		j, err := journal.Open(*journalPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sink Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer j.Close()
+		sink = j
+	} else {
+		f, err := companytec.OpenFileSink(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sink Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		sink = f
+	}
+
+	// The collector reports each collection on the client logger
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	client := companytec.New(net.JoinHostPort(*host, strconv.Itoa(*port)),
+		companytec.WithTimeout(*timeout), companytec.WithLogger(logger))
+	if err := client.ConnectCtx(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Connect Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Disconnect()
+
+	collector := companytec.NewSupplyCollector(client, sink, *interval)
+	if cp, err := collector.Checkpoint(ctx); err == nil && cp.Count > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming after %d supplies (last %s)\n", cp.Count, cp.Key)
+	}
+
+	if *once {
+		n, err := collector.Drain(ctx)
+		fmt.Fprintf(os.Stderr, "%d supplies collected\n", n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Collect Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	collector.Run(ctx)
+}
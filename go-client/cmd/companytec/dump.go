@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// runDump implements "companytec dump": it writes the supplies stored in the
+// device memory as JSON lines, without moving the read pointer, to recover
+// transactions missed while the back office was offline.
+func runDump(args []string) {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	host := fs.String("host", "127.0.0.1", "Device host IP")
+	port := fs.Int("port", 2001, "Device port")
+	timeout := fs.Duration("timeout", 5*time.Second, "Device dial/read/write timeout")
+	memorySize := fs.Int("memory-size", companytec.DefaultMemorySize, "Number of supplies the device memory holds")
+	from := fs.Int("from", -1, "First memory position (default: the write pointer, the oldest supply once the memory is full)")
+	to := fs.Int("to", -1, "Last memory position, before -from to wrap around the end of the memory (default: the position before the write pointer)")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := companytec.New(net.JoinHostPort(*host, strconv.Itoa(*port)),
+		companytec.WithTimeout(*timeout), companytec.WithMemorySize(*memorySize))
+	if err := client.ConnectCtx(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Connect Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Disconnect()
+
+	first, last := *from, *to
+	if first < 0 || last < 0 {
+		pointers, err := client.PointersCtx(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Pointer Error: %v\n", err)
+			os.Exit(1)
+		}
+		size := client.MemorySize()
+		if first < 0 {
+			first = pointers.Write % size
+		}
+		if last < 0 {
+			last = (pointers.Write - 1 + size) % size
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	n := 0
+	for entry, err := range client.DumpSupplies(ctx, first, last) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Dump Error at position %d: %v\n", entry.Position, err)
+			os.Exit(1)
+		}
+		enc.Encode(entry)
+		n++
+	}
+	fmt.Fprintf(os.Stderr, "%d supplies dumped\n", n)
+}
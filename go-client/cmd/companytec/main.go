@@ -2,29 +2,111 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"companytec-client/pkg/api"
+	"companytec-client/pkg/blacklist"
+	"companytec-client/pkg/cache"
+	"companytec-client/pkg/clocksync"
 	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/fiscal"
+	"companytec-client/pkg/fleet"
+	"companytec-client/pkg/journal"
+	"companytec-client/pkg/metrics"
+	"companytec-client/pkg/recording"
+	"companytec-client/pkg/tracing"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		case "dump":
+			runDump(os.Args[2:])
+			return
+		case "collect":
+			runCollect(os.Args[2:])
+			return
+		case "monitor":
+			runMonitor(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		}
+	}
+
 	host := flag.String("host", "127.0.0.1", "Device host IP")
 	port := flag.Int("port", 2001, "Device port")
 	apiPort := flag.Int("api-port", 3000, "API server port")
+	timeout := flag.Duration("timeout", 5*time.Second, "Device dial/read/write timeout")
+	memorySize := flag.Int("memory-size", companytec.DefaultMemorySize, "Number of supplies the device memory holds")
+	clockSync := flag.Duration("clock-sync", 0, "Device clock check interval (0 disables clock sync)")
+	clockThreshold := flag.Duration("clock-threshold", 2*time.Second, "Clock skew corrected by clock sync")
+	blacklistState := flag.String("blacklist-state", "", "File recording the device blacklist (empty keeps it in memory)")
+	devicesPath := flag.String("devices", "", "JSON file listing more devices ({id, addr, labels}) served under /devices/:id")
+	fleetState := flag.String("fleet-state", "", "Directory keeping a journal and a blacklist record per -devices device")
+	fleetCollect := flag.Bool("fleet-collect", false, "Collect the supplies of every -devices device into its -fleet-state journal, acknowledging them")
+	journalPath := flag.String("journal", "", "Journal file: collects every supply and records price, preset and mode changes")
+	cacheTTL := flag.Duration("cache", 0, "Status and visualization cache TTL, totals and prices get longer defaults (0 disables the cache)")
+	wsOrigins := flag.String("ws-origins", "", "Comma separated origins allowed to open /ws, * for any (empty allows the API host only)")
+	metricsOn := flag.Bool("metrics", true, "Serve Prometheus metrics on /metrics")
+	traceExporter := flag.String("trace", "", "OpenTelemetry trace exporter: otlp, stdout or file (empty disables tracing)")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP collector URL (default from OTEL_EXPORTER_OTLP_ENDPOINT)")
+	traceFile := flag.String("trace-file", "traces.jsonl", "File the file trace exporter appends to")
+	logLevel := flag.String("log-level", "info", "Level of the logs written to stderr: debug, info, warn or error")
+	recordPath := flag.String("record", "", "File recording every frame exchanged with the devices, for companytec replay")
+	wireTrace := flag.Bool("wire-trace", false, "Log every frame sent to and received from the devices, identifier tags masked (implies -log-level debug)")
 	flag.Parse()
 
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Printf("Log Level Error: %v\n", err)
+		os.Exit(1)
+	}
+	clientOpts := []companytec.Option{companytec.WithTimeout(*timeout), companytec.WithMemorySize(*memorySize)}
+	if *wireTrace {
+		level = slog.LevelDebug
+		clientOpts = append(clientOpts, companytec.WithWireTrace())
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	stopRecording := func() {}
+	if *recordPath != "" {
+		rec, err := recording.Create(*recordPath)
+		if err != nil {
+			fmt.Printf("Recording Error: %v\n", err)
+			os.Exit(1)
+		}
+		stopRecording = func() { rec.Close() }
+		defer stopRecording()
+		clientOpts = append(clientOpts, rec.Option())
+		fmt.Printf("Recording: %s\n", *recordPath)
+	}
+
 	fmt.Printf("Companytec Client\n")
 	fmt.Printf("Device: %s:%d\n", *host, *port)
 	fmt.Printf("API Port: %d\n\n", *apiPort)
 
 	// Create client
-	client := companytec.NewClient(*host, *port)
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	client := companytec.New(addr, append(clientOpts, companytec.WithLogger(logger.With("device", "default")))...)
+	client.OnStateChange(func(change companytec.StateChange) {
+		if change.To == companytec.StateDegraded || change.To == companytec.StateDown {
+			fmt.Printf("\nDevice connection %s: %v\n", change.To, change.Err)
+		}
+	})
 
 	// Connect immediately for better UX
 	fmt.Println("Connecting to device...")
@@ -34,8 +116,105 @@ func main() {
 		fmt.Println("Connected successfully!")
 	}
 
+	bl, err := blacklist.New(client, *blacklistState)
+	if err != nil {
+		fmt.Printf("Blacklist Error: %v\n", err)
+		os.Exit(1)
+	}
+	serverOpts := []api.Option{api.WithBlacklist(bl), api.WithLogger(logger)}
+	if *wsOrigins != "" {
+		serverOpts = append(serverOpts, api.WithAllowedOrigins(strings.Split(*wsOrigins, ",")...))
+	}
+	stopTracing := func() {}
+	if *traceExporter != "" {
+		tp, err := tracing.NewProvider(context.Background(), tracing.Config{
+			Exporter: *traceExporter,
+			Endpoint: *traceEndpoint,
+			File:     *traceFile,
+		})
+		if err != nil {
+			fmt.Printf("Tracing Error: %v\n", err)
+			os.Exit(1)
+		}
+		// Flushes the last spans
+		stopTracing = func() { tp.Shutdown(context.Background()) }
+		defer stopTracing()
+		tracing.Install(tp)
+		serverOpts = append(serverOpts, api.WithTracing(tp))
+		fmt.Printf("Tracing: %s\n", *traceExporter)
+	}
+	if *metricsOn {
+		serverOpts = append(serverOpts, api.WithMetrics(metrics.New()))
+	}
+	if *clockSync > 0 {
+		svc := clocksync.New(client, clocksync.Config{
+			Interval:  *clockSync,
+			Threshold: *clockThreshold,
+			Logger:    logger.With("device", "default"),
+		})
+		go svc.Run(context.Background())
+		serverOpts = append(serverOpts, api.WithClockSync(svc))
+		fmt.Printf("Clock sync every %s (threshold %s)\n", *clockSync, *clockThreshold)
+	}
+	if *journalPath != "" {
+		j, err := journal.Open(*journalPath)
+		if err != nil {
+			fmt.Printf("Journal Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer j.Close()
+		// The auditor resumes from the journal before the collector adds to it
+		auditor := fiscal.NewAuditor(0)
+		if err := auditor.LoadJournal(j); err != nil {
+			fmt.Printf("Journal Error: %v\n", err)
+			os.Exit(1)
+		}
+		collector := companytec.NewSupplyCollector(client, fiscal.NewSink(j, auditor), 0)
+		go collector.Run(context.Background())
+		serverOpts = append(serverOpts, api.WithJournal(j), api.WithAuditor(auditor))
+		fmt.Printf("Journal: %s (collecting supplies)\n", *journalPath)
+	}
+	if *cacheTTL > 0 {
+		serverOpts = append(serverOpts, api.WithCache(cache.Config{StatusTTL: *cacheTTL, VisualizationTTL: *cacheTTL}))
+		fmt.Printf("Response cache: %s\n", *cacheTTL)
+	}
+
+	if *devicesPath != "" {
+		devices, err := fleet.ReadFile(*devicesPath)
+		if err != nil {
+			fmt.Printf("Devices Error: %v\n", err)
+			os.Exit(1)
+		}
+		reg := fleet.NewRegistry(append(clientOpts, companytec.WithLogger(logger))...)
+		for _, cfg := range devices {
+			if _, err := reg.Add(cfg); err != nil {
+				fmt.Printf("Devices Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		for id, err := range reg.ConnectAll(context.Background()) {
+			fmt.Printf("Warning: device %s: %v\n", id, err)
+		}
+		defer reg.Close()
+		serverOpts = append(serverOpts, api.WithFleet(reg))
+		if *fleetState != "" {
+			if err := os.MkdirAll(*fleetState, 0o755); err != nil {
+				fmt.Printf("Devices Error: %v\n", err)
+				os.Exit(1)
+			}
+			serverOpts = append(serverOpts, api.WithFleetState(*fleetState))
+			if *fleetCollect {
+				serverOpts = append(serverOpts, api.WithFleetCollector())
+			}
+		} else if *fleetCollect {
+			fmt.Println("Devices Error: -fleet-collect requires -fleet-state")
+			os.Exit(1)
+		}
+		fmt.Printf("Fleet: %d devices\n", len(devices))
+	}
+
 	// Start API Server
-	server := api.NewServer(client)
+	server := api.NewServer(client, serverOpts...)
 	go func() {
 		if err := server.Run(*apiPort); err != nil {
 			fmt.Printf("API Error: %v\n", err)
@@ -50,6 +229,8 @@ func main() {
 		<-c
 		fmt.Println("\nShutting down...")
 		client.Disconnect()
+		stopTracing()
+		stopRecording()
 		os.Exit(0)
 	}()
 
@@ -65,7 +246,7 @@ func main() {
 		if choice == "0" {
 			break
 		}
-		handleCommand(client, scanner, choice)
+		handleCommand(client, bl, scanner, choice)
 	}
 }
 
@@ -78,8 +259,12 @@ func showMenu(apiPort int) {
 	fmt.Println("  2.  Read Supply Identified")
 	fmt.Println("  3.  Read Supply PAF1")
 	fmt.Println("  4.  Read Supply PAF2")
+	fmt.Println("  35. Read Supply Dual Identification")
 	fmt.Println("  5.  Read Memory Pointers")
 	fmt.Println("  6.  Increment Supply Pointer")
+	fmt.Println("  32. Read Supply at Position")
+	fmt.Println("  33. Move Read Pointer")
+	fmt.Println("  34. Dump Supplies")
 	fmt.Println("Visualization:")
 	fmt.Println("  7.  Get Visualization")
 	fmt.Println("  8.  Get Visualization Identified")
@@ -87,6 +272,8 @@ func showMenu(apiPort int) {
 	fmt.Println("  9.  Get Status")
 	fmt.Println("  10. Read Calendar")
 	fmt.Println("  11. Read Extended Clock")
+	fmt.Println("  26. Set Calendar to Host Time")
+	fmt.Println("  27. Set Extended Clock to Host Time")
 	fmt.Println("Pump Management:")
 	fmt.Println("  12. Read Total (Volume - L)")
 	fmt.Println("  13. Read Total (Value - $)")
@@ -97,6 +284,16 @@ func showMenu(apiPort int) {
 	fmt.Println("Identifier:")
 	fmt.Println("  18. Read Identifier")
 	fmt.Println("  19. Read Identifier from Memory")
+	fmt.Println("  21. Record Identifier")
+	fmt.Println("  22. Delete Identifier")
+	fmt.Println("  23. Increment Identifier")
+	fmt.Println("  24. Clear Identifier Memory")
+	fmt.Println("  25. Set Preset Identified")
+	fmt.Println("Blacklist:")
+	fmt.Println("  28. Add to Blacklist")
+	fmt.Println("  29. Remove from Blacklist")
+	fmt.Println("  30. Clear Blacklist")
+	fmt.Println("  31. Sync Blacklist from File (CSV/JSON)")
 	fmt.Println("Advanced:")
 	fmt.Println("  20. Send Custom Command")
 	fmt.Println("")
@@ -114,7 +311,7 @@ func ask(scanner *bufio.Scanner, prompt string) string {
 	return ""
 }
 
-func handleCommand(client *companytec.Client, scanner *bufio.Scanner, choice string) {
+func handleCommand(client *companytec.Client, bl *blacklist.Manager, scanner *bufio.Scanner, choice string) {
 	var err error
 	var res string
 
@@ -203,6 +400,118 @@ func handleCommand(client *companytec.Client, scanner *bufio.Scanner, choice str
 		// But `buildCommand` is separate.
 		// So if user types `&S` in item 20, it won't work in JS unless they type `(&S)`.
 		// Let's assume user knows protocol or we blindly send.
+	case "21":
+		rec := companytec.IdentifierRecord{
+			Control:   ask(scanner, "Enter control code (2 hex, e.g., 00): "),
+			Parameter: ask(scanner, "Enter recording parameter (1 char, e.g., 0): "),
+			ID:        ask(scanner, "Enter identifier (16 hex): "),
+			ShiftA: companytec.Shift{
+				Start: ask(scanner, "Enter shift A start (hhmm, empty=unused): "),
+				End:   ask(scanner, "Enter shift A end (hhmm, empty=unused): "),
+			},
+			ShiftB: companytec.Shift{
+				Start: ask(scanner, "Enter shift B start (hhmm, empty=unused): "),
+				End:   ask(scanner, "Enter shift B end (hhmm, empty=unused): "),
+			},
+		}
+		fmt.Println("--- Record Identifier ---")
+		res, err = client.RecordIdentifier(rec)
+	case "22":
+		control := ask(scanner, "Enter control code (2 hex, e.g., 00): ")
+		id := ask(scanner, "Enter identifier (16 hex): ")
+		var pos int
+		fmt.Sscanf(ask(scanner, "Enter memory position (0=fixed): "), "%d", &pos)
+		fmt.Println("--- Delete Identifier ---")
+		res, err = client.DeleteIdentifier(control, id, pos)
+	case "23":
+		fmt.Println("--- Increment Identifier ---")
+		res, err = client.IncrementIdentifier()
+	case "24":
+		if ask(scanner, "Erase every recorded identifier? (y/N): ") != "y" {
+			return
+		}
+		fmt.Println("--- Clear Identifier Memory ---")
+		res, err = client.ClearIdentifierMemory()
+	case "25":
+		p := companytec.IdentifiedPreset{
+			Nozzle:     ask(scanner, "Enter nozzle code (hex, e.g., 08): "),
+			ID:         ask(scanner, "Enter identifier (16 hex): "),
+			Authorize:  ask(scanner, "Authorize now? (y/N): ") == "y",
+			PresetType: ask(scanner, "Enter preset type ($=money, V=volume): "),
+		}
+		fmt.Sscanf(ask(scanner, "Enter identifier type (0=attendant, 1=customer, 2=odometer): "), "%d", &p.Type)
+		fmt.Sscanf(ask(scanner, "Enter preset value (e.g., 001000): "), "%d", &p.Value)
+		fmt.Sscanf(ask(scanner, "Enter timeout (e.g., 30): "), "%d", &p.Timeout)
+		fmt.Println("--- Set Preset Identified ---")
+		res, err = client.SetPresetIdentified(p)
+	case "26":
+		fmt.Println("--- Set Calendar ---")
+		res, err = client.SetCalendar(time.Now())
+	case "27":
+		fmt.Println("--- Set Extended Clock ---")
+		res, err = client.SetClockExtended(time.Now())
+	case "28":
+		id := ask(scanner, "Enter identifier (16 hex): ")
+		fmt.Println("--- Add to Blacklist ---")
+		if err = bl.Add(context.Background(), id); err == nil {
+			res = "blacklisted " + id
+		}
+	case "29":
+		id := ask(scanner, "Enter identifier (16 hex): ")
+		fmt.Println("--- Remove from Blacklist ---")
+		if err = bl.Remove(context.Background(), id); err == nil {
+			res = "removed " + id
+		}
+	case "30":
+		if ask(scanner, "Clear the whole blacklist? (y/N): ") != "y" {
+			return
+		}
+		fmt.Println("--- Clear Blacklist ---")
+		if err = bl.Clear(context.Background()); err == nil {
+			res = "blacklist cleared"
+		}
+	case "31":
+		path := ask(scanner, "Enter file path (.csv or .json): ")
+		fmt.Println("--- Sync Blacklist ---")
+		var desired []string
+		if desired, err = blacklist.ReadFile(path); err != nil {
+			break
+		}
+		var results []blacklist.Result
+		results, err = bl.Sync(context.Background(), desired)
+		for _, r := range results {
+			fmt.Printf("  %-6s %-16s %s\n", r.Action, r.ID, r.Error)
+		}
+		res = fmt.Sprintf("%d entries", len(results))
+	case "35":
+		fmt.Println("--- Read Supply Dual Identification ---")
+		res, err = client.ReadSupplyDualIdentification()
+	case "32":
+		var pos int
+		fmt.Sscanf(ask(scanner, "Enter memory position (e.g., 0): "), "%d", &pos)
+		fmt.Println("--- Read Supply at Position ---")
+		res, err = client.ReadSupplyPointer(companytec.PointerConsult, pos)
+	case "33":
+		var pos int
+		fmt.Sscanf(ask(scanner, "Enter memory position (e.g., 0): "), "%d", &pos)
+		fmt.Println("--- Move Read Pointer ---")
+		res, err = client.ReadSupplyPointer(companytec.PointerReposition, pos)
+	case "34":
+		var from, to int
+		fmt.Sscanf(ask(scanner, "From position: "), "%d", &from)
+		fmt.Sscanf(ask(scanner, "To position: "), "%d", &to)
+		fmt.Println("--- Dump Supplies ---")
+		n := 0
+		for entry, dumpErr := range client.DumpSupplies(context.Background(), from, to) {
+			if dumpErr != nil {
+				err = dumpErr
+				break
+			}
+			fmt.Printf("  %04d nozzle %s record %04d volume %d value %d\n",
+				entry.Position, entry.Nozzle, entry.Record, entry.Volume, entry.TotalToPay)
+			n++
+		}
+		res = fmt.Sprintf("%d supplies", n)
 	default:
 		fmt.Println("Invalid option")
 		return
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/monitor"
+)
+
+// runMonitor implements "companytec monitor": it prints the fueling lifecycle
+// events of every nozzle, as text or JSON lines.
+func runMonitor(args []string) {
+	fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+	host := fs.String("host", "127.0.0.1", "Device host IP")
+	port := fs.Int("port", 2001, "Device port")
+	timeout := fs.Duration("timeout", 5*time.Second, "Device dial/read/write timeout")
+	statusInterval := fs.Duration("status-interval", time.Second, "Status poll interval")
+	visInterval := fs.Duration("visualization-interval", 500*time.Millisecond, "Visualization poll interval while fueling (negative disables progress events)")
+	asJSON := fs.Bool("json", false, "Print events as JSON lines")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := companytec.New(net.JoinHostPort(*host, strconv.Itoa(*port)), companytec.WithTimeout(*timeout))
+	if err := client.ConnectCtx(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Connect Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Disconnect()
+
+	mon := monitor.New(client, monitor.Config{StatusInterval: *statusInterval, VisualizationInterval: *visInterval})
+	sub := mon.Subscribe(64)
+	defer sub.Close()
+	go mon.Run(ctx)
+
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-sub.C:
+			if *asJSON {
+				enc.Encode(ev)
+				continue
+			}
+			fmt.Printf("%s  nozzle %s  %-18s %s", ev.Time.Format("15:04:05.000"), ev.Nozzle, ev.Type, ev.Status.Description())
+			if ev.Value != 0 {
+				fmt.Printf("  value %d", ev.Value)
+			}
+			fmt.Println()
+		}
+	}
+}
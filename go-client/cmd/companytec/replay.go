@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/recording"
+)
+
+// runReplay implements "companytec replay": it serves a session recorded with
+// -record as a fake device or, with -diff, sends its commands to a live
+// device and prints the responses that differ.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "session.jsonl", "Recording to replay")
+	addr := fs.String("addr", "", "Only replay the connections to this device address (default: all)")
+	listen := fs.String("listen", ":2001", "Address to serve the recording on")
+	latency := fs.Bool("latency", false, "Answer with the recorded latency")
+	diff := fs.Bool("diff", false, "Compare a live device to the recording instead of serving it")
+	host := fs.String("host", "127.0.0.1", "Live device host IP, with -diff")
+	port := fs.Int("port", 2001, "Live device port, with -diff")
+	timeout := fs.Duration("timeout", 5*time.Second, "Live device dial/read/write timeout, with -diff")
+	writes := fs.Bool("writes", false, "With -diff, also send the commands that change the device")
+	fs.Parse(args)
+
+	frames, err := recording.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Recording Error: %v\n", err)
+		os.Exit(1)
+	}
+	all, unmatched := recording.Exchanges(frames)
+	var exchanges []recording.Exchange
+	for _, e := range all {
+		if *addr == "" || e.Addr == *addr {
+			exchanges = append(exchanges, e)
+		}
+	}
+	skipped := 0
+	for _, f := range unmatched {
+		if *addr == "" || f.Addr == *addr {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipping %d received frames that answer no command\n", skipped)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *diff {
+		runDiff(ctx, net.JoinHostPort(*host, strconv.Itoa(*port)), *timeout, exchanges, *writes)
+		return
+	}
+
+	player := recording.NewPlayer(exchanges, recording.PlayerConfig{
+		Latency: *latency,
+		Logger:  slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	})
+	listenAddr, err := player.Start(*listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Replaying %d exchanges of %s on %s\n", len(exchanges), *file, listenAddr)
+
+	<-ctx.Done()
+	player.Close()
+	st := player.Stats()
+	fmt.Printf("\n%d commands answered from the recording, %d unknown\n", st.Served, st.Unknown)
+}
+
+// runDiff prints the exchanges a live device answers differently and exits
+// with status 1 if there is any.
+func runDiff(ctx context.Context, addr string, timeout time.Duration, exchanges []recording.Exchange, writes bool) {
+	client := companytec.New(addr, companytec.WithTimeout(timeout))
+	if err := client.ConnectCtx(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Connect Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Disconnect()
+
+	results, err := recording.Diff(ctx, client, exchanges, recording.DiffConfig{Writes: writes})
+	matched, differ, skipped := 0, 0, 0
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Match():
+			matched++
+		default:
+			differ++
+			fmt.Printf("%s\n  recorded: %s\n", r.Command, r.Response)
+			if r.Err != nil {
+				fmt.Printf("  live:     error: %v\n", r.Err)
+			} else {
+				fmt.Printf("  live:     %s\n", r.Live)
+			}
+		}
+	}
+	fmt.Printf("%d matched, %d differ, %d skipped (writes)\n", matched, differ, skipped)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Diff Error: %v\n", err)
+		os.Exit(1)
+	}
+	if differ > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"companytec-client/pkg/simulator"
+)
+
+// runSimulate implements "companytec simulate": a fake device for demos and
+// development without hardware.
+func runSimulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	listen := fs.String("listen", ":2001", "Address to listen on")
+	nozzles := fs.Int("nozzles", 4, "Number of nozzles (01..N)")
+	price := fs.Int("price", 5890, "Unit price of every nozzle (4 digits)")
+	flow := fs.Int("flow", 50, "Flow rate in hundredths of a liter per second")
+	auto := fs.Bool("auto-authorize", false, "Start dispensing as soon as a nozzle is lifted")
+	demo := fs.Duration("demo", 0, "Fuel a random nozzle at this interval (0 disables)")
+	clockOffset := fs.Duration("clock-offset", 0, "Initial drift of the device clock")
+	identified := fs.Bool("identified", false, "Answer &A in the identified format (75 chars)")
+	fs.Parse(args)
+
+	cfg := simulator.Config{FlowRate: *flow, AutoAuthorize: *auto, ClockOffset: *clockOffset, Identified: *identified}
+	for i := 1; i <= *nozzles && i <= 32; i++ {
+		cfg.Nozzles = append(cfg.Nozzles, simulator.NozzleConfig{
+			Code:   fmt.Sprintf("%02X", i),
+			Prices: []int{*price, *price},
+		})
+	}
+	sim := simulator.New(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	addr, err := sim.Start(*listen)
+	if err != nil {
+		fmt.Printf("Simulator Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Companytec simulator listening on %s with %d nozzles\n", addr, len(cfg.Nozzles))
+
+	if *demo > 0 {
+		fmt.Printf("Demo mode: fueling a random nozzle every %s\n", *demo)
+		go sim.RunDemo(ctx, *demo)
+	}
+
+	<-ctx.Done()
+	fmt.Println("\nShutting down simulator...")
+	sim.Close()
+}
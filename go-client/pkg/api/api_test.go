@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// requests are the API requests answered from device responses.
+var requests = []struct{ method, path, body string }{
+	{"GET", "/status", ""},
+	{"GET", "/calendar", ""},
+	{"GET", "/supply", ""},
+	{"GET", "/supply/dual", ""},
+	{"GET", "/supply/1", ""},
+	{"GET", "/supplies?from=0&to=2", ""},
+	{"GET", "/pointers", ""},
+	{"GET", "/visualization", ""},
+	{"GET", "/total/01/L", ""},
+	{"GET", "/total/01/$", ""},
+	{"GET", "/price/01", ""},
+	{"GET", "/identifier", ""},
+	{"GET", "/identifiers?from=1&to=2", ""},
+	{"GET", "/identifiers/1", ""},
+	{"GET", "/clock", ""},
+	{"GET", "/fiscal/supply", ""},
+	{"GET", "/fiscal/supply?format=2", ""},
+	{"GET", "/fiscal/supplies", ""},
+	{"POST", "/preset", `{"nozzle":"01","value":"1000"}`},
+	{"POST", "/mode", `{"nozzle":"01","mode":"L"}`},
+	{"POST", "/price", `{"nozzle":"01","level":"0","price":"5879"}`},
+}
+
+// fakeDevice answers every command with answer(command).
+func fakeDevice(answer func(command string) string) companytec.Dialer {
+	return companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go func() {
+			defer device.Close()
+			dec := companytec.NewFrameDecoder(device, 0)
+			for {
+				command, err := dec.Next()
+				if err != nil {
+					return
+				}
+				if _, err := device.Write([]byte(answer(command))); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	})
+}
+
+// checkAPI serves every request with a device answering payload, wrapped in
+// a frame with a valid checksum when framed. The handlers may fail but must
+// not panic: the router has no recovery middleware, so a panic fails the
+// test.
+func checkAPI(t *testing.T, payload string, framed bool) {
+	answer := func(command string) string {
+		if !framed {
+			return payload
+		}
+		if !companytec.HasChecksum(command) {
+			return "(" + payload + ")"
+		}
+		return "(" + payload + companytec.Checksum("("+payload) + ")"
+	}
+	// Answers with the wrong header are skipped until the timeout, which is
+	// kept short: a device on a pipe answers at once.
+	client := companytec.New("device:2001",
+		companytec.WithDialer(fakeDevice(answer)),
+		companytec.WithTimeout(5*time.Millisecond),
+		companytec.WithReconnectPolicy(companytec.ReconnectPolicy{}),
+	)
+	defer client.Disconnect()
+
+	s := NewServer(client, WithLogger(slog.New(slog.DiscardHandler)))
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		if w.Code == http.StatusOK && !json.Valid(w.Body.Bytes()) {
+			t.Errorf("%s %s with device answering %q: 200 with body %q", req.method, req.path, payload, w.Body.String())
+		}
+	}
+}
+
+// corpusPayloads returns the bodies of the frames of the companytec golden
+// corpus, without delimiters and checksum.
+func corpusPayloads(t testing.TB) []string {
+	f, err := os.Open(filepath.Join("..", "companytec", "testdata", "frames.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var payloads []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		_, frame, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		body := strings.TrimSuffix(strings.TrimPrefix(frame, "("), ")")
+		payloads = append(payloads, body)
+		if len(body) > 2 {
+			payloads = append(payloads, body[:len(body)-2])
+		}
+	}
+	return payloads
+}
+
+func FuzzAPI(f *testing.F) {
+	for _, p := range corpusPayloads(f) {
+		f.Add(p, true)
+	}
+	f.Add("(0)", false)
+	f.Add("", true)
+	f.Add("garbage", false)
+	f.Fuzz(checkAPI)
+}
+
+func TestAPIProperties(t *testing.T) {
+	prop := func(payload string, framed bool) bool {
+		checkAPI(t, payload, framed)
+		return !t.Failed()
+	}
+	if err := quick.Check(prop, &quick.Config{MaxCount: 30}); err != nil {
+		t.Error(err)
+	}
+
+	// Device answers cut at every length
+	for _, p := range corpusPayloads(t) {
+		if len(p) > 60 {
+			p = p[:60]
+		}
+		for i := 0; i <= len(p); i += 7 {
+			checkAPI(t, p[:i], true)
+		}
+	}
+}
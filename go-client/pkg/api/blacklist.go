@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/blacklist"
+)
+
+func (s *Server) handleListBlacklist(c *gin.Context) {
+	d := s.device(c)
+	ids, known := d.blacklist.List()
+	c.JSON(http.StatusOK, gin.H{"ids": ids, "known": known})
+}
+
+func (s *Server) handleGetBlacklisted(c *gin.Context) {
+	d := s.device(c)
+	id := strings.ToUpper(c.Param("id"))
+	if !d.blacklist.Contains(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identifier not blacklisted"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+func (s *Server) handleBlacklist(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	if err := d.blacklist.Add(c.Request.Context(), c.Param("id")); err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": strings.ToUpper(c.Param("id"))})
+}
+
+func (s *Server) handleUnblacklist(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	if err := d.blacklist.Remove(c.Request.Context(), c.Param("id")); err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) handleClearBlacklist(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	if err := d.blacklist.Clear(c.Request.Context()); err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleSyncBlacklist applies a desired blacklist, sent as JSON or as CSV
+// (Content-Type text/csv). With ?dryRun=true it only returns the plan.
+func (s *Server) handleSyncBlacklist(c *gin.Context) {
+	d := s.device(c)
+	format := blacklist.FormatJSON
+	if strings.Contains(c.ContentType(), "csv") {
+		format = blacklist.FormatCSV
+	}
+	desired, err := blacklist.Parse(c.Request.Body, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("dryRun") == "true" {
+		plan, err := d.blacklist.Plan(desired)
+		if err != nil {
+			s.commandError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"results": plan})
+		return
+	}
+
+	if !s.ensureConnected(c) {
+		return
+	}
+	results, err := d.blacklist.Sync(c.Request.Context(), desired)
+	if results == nil {
+		s.commandError(c, err)
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{"results": results})
+}
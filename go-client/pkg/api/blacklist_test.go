@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"companytec-client/pkg/blacklist"
+	"companytec-client/pkg/simulator"
+)
+
+func TestBlacklistEndpoints(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	do(t, s, "PUT", "/blacklist/b328000000000001", "", http.StatusOK, nil)
+	do(t, s, "GET", "/blacklist/B328000000000001", "", http.StatusOK, nil)
+	do(t, s, "GET", "/blacklist/B328000000000002", "", http.StatusNotFound, nil)
+	do(t, s, "PUT", "/blacklist/B328", "", http.StatusBadRequest, nil)
+	if got := sim.Blacklist(); !slices.Equal(got, []string{"B328000000000001"}) {
+		t.Errorf("device blacklist %v", got)
+	}
+
+	do(t, s, "DELETE", "/blacklist/B328000000000001", "", http.StatusNoContent, nil)
+	var list struct {
+		IDs   []string
+		Known bool
+	}
+	do(t, s, "GET", "/blacklist", "", http.StatusOK, &list)
+	if len(list.IDs) != 0 || list.Known {
+		t.Errorf("list %+v", list)
+	}
+	do(t, s, "DELETE", "/blacklist", "", http.StatusNoContent, nil)
+	do(t, s, "GET", "/blacklist", "", http.StatusOK, &list)
+	if !list.Known {
+		t.Error("blacklist not known after clearing it")
+	}
+}
+
+func TestSyncBlacklist(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	sync := func(query string) []blacklist.Result {
+		r := httptest.NewRequest("POST", "/blacklist/sync"+query,
+			strings.NewReader("id\nB328000000000001\nB328000000000002\n"))
+		r.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("sync%s: %d %s", query, w.Code, w.Body.String())
+		}
+		var resp struct{ Results []blacklist.Result }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Results
+	}
+
+	if plan := sync("?dryRun=true"); len(plan) != 3 || plan[0].Action != blacklist.ActionClear {
+		t.Errorf("dry run %+v", plan)
+	}
+	if len(sim.Blacklist()) != 0 {
+		t.Error("dry run changed the device")
+	}
+	if results := sync(""); len(results) != 3 {
+		t.Errorf("sync %+v", results)
+	}
+	if got := sim.Blacklist(); len(got) != 2 {
+		t.Errorf("device blacklist %v", got)
+	}
+
+	do(t, s, "POST", "/blacklist/sync", `["B328"]`, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/cache"
+	"companytec-client/pkg/companytec"
+)
+
+// The read helpers go through the device cache when there is one, and set
+// the cache headers of the response.
+
+func (d *device) status(c *gin.Context) ([]companytec.NozzleStatus, error) {
+	if d.cache == nil {
+		return d.client.StatusCtx(c.Request.Context())
+	}
+	v, info, err := d.cache.Status(c.Request.Context())
+	cacheHeaders(c, info)
+	return v, err
+}
+
+func (d *device) visualization(c *gin.Context) ([]companytec.VisualizationEntry, error) {
+	if d.cache == nil {
+		return d.client.VisualizationCtx(c.Request.Context())
+	}
+	v, info, err := d.cache.Visualization(c.Request.Context())
+	cacheHeaders(c, info)
+	return v, err
+}
+
+func (d *device) total(c *gin.Context, nozzle, mode string) (*companytec.Total, error) {
+	if d.cache == nil {
+		return d.client.TotalCtx(c.Request.Context(), nozzle, mode)
+	}
+	v, info, err := d.cache.Total(c.Request.Context(), nozzle, mode)
+	cacheHeaders(c, info)
+	return v, err
+}
+
+func (d *device) price(c *gin.Context, nozzle, mode string) (*companytec.Price, error) {
+	if d.cache == nil {
+		return d.client.PriceCtx(c.Request.Context(), nozzle, mode)
+	}
+	v, info, err := d.cache.Price(c.Request.Context(), nozzle, mode)
+	cacheHeaders(c, info)
+	return v, err
+}
+
+// The write helpers go through the device cache when there is one, which
+// invalidates the nozzle they touch whether the write succeeded or not.
+
+func (d *device) setPreset(c *gin.Context, nozzle, value string) (string, error) {
+	if d.cache == nil {
+		return d.client.SetPresetCtx(c.Request.Context(), nozzle, value)
+	}
+	return d.cache.SetPreset(c.Request.Context(), nozzle, value)
+}
+
+func (d *device) setPresetIdentified(c *gin.Context, p companytec.IdentifiedPreset) (string, error) {
+	if d.cache == nil {
+		return d.client.SetPresetIdentifiedCtx(c.Request.Context(), p)
+	}
+	return d.cache.SetPresetIdentified(c.Request.Context(), p)
+}
+
+func (d *device) setOperatingMode(c *gin.Context, nozzle, mode string) (string, error) {
+	if d.cache == nil {
+		return d.client.SetOperatingModeCtx(c.Request.Context(), nozzle, mode)
+	}
+	return d.cache.SetOperatingMode(c.Request.Context(), nozzle, mode)
+}
+
+func (d *device) changePrice(c *gin.Context, nozzle, level, price string) (string, error) {
+	if d.cache == nil {
+		return d.client.ChangePriceCtx(c.Request.Context(), nozzle, level, price)
+	}
+	return d.cache.ChangePrice(c.Request.Context(), nozzle, level, price)
+}
+
+// cacheHeaders reports the age of the value served: Age in whole seconds as
+// HTTP caches do, X-Cache-Age-Ms since TTLs are well under a second.
+func cacheHeaders(c *gin.Context, info cache.Info) {
+	if info.Hit {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	c.Header("Age", strconv.FormatInt(int64(info.Age.Seconds()), 10))
+	c.Header("X-Cache-Age-Ms", strconv.FormatInt(info.Age.Milliseconds(), 10))
+}
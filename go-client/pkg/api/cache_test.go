@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/cache"
+	"companytec-client/pkg/simulator"
+)
+
+// xCache serves a request and returns its X-Cache header.
+func xCache(t *testing.T, s *Server, method, path, body string) string {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+	}
+	return w.Header().Get("X-Cache")
+}
+
+func TestCache(t *testing.T) {
+	s := newSimulatedServer(t, simulator.New(simulator.Config{}), WithCache(cache.Config{
+		StatusTTL: time.Minute, PriceTTL: time.Minute,
+	}))
+
+	for _, want := range []string{"MISS", "HIT"} {
+		if got := xCache(t, s, "GET", "/status", ""); got != want {
+			t.Errorf("status X-Cache %q, want %q", got, want)
+		}
+		if got := xCache(t, s, "GET", "/price/01", ""); got != want {
+			t.Errorf("price X-Cache %q, want %q", got, want)
+		}
+	}
+
+	// Every write endpoint drops the values it makes stale
+	writes := []struct{ path, body string }{
+		{"/price", `{"nozzle":"01","level":"0","price":"6199"}`},
+		{"/mode", `{"nozzle":"01","mode":"B"}`},
+		{"/mode", `{"nozzle":"01","mode":"L"}`},
+		{"/preset", `{"nozzle":"01","value":"1000"}`},
+		{"/preset/identified", `{"nozzle":"02","id":"ABCDEF0123456789","type":1,"authorize":true,"value":2000,"timeout":30,"presetType":"$"}`},
+	}
+	for _, w := range writes {
+		xCache(t, s, "GET", "/status", "")
+		xCache(t, s, "POST", w.path, w.body)
+		if got := xCache(t, s, "GET", "/status", ""); got != "MISS" {
+			t.Errorf("status X-Cache %q after POST %s", got, w.path)
+		}
+	}
+	if got := xCache(t, s, "GET", "/price/01", ""); got != "MISS" {
+		t.Errorf("price X-Cache %q after the price change", got)
+	}
+	if st := s.def.cache.Stats(); st.Invalidations != uint64(len(writes)) {
+		t.Errorf("%d invalidations, want one per write", st.Invalidations)
+	}
+}
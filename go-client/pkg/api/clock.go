@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/clocksync"
+)
+
+// measurementJSON renders a clock measurement with durations in milliseconds.
+func measurementJSON(m clocksync.Measurement) gin.H {
+	return gin.H{
+		"device":      m.Device,
+		"host":        m.Host,
+		"skewMs":      m.Skew.Milliseconds(),
+		"roundTripMs": m.RoundTrip.Milliseconds(),
+	}
+}
+
+func reportJSON(r clocksync.Report) gin.H {
+	h := measurementJSON(r.Measurement)
+	h["checkedAt"] = r.CheckedAt
+	h["corrected"] = r.Corrected
+	if r.Error != "" {
+		h["error"] = r.Error
+	}
+	return h
+}
+
+// handleClock measures the device clock skew without correcting it.
+func (s *Server) handleClock(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	m, err := d.clock.Measure(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, measurementJSON(m))
+}
+
+type SetClockRequest struct {
+	// Time is the wall time to set, as written (its offset is not applied).
+	Time time.Time `json:"time" binding:"required"`
+	// Calendar uses the legacy &H command (minute resolution).
+	Calendar bool `json:"calendar"`
+}
+
+func (s *Server) handleSetClock(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	var req SetClockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resp string
+	var err error
+	if req.Calendar {
+		resp, err = d.client.SetCalendarCtx(c.Request.Context(), req.Time)
+	} else {
+		resp, err = d.client.SetClockExtendedCtx(c.Request.Context(), req.Time)
+	}
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+func (s *Server) handleClockSyncStatus(c *gin.Context) {
+	d := s.device(c)
+	st := d.clock.Status()
+	h := gin.H{
+		"intervalSec": st.Interval.Seconds(),
+		"thresholdMs": st.Threshold.Milliseconds(),
+		"corrections": st.Corrections,
+	}
+	if st.Last != nil {
+		h["last"] = reportJSON(*st.Last)
+	}
+	if st.LastCorrection != nil {
+		h["lastCorrection"] = st.LastCorrection
+	}
+	c.JSON(http.StatusOK, h)
+}
+
+// handleClockSync sets the device clock to the host time now.
+func (s *Server) handleClockSync(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	report, err := d.clock.Sync(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reportJSON(report))
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/blacklist"
+	"companytec-client/pkg/cache"
+	"companytec-client/pkg/clocksync"
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/fiscal"
+	"companytec-client/pkg/fleet"
+	"companytec-client/pkg/journal"
+	"companytec-client/pkg/monitor"
+)
+
+// deviceKey is the gin context key of the device a request is for.
+const deviceKey = "device"
+
+// device is a device served by the API, with its services.
+type device struct {
+	client    *companytec.Client
+	clock     *clocksync.Service
+	blacklist *blacklist.Manager
+	auditor   *fiscal.Auditor
+	journal   *journal.Journal
+	cache     *cache.Cache // Nil unless WithCache
+	// cancels unregister the listeners setupDevice added to the client
+	cancels []func()
+
+	monitor     *monitor.Monitor
+	ownMonitor  bool
+	streamMu    sync.Mutex
+	streams     int
+	stopMonitor context.CancelFunc
+}
+
+// setDefaults creates the services that were not given, logging on logger
+// unless it is nil.
+func (d *device) setDefaults(logger *slog.Logger) {
+	if d.clock == nil {
+		d.clock = clocksync.New(d.client, clocksync.Config{Logger: logger})
+	}
+	if d.blacklist == nil {
+		d.blacklist, _ = blacklist.New(d.client, "")
+	}
+	if d.auditor == nil {
+		d.auditor = fiscal.NewAuditor(maxAuditEntries)
+	}
+	if d.monitor == nil {
+		d.monitor = monitor.New(d.client, monitor.Config{Supplies: true, Logger: logger})
+		d.ownMonitor = true
+	}
+}
+
+// setupDevice creates the services of d that were not given, its cache and
+// its metrics.
+func (s *Server) setupDevice(id string, d *device) {
+	d.setDefaults(s.deviceLogger(id))
+	if d.journal != nil {
+		d.cancels = append(d.cancels, d.journal.Observe(d.client, s.deviceLogger(id)))
+	}
+	if s.cache != nil {
+		d.cache = cache.New(d.client, *s.cache)
+	}
+	if s.metrics != nil {
+		d.cancels = append(d.cancels, s.metrics.Instrument(id, d.client))
+	}
+}
+
+// openState opens the blacklist state and the journal of fleet device id in
+// the WithFleetState directory and, with WithFleetCollector, collects the
+// device supplies into the journal, audited, as -journal does for the default
+// device. Nothing is kept open when it fails.
+func (s *Server) openState(id string, d *device) error {
+	// The journal is the only state holding a file open, so it is opened
+	// last
+	bl, err := blacklist.New(d.client, filepath.Join(s.stateDir, id+".blacklist.json"))
+	if err != nil {
+		return err
+	}
+	j, err := journal.Open(filepath.Join(s.stateDir, id+".journal"))
+	if err != nil {
+		return err
+	}
+	auditor := fiscal.NewAuditor(maxAuditEntries)
+	if err := auditor.LoadJournal(j); err != nil {
+		j.Close()
+		return err
+	}
+	d.blacklist, d.journal, d.auditor = bl, j, auditor
+	d.cancels = append(d.cancels, func() { j.Close() })
+
+	if s.collect {
+		ctx, cancel := context.WithCancel(context.Background())
+		collector := companytec.NewSupplyCollector(d.client, fiscal.NewSink(j, auditor), 0)
+		go collector.Run(ctx)
+		d.cancels = append(d.cancels, cancel)
+	}
+	return nil
+}
+
+// close releases the services of a device removed from the fleet, in the
+// reverse order of their setup.
+func (d *device) close() {
+	for i := len(d.cancels) - 1; i >= 0; i-- {
+		d.cancels[i]()
+	}
+	d.streamMu.Lock()
+	if d.ownMonitor && d.streams > 0 {
+		d.stopMonitor()
+	}
+	d.streamMu.Unlock()
+}
+
+// device returns the device of the request, set by defaultDevice or
+// withDevice.
+func (s *Server) device(c *gin.Context) *device {
+	return c.MustGet(deviceKey).(*device)
+}
+
+// defaultDevice is the middleware of the root routes.
+func (s *Server) defaultDevice(c *gin.Context) {
+	if s.def == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no default device, use /devices/:id"})
+		return
+	}
+	c.Set(deviceKey, s.def)
+}
+
+// withDevice is the middleware of the /devices/:device routes.
+func (s *Server) withDevice(c *gin.Context) {
+	var fd *fleet.Device
+	if s.fleet != nil {
+		fd, _ = s.fleet.Get(c.Param("device"))
+	}
+	if fd == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown device"})
+		return
+	}
+
+	d, err := s.fleetDevice(fd)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "device state unavailable"})
+		c.Error(err)
+		return
+	}
+	c.Set(deviceKey, d)
+}
+
+// fleetDevice returns the device of fd, created on first use. Opening its
+// state is retried on the next use when it fails.
+func (s *Server) fleetDevice(fd *fleet.Device) (*device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[fd]; ok {
+		return d, nil
+	}
+	d := &device{client: fd.Client}
+	if s.stateDir != "" {
+		if err := s.openState(fd.ID, d); err != nil {
+			return nil, fmt.Errorf("device %s state: %w", fd.ID, err)
+		}
+	}
+	s.setupDevice(fd.ID, d)
+	s.devices[fd] = d
+	return d, nil
+}
+
+// removeDevice releases the services of a device removed from the fleet.
+func (s *Server) removeDevice(fd *fleet.Device) {
+	s.mu.Lock()
+	d, ok := s.devices[fd]
+	delete(s.devices, fd)
+	s.mu.Unlock()
+	if ok {
+		d.close()
+	}
+}
+
+// DeviceInfo describes a device in the fleet overview.
+type DeviceInfo struct {
+	ID     string            `json:"id"`
+	Addr   string            `json:"addr"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Health fleet.Health      `json:"health"`
+}
+
+func deviceInfo(d *fleet.Device) DeviceInfo {
+	return DeviceInfo{ID: d.ID, Addr: d.Addr, Labels: d.Labels, Health: d.Health()}
+}
+
+// handleDevices lists the fleet devices with their health. ?label=key=value,
+// repeatable, selects devices by label.
+func (s *Server) handleDevices(c *gin.Context) {
+	if s.fleet == nil {
+		c.JSON(http.StatusOK, gin.H{"devices": []DeviceInfo{}, "healthy": 0, "total": 0})
+		return
+	}
+	selector := make(map[string]string)
+	for _, l := range c.QueryArray("label") {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "label must be key=value"})
+			return
+		}
+		selector[k] = v
+	}
+
+	devices := []DeviceInfo{}
+	healthy := 0
+	for _, d := range s.fleet.List(selector) {
+		info := deviceInfo(d)
+		if info.Health.Healthy() {
+			healthy++
+		}
+		devices = append(devices, info)
+	}
+	c.JSON(http.StatusOK, gin.H{"devices": devices, "healthy": healthy, "total": len(devices)})
+}
+
+func (s *Server) handleDevice(c *gin.Context) {
+	d, ok := s.fleet.Get(c.Param("device"))
+	if !ok {
+		// Removed since withDevice
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown device"})
+		return
+	}
+	c.JSON(http.StatusOK, deviceInfo(d))
+}
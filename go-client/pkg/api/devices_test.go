@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/fleet"
+	"companytec-client/pkg/journal"
+	"companytec-client/pkg/metrics"
+	"companytec-client/pkg/simulator"
+)
+
+// fleetOf returns a connected registry with a simulated device per ID.
+func fleetOf(t *testing.T, sims map[string]*simulator.Simulator) *fleet.Registry {
+	t.Helper()
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, addr string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sims[strings.TrimSuffix(addr, ":2001")].ServeConn(device)
+		return client, nil
+	})
+	reg := fleet.NewRegistry(companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	for id := range sims {
+		if _, err := reg.Add(fleet.DeviceConfig{ID: id, Addr: id + ":2001", Labels: map[string]string{"site": id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := reg.ConnectAll(context.Background()); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+func newFleetServer(t *testing.T, reg *fleet.Registry, opts ...Option) *Server {
+	t.Helper()
+	opts = append([]Option{WithLogger(slog.New(slog.DiscardHandler)), WithFleet(reg)}, opts...)
+	return NewServer(nil, opts...)
+}
+
+func TestFleetEndpoints(t *testing.T) {
+	a := simulator.New(simulator.Config{})
+	reg := fleetOf(t, map[string]*simulator.Simulator{"a": a, "b": simulator.New(simulator.Config{})})
+	s := newFleetServer(t, reg)
+
+	var overview struct {
+		Devices []DeviceInfo
+		Healthy int
+		Total   int
+	}
+	do(t, s, "GET", "/devices", "", http.StatusOK, &overview)
+	if overview.Total != 2 || overview.Healthy != 2 || overview.Devices[0].ID != "a" {
+		t.Errorf("overview %+v", overview)
+	}
+	do(t, s, "GET", "/devices?label=site=b", "", http.StatusOK, &overview)
+	if overview.Total != 1 || overview.Devices[0].ID != "b" {
+		t.Errorf("site b %+v", overview)
+	}
+	do(t, s, "GET", "/devices?label=site", "", http.StatusBadRequest, nil)
+
+	// Each device is served by its own client
+	a.Lift("01")
+	var status struct{ Nozzles []companytec.NozzleStatus }
+	do(t, s, "GET", "/devices/a/status", "", http.StatusOK, &status)
+	if status.Nozzles[0].Code != companytec.StatusWaiting {
+		t.Errorf("device a status %+v", status.Nozzles[0])
+	}
+	do(t, s, "GET", "/devices/b/status", "", http.StatusOK, &status)
+	if status.Nozzles[0].Code != companytec.StatusAvailable {
+		t.Errorf("device b status %+v", status.Nozzles[0])
+	}
+	var info DeviceInfo
+	do(t, s, "GET", "/devices/a", "", http.StatusOK, &info)
+	if info.Addr != "a:2001" || !info.Health.Healthy() {
+		t.Errorf("device a %+v", info)
+	}
+	do(t, s, "GET", "/devices/c/status", "", http.StatusNotFound, nil)
+	// No default device
+	do(t, s, "GET", "/status", "", http.StatusNotFound, nil)
+	// Without fleet state, fleet devices have no journal
+	do(t, s, "GET", "/devices/a/transactions", "", http.StatusNotFound, nil)
+}
+
+func TestFleetState(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true})
+	reg := fleetOf(t, map[string]*simulator.Simulator{"a": sim})
+	dir := t.TempDir()
+	s := newFleetServer(t, reg, WithFleetState(dir), WithFleetCollector())
+
+	do(t, s, "POST", "/devices/a/price", `{"nozzle":"01","level":"0","price":"6199"}`, http.StatusOK, nil)
+	do(t, s, "PUT", "/devices/a/blacklist/ABCDEF0123456789", "", http.StatusOK, nil)
+	if _, err := os.Stat(filepath.Join(dir, "a.blacklist.json")); err != nil {
+		t.Errorf("blacklist state: %v", err)
+	}
+
+	// The collector stores the supplies of the device in its journal
+	sim.Lift("02")
+	sim.Dispense("02", 500)
+	sim.Hang("02")
+	var page struct{ Transactions []journal.Entry }
+	deadline := time.Now().Add(5 * time.Second)
+	for len(page.Transactions) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		do(t, s, "GET", "/devices/a/transactions?kind=supply", "", http.StatusOK, &page)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].Supply.Volume != 500 {
+		t.Fatalf("supplies %+v", page.Transactions)
+	}
+	do(t, s, "GET", "/devices/a/transactions?kind=price_change", "", http.StatusOK, &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].Value != "6199" {
+		t.Errorf("price changes %+v", page.Transactions)
+	}
+	var audit struct{ Records []any }
+	do(t, s, "GET", "/devices/a/fiscal/supplies", "", http.StatusOK, &audit)
+	if len(audit.Records) != 1 {
+		t.Errorf("audited %+v", audit)
+	}
+}
+
+func TestFleetStateWithoutCollector(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true})
+	reg := fleetOf(t, map[string]*simulator.Simulator{"a": sim})
+	s := newFleetServer(t, reg, WithFleetState(t.TempDir()))
+
+	sim.Lift("02")
+	sim.Dispense("02", 500)
+	sim.Hang("02")
+	time.Sleep(100 * time.Millisecond)
+	// Neither collected nor acknowledged
+	var page struct{ Transactions []journal.Entry }
+	do(t, s, "GET", "/devices/a/transactions?kind=supply", "", http.StatusOK, &page)
+	if len(page.Transactions) != 0 {
+		t.Errorf("supplies collected %+v", page.Transactions)
+	}
+	var supply struct{ Parsed companytec.SupplyRecord }
+	do(t, s, "GET", "/devices/a/supply", "", http.StatusOK, &supply)
+	if supply.Parsed.Volume != 500 {
+		t.Errorf("supply %+v", supply.Parsed)
+	}
+}
+
+func TestFleetStateOpenFailure(t *testing.T) {
+	reg := fleetOf(t, map[string]*simulator.Simulator{"a": simulator.New(simulator.Config{})})
+	dir := t.TempDir()
+	// A directory where the journal file goes
+	if err := os.Mkdir(filepath.Join(dir, "a.journal"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := newFleetServer(t, reg, WithFleetState(dir))
+
+	do(t, s, "GET", "/devices/a/transactions", "", http.StatusInternalServerError, nil)
+	if len(s.devices) != 0 {
+		t.Errorf("%d devices kept", len(s.devices))
+	}
+	// Retried on the next request
+	if err := os.Remove(filepath.Join(dir, "a.journal")); err != nil {
+		t.Fatal(err)
+	}
+	do(t, s, "GET", "/devices/a/transactions", "", http.StatusOK, nil)
+}
+
+func TestFleetRemove(t *testing.T) {
+	reg := fleetOf(t, map[string]*simulator.Simulator{"a": simulator.New(simulator.Config{})})
+	dir := t.TempDir()
+	s := newFleetServer(t, reg, WithMetrics(metrics.New()), WithFleetState(dir))
+
+	do(t, s, "GET", "/devices/a/status", "", http.StatusOK, nil)
+	if !strings.Contains(scrape(t, s), `device="a"`) {
+		t.Fatal("device a not instrumented")
+	}
+
+	reg.Remove("a")
+	if strings.Contains(scrape(t, s), `device="a"`) {
+		t.Error("series of the removed device kept")
+	}
+	if len(s.devices) != 0 {
+		t.Errorf("%d devices kept", len(s.devices))
+	}
+	do(t, s, "GET", "/devices/a/status", "", http.StatusNotFound, nil)
+
+	// The journal was closed: the device can be registered again
+	if _, err := reg.Add(fleet.DeviceConfig{ID: "a", Addr: "a:2001"}); err != nil {
+		t.Fatal(err)
+	}
+	do(t, s, "GET", "/devices/a/transactions", "", http.StatusOK, nil)
+}
+
+func scrape(t *testing.T, s *Server) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"companytec-client/pkg/monitor"
+)
+
+const (
+	// streamBuffer is the number of events a slow stream client can lag
+	// behind before events are dropped for it.
+	streamBuffer = 64
+	// streamHeartbeat is the interval of SSE keep-alive comments and
+	// WebSocket pings, short enough for idle proxies not to close streams.
+	streamHeartbeat = 15 * time.Second
+	// streamWriteTimeout bounds a write to a stream client.
+	streamWriteTimeout = 10 * time.Second
+)
+
+var knownEventTypes = map[monitor.EventType]bool{
+	monitor.NozzleAppeared:    true,
+	monitor.NozzleDisappeared: true,
+	monitor.NozzleLifted:      true,
+	monitor.FuelingStarted:    true,
+	monitor.FuelingProgress:   true,
+	monitor.FuelingFinished:   true,
+	monitor.SupplyCompleted:   true,
+	monitor.NozzleBlocked:     true,
+	monitor.NozzleUnblocked:   true,
+}
+
+// EventFilter selects the events a stream client receives. Empty lists match
+// everything. WebSocket clients can send one as a JSON message to replace
+// their filter.
+type EventFilter struct {
+	Nozzles []string            `json:"nozzles"`
+	Types   []monitor.EventType `json:"types"`
+}
+
+// parseFilter reads ?nozzle= and ?type=, given repeated or comma separated.
+func parseFilter(c *gin.Context) (EventFilter, bool) {
+	var f EventFilter
+	for _, v := range c.QueryArray("nozzle") {
+		f.Nozzles = append(f.Nozzles, strings.Split(v, ",")...)
+	}
+	for _, v := range c.QueryArray("type") {
+		for _, t := range strings.Split(v, ",") {
+			f.Types = append(f.Types, monitor.EventType(t))
+		}
+	}
+	return f, f.valid()
+}
+
+func (f *EventFilter) valid() bool {
+	for i, n := range f.Nozzles {
+		f.Nozzles[i] = strings.ToUpper(strings.TrimSpace(n))
+	}
+	for _, t := range f.Types {
+		if !knownEventTypes[t] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f EventFilter) match(ev monitor.Event) bool {
+	if len(f.Nozzles) > 0 && !contains(f.Nozzles, ev.Nozzle) {
+		return false
+	}
+	return len(f.Types) == 0 || contains(f.Types, ev.Type)
+}
+
+func contains[T comparable](list []T, v T) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe adds a stream client. The device's own monitor runs while at
+// least one client is connected; the returned function removes the client.
+func (d *device) subscribe() (*monitor.Subscription, func()) {
+	sub := d.monitor.Subscribe(streamBuffer)
+	d.streamMu.Lock()
+	d.streams++
+	if d.ownMonitor && d.streams == 1 {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.stopMonitor = cancel
+		go d.monitor.Run(ctx)
+	}
+	d.streamMu.Unlock()
+
+	return sub, func() {
+		sub.Close()
+		d.streamMu.Lock()
+		d.streams--
+		if d.ownMonitor && d.streams == 0 {
+			d.stopMonitor()
+		}
+		d.streamMu.Unlock()
+	}
+}
+
+// handleEvents streams monitor events as Server-Sent Events, named after the
+// event type, after a "snapshot" event with the state of every nozzle.
+func (s *Server) handleEvents(c *gin.Context) {
+	d := s.device(c)
+	filter, ok := parseFilter(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown event type"})
+		return
+	}
+	sub, done := d.subscribe()
+	defer done()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("snapshot", gin.H{"nozzles": d.monitor.Nozzles()})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case ev, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			if filter.match(ev) {
+				c.SSEvent(string(ev.Type), ev)
+			}
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		}
+	})
+}
+
+// upgrader returns the WebSocket upgrader checking origins against
+// WithAllowedOrigins.
+func (s *Server) upgrader() *websocket.Upgrader {
+	u := &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+	}
+	if len(s.origins) > 0 {
+		// Without CheckOrigin the upgrader requires the same origin
+		u.CheckOrigin = s.checkOrigin
+	}
+	return u
+}
+
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if strings.TrimSpace(allowed) == "*" || strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(allowed), "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebSocket streams monitor events as JSON messages, after a
+// {"type": "snapshot"} message with the state of every nozzle. The client
+// can send an EventFilter to replace the filter given in the query.
+func (s *Server) handleWebSocket(c *gin.Context) {
+	d := s.device(c)
+	filter, ok := parseFilter(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown event type"})
+		return
+	}
+	conn, err := s.upgrader().Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade already answered the request
+		return
+	}
+	defer conn.Close()
+	sub, done := d.subscribe()
+	defer done()
+
+	filters := make(chan EventFilter)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(filters)
+		conn.SetReadLimit(4096)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f EventFilter
+			if json.Unmarshal(data, &f) != nil || !f.valid() {
+				continue
+			}
+			select {
+			case filters <- f:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	write := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+	if write(gin.H{"type": "snapshot", "nozzles": d.monitor.Nozzles()}) != nil {
+		return
+	}
+
+	ping := time.NewTicker(streamHeartbeat)
+	defer ping.Stop()
+	for {
+		select {
+		case f, ok := <-filters:
+			if !ok {
+				// The client went away
+				return
+			}
+			filter = f
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if filter.match(ev) && write(ev) != nil {
+				return
+			}
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
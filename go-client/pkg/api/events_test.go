@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"companytec-client/pkg/monitor"
+	"companytec-client/pkg/simulator"
+)
+
+// streamServer serves sim with a monitor polling every few milliseconds.
+func streamServer(t *testing.T, sim *simulator.Simulator, opts ...Option) *httptest.Server {
+	t.Helper()
+	client := simulated(t, sim)
+	m := monitor.New(client, monitor.Config{StatusInterval: 5 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(ctx)
+
+	opts = append([]Option{WithLogger(slog.New(slog.DiscardHandler)), WithMonitor(m)}, opts...)
+	srv := httptest.NewServer(NewServer(client, opts...).router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dialWS(t *testing.T, srv *httptest.Server, query, origin string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws"+query, header)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestEventFilter(t *testing.T) {
+	f := EventFilter{Nozzles: []string{" 0a"}, Types: []monitor.EventType{monitor.FuelingStarted}}
+	if !f.valid() {
+		t.Fatal("valid filter refused")
+	}
+	if !f.match(monitor.Event{Nozzle: "0A", Type: monitor.FuelingStarted}) {
+		t.Error("matching event filtered out")
+	}
+	if f.match(monitor.Event{Nozzle: "0A", Type: monitor.NozzleLifted}) || f.match(monitor.Event{Nozzle: "01", Type: monitor.FuelingStarted}) {
+		t.Error("event of another type or nozzle matched")
+	}
+	if (EventFilter{}).match(monitor.Event{Nozzle: "01"}) == false {
+		t.Error("empty filter does not match")
+	}
+	if bad := (EventFilter{Types: []monitor.EventType{"refueled"}}); bad.valid() {
+		t.Error("unknown type accepted")
+	}
+}
+
+func TestEventsSSE(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	srv := streamServer(t, sim)
+
+	resp, err := http.Get(srv.URL + "/events?type=nozzle_lifted&nozzle=02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("content type %q", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	var events []string
+	for lines.Scan() && len(events) < 2 {
+		if name, ok := strings.CutPrefix(lines.Text(), "event:"); ok {
+			events = append(events, name)
+			if name == "snapshot" {
+				sim.Lift("01")
+				sim.Lift("02")
+			}
+		}
+		if strings.HasPrefix(lines.Text(), "data:") && len(events) == 2 && !strings.Contains(lines.Text(), `"nozzle":"02"`) {
+			t.Errorf("event of another nozzle: %s", lines.Text())
+		}
+	}
+	if len(events) != 2 || events[0] != "snapshot" || events[1] != "nozzle_lifted" {
+		t.Errorf("events %v", events)
+	}
+
+	bad, err := http.Get(srv.URL + "/events?type=refueled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown type: status %d", bad.StatusCode)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	srv := streamServer(t, sim)
+	conn, _, err := dialWS(t, srv, "?type=fueling_started", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var snapshot struct {
+		Type    string
+		Nozzles []monitor.NozzleState
+	}
+	if err := conn.ReadJSON(&snapshot); err != nil || snapshot.Type != "snapshot" {
+		t.Fatalf("snapshot %+v, %v", snapshot, err)
+	}
+
+	// Replace the filter, then wait for the monitor to see the lift
+	if err := conn.WriteJSON(EventFilter{Types: []monitor.EventType{monitor.NozzleLifted}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	sim.Lift("03")
+	var ev monitor.Event
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != monitor.NozzleLifted || ev.Nozzle != "03" {
+		t.Errorf("event %+v", ev)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	same := streamServer(t, sim)
+	tests := []struct {
+		name   string
+		srv    *httptest.Server
+		origin string
+		ok     bool
+	}{
+		{"no origin", same, "", true},
+		{"same origin", same, same.URL, true},
+		{"other origin", same, "https://evil.example", false},
+		{"allowed", streamServer(t, sim, WithAllowedOrigins("https://dash.example/")), "https://dash.example", true},
+		{"not allowed", streamServer(t, sim, WithAllowedOrigins("https://dash.example")), "https://evil.example", false},
+		{"any", streamServer(t, sim, WithAllowedOrigins("*")), "https://evil.example", true},
+	}
+	for _, tt := range tests {
+		_, resp, err := dialWS(t, tt.srv, "", tt.origin)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && (err == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Errorf("%s: connected", tt.name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/fiscal"
+)
+
+// maxAuditEntries bounds the PAF records kept for GET /fiscal/supplies.
+const maxAuditEntries = 1000
+
+// handleFiscalSupply reads the current supply in a PAF format (?format=1 or
+// 2, default 1), checks its totalizers against the previous supply of the
+// nozzle and keeps it for GET /fiscal/supplies.
+func (s *Server) handleFiscalSupply(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+
+	var rec *companytec.PAFSupplyRecord
+	var err error
+	switch c.DefaultQuery("format", "1") {
+	case "1":
+		rec, err = d.client.SupplyPAF1Ctx(c.Request.Context())
+	case "2":
+		rec, err = d.client.SupplyPAF2Ctx(c.Request.Context())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 1 or 2"})
+		return
+	}
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	if rec == nil {
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+	entry, _ := d.auditor.Add(*rec)
+	c.JSON(http.StatusOK, entry)
+}
+
+// handleFiscalSupplies returns the records audited so far, read through the
+// API or captured by the collector feeding the auditor (WithAuditor), oldest
+// first, with the totalizer issues found in each.
+func (s *Server) handleFiscalSupplies(c *gin.Context) {
+	d := s.device(c)
+	entries := d.auditor.Entries()
+	issues := 0
+	for _, e := range entries {
+		issues += len(e.Issues)
+	}
+	c.JSON(http.StatusOK, gin.H{"records": entries, "issues": issues})
+}
+
+type ValidateRequest struct {
+	// Records and Frames (raw &A2/&A3 responses) are checked together,
+	// records first, in supply order.
+	Records []companytec.PAFSupplyRecord `json:"records"`
+	Frames  []string                     `json:"frames"`
+}
+
+// handleFiscalValidate checks the totalizer continuity of records supplied by
+// the caller, e.g. exported from the back office, without touching the device.
+func (s *Server) handleFiscalValidate(c *gin.Context) {
+	var req ValidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records := req.Records
+	for i, frame := range req.Frames {
+		rec, err := companytec.ParseSupplyPAF(frame)
+		if err != nil || rec == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid frame", "index": i})
+			return
+		}
+		records = append(records, *rec)
+	}
+	c.JSON(http.StatusOK, gin.H{"issues": fiscal.Validate(records)})
+}
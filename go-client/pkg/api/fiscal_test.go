@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/fiscal"
+	"companytec-client/pkg/simulator"
+)
+
+func TestFiscalSupply(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true})
+	auditor := fiscal.NewAuditor(0)
+	s := newSimulatedServer(t, sim, WithAuditor(auditor))
+
+	var entry fiscal.Entry
+	do(t, s, "GET", "/fiscal/supply", "", http.StatusOK, &entry)
+	if entry.Nozzle != "" {
+		t.Errorf("supply %+v with an empty memory", entry)
+	}
+
+	// A supply collected elsewhere, then one read through the API
+	auditor.AddSupply(companytec.SupplyRecord{Nozzle: "01", Record: 1, Volume: 900, FinalTotal: 900})
+	sim.Lift("01")
+	sim.Dispense("01", 1000)
+	sim.Hang("01")
+	sim.Lift("01")
+	sim.Dispense("01", 500)
+	sim.Hang("01")
+	client := simulated(t, sim)
+	if _, err := client.Increment(); err != nil {
+		t.Fatal(err)
+	}
+
+	do(t, s, "GET", "/fiscal/supply?format=2", "", http.StatusOK, &entry)
+	if entry.Format != 2 || entry.Record != 2 || entry.Dispensed() != int64(entry.Volume) {
+		t.Errorf("PAF2 supply %+v", entry)
+	}
+	// The collected supply ended short of the device totalizer
+	if len(entry.Issues) != 1 || entry.Issues[0] != (fiscal.Issue{Kind: fiscal.IssueGap, Nozzle: "01", Record: 2, Expected: 900, Got: 1000}) {
+		t.Errorf("issues %+v", entry.Issues)
+	}
+	do(t, s, "GET", "/fiscal/supply?format=3", "", http.StatusBadRequest, nil)
+
+	var audit struct {
+		Records []fiscal.Entry
+		Issues  int
+	}
+	do(t, s, "GET", "/fiscal/supplies", "", http.StatusOK, &audit)
+	if len(audit.Records) != 2 || audit.Issues != 1 {
+		t.Errorf("audit %+v", audit)
+	}
+}
+
+func TestFiscalValidate(t *testing.T) {
+	s := newSimulatedServer(t, simulator.New(simulator.Config{}))
+
+	body := `{"records":[
+		{"nozzle":"01","record":1,"volume":100,"startTotal":0,"endTotal":100},
+		{"nozzle":"01","record":2,"volume":100,"startTotal":100,"endTotal":150}
+	]}`
+	var resp struct{ Issues []fiscal.Issue }
+	do(t, s, "POST", "/fiscal/validate", body, http.StatusOK, &resp)
+	if len(resp.Issues) != 1 || resp.Issues[0].Kind != fiscal.IssueVolumeMismatch {
+		t.Errorf("issues %+v", resp.Issues)
+	}
+	do(t, s, "POST", "/fiscal/validate", `{"frames":["(0)"]}`, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/companytec"
+)
+
+// maxIdentifierScan bounds a single GET /identifiers listing.
+const maxIdentifierScan = 1000
+
+func (s *Server) handlePendingIdentifier(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	id, err := d.client.PendingIdentifierCtx(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	if id == "" {
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+func (s *Server) handleIncrementIdentifier(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	resp, err := d.client.IncrementIdentifierCtx(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+// handleListIdentifiers lists the recorded identifiers between ?from= and ?to=
+// (default 1 to 100).
+func (s *Server) handleListIdentifiers(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	from, err1 := strconv.Atoi(c.DefaultQuery("from", "1"))
+	to, err2 := strconv.Atoi(c.DefaultQuery("to", "100"))
+	if err1 != nil || err2 != nil || from < 1 || to < from || to > companytec.MaxIdentifierPosition || to-from >= maxIdentifierScan {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from/to range"})
+		return
+	}
+
+	entries := []companytec.IdentifierEntry{}
+	for entry, err := range d.client.Identifiers(c.Request.Context(), from, to) {
+		if err != nil {
+			s.commandError(c, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func (s *Server) handleGetIdentifier(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	pos, err := strconv.Atoi(c.Param("position"))
+	if err != nil || pos < 1 || pos > companytec.MaxIdentifierPosition {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid position"})
+		return
+	}
+	rec, err := d.client.IdentifierCtx(c.Request.Context(), pos)
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	if rec == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no identifier at this position"})
+		return
+	}
+	c.JSON(http.StatusOK, companytec.IdentifierEntry{Position: pos, IdentifierRecord: *rec})
+}
+
+func (s *Server) handleRecordIdentifier(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	var req companytec.IdentifierRecord
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := d.client.RecordIdentifierCtx(c.Request.Context(), req)
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+// handleDeleteIdentifier deletes an identifier. Query: control (default 00)
+// and position (default 0, fixed position).
+func (s *Server) handleDeleteIdentifier(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	pos, err := strconv.Atoi(c.DefaultQuery("position", "0"))
+	if err != nil || pos < 0 || pos > companytec.MaxIdentifierPosition {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid position"})
+		return
+	}
+	resp, err := d.client.DeleteIdentifierCtx(c.Request.Context(), c.DefaultQuery("control", "00"), c.Param("id"), pos)
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+func (s *Server) handleClearIdentifiers(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	resp, err := d.client.ClearIdentifierMemoryCtx(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": resp})
+}
+
+func (s *Server) handlePresetIdentified(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	var req companytec.IdentifiedPreset
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := d.setPresetIdentified(c, req)
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": resp})
+}
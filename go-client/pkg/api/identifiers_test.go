@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func TestIdentifierEndpoints(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	for _, id := range []string{"ABCDEF0123456789", "1111222233334444"} {
+		do(t, s, "POST", "/identifiers",
+			`{"control":"01","parameter":"1","id":"`+id+`","shiftA":{"start":"0600","end":"1400"}}`,
+			http.StatusOK, nil)
+	}
+	var entries []companytec.IdentifierEntry
+	do(t, s, "GET", "/identifiers?from=1&to=5", "", http.StatusOK, &entries)
+	if len(entries) != 2 || entries[1].Position != 2 || entries[1].ID != "1111222233334444" {
+		t.Fatalf("identifiers %+v", entries)
+	}
+	if entries[0].ShiftA != (companytec.Shift{Start: "0600", End: "1400"}) {
+		t.Errorf("shift A %+v", entries[0].ShiftA)
+	}
+
+	var entry companytec.IdentifierEntry
+	do(t, s, "GET", "/identifiers/2", "", http.StatusOK, &entry)
+	if entry.ID != "1111222233334444" {
+		t.Errorf("identifier at 2: %+v", entry)
+	}
+	do(t, s, "GET", "/identifiers/3", "", http.StatusNotFound, nil)
+	do(t, s, "GET", "/identifiers/0", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/identifiers?from=1&to=5000", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/identifiers/1000000", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/identifiers?from=999999&to=1000000", "", http.StatusBadRequest, nil)
+
+	do(t, s, "DELETE", "/identifiers/ABCDEF0123456789?control=01", "", http.StatusOK, nil)
+	do(t, s, "GET", "/identifiers/1", "", http.StatusNotFound, nil)
+	do(t, s, "DELETE", "/identifiers/ABCDEF0123456789?control=01", "", http.StatusConflict, nil)
+	do(t, s, "DELETE", "/identifiers/XYZ", "", http.StatusBadRequest, nil)
+	do(t, s, "DELETE", "/identifiers/ABCDEF0123456789?position=-1", "", http.StatusBadRequest, nil)
+	do(t, s, "DELETE", "/identifiers/ABCDEF0123456789?position=1000000", "", http.StatusBadRequest, nil)
+
+	do(t, s, "DELETE", "/identifiers", "", http.StatusOK, nil)
+	do(t, s, "GET", "/identifiers?from=1&to=5", "", http.StatusOK, &entries)
+	if len(entries) != 0 {
+		t.Errorf("identifiers after clearing the memory: %+v", entries)
+	}
+}
+
+func TestPendingIdentifier(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	var pending map[string]string
+	do(t, s, "GET", "/identifier", "", http.StatusOK, &pending)
+	if pending != nil {
+		t.Errorf("pending identifier %v with none presented", pending)
+	}
+	sim.PresentTag("abcdef0123456789")
+	do(t, s, "GET", "/identifier", "", http.StatusOK, &pending)
+	if pending["id"] != "ABCDEF0123456789" {
+		t.Errorf("pending identifier %v", pending)
+	}
+	do(t, s, "POST", "/identifier/increment", "", http.StatusOK, nil)
+	pending = nil
+	do(t, s, "GET", "/identifier", "", http.StatusOK, &pending)
+	if pending != nil {
+		t.Errorf("pending identifier %v after ?I", pending)
+	}
+}
+
+func TestPresetIdentified(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	body := `{"nozzle":"01","id":"ABCDEF0123456789","type":1,"authorize":true,"value":2000,"timeout":30,"presetType":"$"}`
+	do(t, s, "POST", "/preset/identified", body, http.StatusOK, nil)
+	if got := nozzleStatus(t, s, "01"); got != companytec.StatusReady {
+		t.Fatalf("nozzle in status %s after an identified preset", got)
+	}
+	sim.Lift("01")
+	sim.Dispense("01", 100)
+	sim.Hang("01")
+	supplies := sim.Supplies()
+	if len(supplies) != 1 || supplies[0].CustomerTag != "ABCDEF0123456789" {
+		t.Errorf("supplies %+v", supplies)
+	}
+
+	do(t, s, "POST", "/preset/identified", `{"nozzle":"01","id":"ABC","presetType":"$"}`, http.StatusBadRequest, nil)
+}
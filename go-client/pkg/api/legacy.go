@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/companytec"
+)
+
+// The endpoints that predate the typed parsing layer keep the response shapes
+// they always had, with the device fields as the strings sliced out of the
+// frame. /supply, /total and /price add the decoded value under "parsed".
+
+// legacySupply is the /supply response for resp, decoded as supply.
+func legacySupply(resp string, supply *companytec.SupplyRecord) gin.H {
+	data := resp[1 : len(resp)-3] // Without delimiters and checksum
+	return gin.H{
+		"totalToPay": data[0:6],
+		"volume":     data[6:12],
+		"price":      data[12:16],
+		"commaCode":  data[16:18],
+		"supplyTime": data[18:22],
+		"nozzle":     data[22:24],
+		"day":        data[24:26],
+		"hour":       data[26:28],
+		"minute":     data[28:30],
+		"parsed":     supply,
+	}
+}
+
+// legacyVisualization is the /visualization response for entries, with the
+// six digit values of the frame.
+func legacyVisualization(entries []companytec.VisualizationEntry) []gin.H {
+	nozzles := make([]gin.H, 0, len(entries))
+	for _, e := range entries {
+		nozzles = append(nozzles, gin.H{
+			"nozzle": e.Nozzle,
+			"value":  fmt.Sprintf("%06d", e.Value),
+		})
+	}
+	return nozzles
+}
+
+// legacyTotal is the /total response for total.
+func legacyTotal(total *companytec.Total) gin.H {
+	return gin.H{
+		"raw":    total.Raw,
+		"mode":   total.Mode,
+		"nozzle": total.Nozzle,
+		"value":  total.Raw[4 : len(total.Raw)-3], // After mode and nozzle
+		"parsed": total,
+	}
+}
+
+// legacyPrice is the /price response for price.
+func legacyPrice(price *companytec.Price) gin.H {
+	return gin.H{"price": price.Raw, "parsed": price}
+}
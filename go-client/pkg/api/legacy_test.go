@@ -0,0 +1,56 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+func TestLegacyShapes(t *testing.T) {
+	checked := func(body string) string {
+		return "(" + body + companytec.Checksum("("+body) + ")"
+	}
+	answers := map[string]string{
+		"(&S)": "(SFFFF)",
+		"(&V)": "(02001250)",
+		companytec.New("").BuildCommand("&A", ""):    checked("012345002100587903015402021407030042000123456700"),
+		companytec.New("").BuildCommand("&T", "01L"): checked("L0100012345678"),
+		companytec.New("").BuildCommand("&T", "01U"): checked("U0158796099"),
+	}
+	client := companytec.New("device:2001",
+		companytec.WithDialer(fakeDevice(func(command string) string {
+			if answer, ok := answers[command]; ok {
+				return answer
+			}
+			return "(0)"
+		})),
+		companytec.WithTimeout(time.Second),
+	)
+	defer client.Disconnect()
+	s := NewServer(client, WithLogger(slog.New(slog.DiscardHandler)))
+
+	// The shapes served before the typed parsing layer, which only adds
+	// "parsed"
+	tests := map[string]string{
+		"/status":        `{"nozzles":null}`,
+		"/visualization": `[{"nozzle":"02","value":"001250"}]`,
+		"/supply": `{"commaCode":"03","day":"02","hour":"14","minute":"07","nozzle":"02",` +
+			`"parsed":{"totalToPay":12345,"volume":2100,"price":5879,"commaCode":"03","supplyTime":154,"nozzle":"02","day":2,"hour":14,"minute":7,"month":3,"record":42,"finalTotal":1234567,"status":"00"},` +
+			`"price":"5879","supplyTime":"0154","totalToPay":"012345","volume":"002100"}`,
+		"/total/01/L": `{"mode":"L","nozzle":"01","parsed":{"mode":"L","nozzle":"01","value":12345678},` +
+			`"raw":"` + checked("L0100012345678") + `","value":"00012345678"}`,
+		"/price/01": `{"parsed":{"mode":"U","nozzle":"01","levels":[5879,6099]},"price":"` + checked("U0158796099") + `"}`,
+	}
+	for path, want := range tests {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != want {
+			t.Errorf("GET %s: %d\n got %s\nwant %s", path, w.Code, got, want)
+		}
+	}
+}
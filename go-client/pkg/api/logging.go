@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/companytec"
+)
+
+// WithLogger logs every API request on logger, instead of gin's default
+// access log, along with the errors the handlers attached to it. The monitor
+// and clock-sync services the server creates for its devices log on it too,
+// with the device ID.
+//
+// Requests are logged at info level, or at warn level when they fail with a
+// server error. They are logged by route rather than path, which may hold an
+// identifier tag, and their errors with the tags masked (see
+// companytec.RedactError).
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// requestLogger is the middleware logging requests with WithLogger.
+func (s *Server) requestLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	attrs := []slog.Attr{
+		slog.String("method", c.Request.Method),
+		slog.String("route", route),
+		slog.Int("status", status),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("client", c.ClientIP()),
+	}
+	if id := c.Param("device"); id != "" {
+		attrs = append(attrs, slog.String("device", id))
+	}
+	if err := c.Errors.Last(); err != nil {
+		attrs = append(attrs, slog.String("error", companytec.RedactError(err.Err)))
+	}
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelWarn
+	}
+	s.logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
+
+// deviceLogger returns the logger of the services of device id, nil when the
+// server has none.
+func (s *Server) deviceLogger(id string) *slog.Logger {
+	if s.logger == nil {
+		return nil
+	}
+	return s.logger.With("device", id)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"companytec-client/pkg/simulator"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	s := newSimulatedServer(t, simulator.New(simulator.Config{}), WithLogger(logger))
+
+	do(t, s, "PUT", "/blacklist/ABCDEF0123456789", "", http.StatusOK, nil)
+	// The device rejects deleting an identifier it does not hold
+	do(t, s, "DELETE", "/identifiers/ABCDEF0123456789", "", http.StatusConflict, nil)
+	do(t, s, "PUT", "/blacklist/ABCDEF0123456789AB", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/missing/ABCDEF0123456789", "", http.StatusNotFound, nil)
+
+	if strings.Contains(buf.String(), "ABCDEF0123456789") {
+		t.Errorf("identifier tag logged:\n%s", buf.String())
+	}
+	var records []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d records, want one per request:\n%s", len(records), buf.String())
+	}
+	routes := []string{"/blacklist/:id", "/identifiers/:id", "/blacklist/:id", "unmatched"}
+	for i, rec := range records {
+		if rec["msg"] != "request" || rec["route"] != routes[i] {
+			t.Errorf("record %d: %v", i, rec)
+		}
+	}
+	if records[0]["error"] != nil || records[0]["level"] != "INFO" {
+		t.Errorf("successful request logged as %v", records[0])
+	}
+	if err, _ := records[1]["error"].(string); !strings.Contains(err, "(?F00A************678900") {
+		t.Errorf("rejected command logged as %q", err)
+	}
+	if err, _ := records[2]["error"].(string); !strings.Contains(err, "**************89AB") {
+		t.Errorf("invalid identifier logged as %q", err)
+	}
+}
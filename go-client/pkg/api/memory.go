@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/companytec"
+)
+
+// maxSupplyScan bounds a single GET /supplies dump.
+const maxSupplyScan = 1000
+
+func (s *Server) handlePointers(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	pointers, err := d.client.PointersCtx(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pointers)
+}
+
+func (s *Server) handleSupplyAt(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	pos, err := strconv.Atoi(c.Param("position"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid position"})
+		return
+	}
+	supply, err := d.client.SupplyAtCtx(c.Request.Context(), pos)
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	if supply == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no supply at this position"})
+		return
+	}
+	c.JSON(http.StatusOK, companytec.SupplyEntry{Position: pos, SupplyRecord: *supply})
+}
+
+// handleDumpSupplies lists the supplies stored between ?from= and ?to=
+// without moving the read pointer. The range wraps around the end of the
+// memory when from is after to.
+func (s *Server) handleDumpSupplies(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	from, err1 := strconv.Atoi(c.Query("from"))
+	to, err2 := strconv.Atoi(c.Query("to"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to are required"})
+		return
+	}
+	size := d.client.MemorySize()
+	if n := ((to-from)%size+size)%size + 1; n > maxSupplyScan {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d positions can be dumped at once", maxSupplyScan)})
+		return
+	}
+
+	entries := []companytec.SupplyEntry{}
+	for entry, err := range d.client.DumpSupplies(c.Request.Context(), from, to) {
+		if err != nil {
+			s.commandError(c, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+type ReadPointerRequest struct {
+	Position *int `json:"position" binding:"required"`
+}
+
+// handleMoveReadPointer repositions the read pointer, e.g. to have &A return
+// supplies again after a back-office outage.
+func (s *Server) handleMoveReadPointer(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	var req ReadPointerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	supply, err := d.client.MoveReadPointerCtx(c.Request.Context(), *req.Position)
+	if err != nil {
+		s.commandError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"supply": supply})
+}
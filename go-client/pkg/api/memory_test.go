@@ -0,0 +1,45 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func TestDumpSuppliesWraps(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true, MemorySize: 4})
+	client := simulated(t, sim, companytec.WithMemorySize(4))
+	s := NewServer(client, WithLogger(slog.New(slog.DiscardHandler)))
+	for _, volume := range []int{100, 200, 300, 400, 500, 600} {
+		sim.Lift("01")
+		sim.Dispense("01", volume)
+		sim.Hang("01")
+	}
+
+	var p companytec.MemoryPointers
+	do(t, s, "GET", "/pointers", "", http.StatusOK, &p)
+	var entries []companytec.SupplyEntry
+	do(t, s, "GET", "/supplies?from=2&to=1", "", http.StatusOK, &entries)
+	if p.Write != 2 || len(entries) != 4 {
+		t.Fatalf("pointers %+v, supplies %+v", p, entries)
+	}
+	for i, e := range entries {
+		if want := 300 + 100*i; e.Volume != want || e.Position != (2+i)%4 {
+			t.Errorf("entry %d: position %d volume %d, want volume %d", i, e.Position, e.Volume, want)
+		}
+	}
+
+	do(t, s, "GET", "/supplies?from=0&to=4", "", http.StatusBadRequest, nil)
+}
+
+func TestDumpSuppliesLimit(t *testing.T) {
+	s := newSimulatedServer(t, simulator.New(simulator.Config{}))
+	do(t, s, "GET", "/supplies?from=0&to=999", "", http.StatusOK, nil)
+	do(t, s, "GET", "/supplies?from=0&to=1000", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/supplies?from=9990&to=5", "", http.StatusOK, nil)
+	do(t, s, "GET", "/supplies?from=5&to=4", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/supplies", "", http.StatusBadRequest, nil)
+}
@@ -1,23 +1,204 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 
+	"companytec-client/pkg/blacklist"
+	"companytec-client/pkg/cache"
+	"companytec-client/pkg/clocksync"
 	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/fiscal"
+	"companytec-client/pkg/fleet"
+	"companytec-client/pkg/journal"
+	"companytec-client/pkg/metrics"
+	"companytec-client/pkg/monitor"
+	"companytec-client/pkg/tracing"
 )
 
+// Server serves the REST API of a default device, at the root, and of the
+// devices of a fleet registry, under /devices/:id.
 type Server struct {
-	client *companytec.Client
-	router *gin.Engine
+	router  *gin.Engine
+	def     *device
+	fleet   *fleet.Registry
+	cache   *cache.Config
+	metrics *metrics.Metrics
+	tracer  trace.TracerProvider
+	logger  *slog.Logger
+	origins []string // See WithAllowedOrigins
+	// stateDir holds the journal and blacklist state of fleet devices, see
+	// WithFleetState
+	stateDir string
+	collect  bool // See WithFleetCollector
+
+	mu      sync.Mutex
+	devices map[*fleet.Device]*device
+}
+
+// Option configures a Server created with NewServer. Unless stated otherwise,
+// options apply to the default device.
+type Option func(*Server)
+
+// WithClockSync reports the status of a running clock-sync service. Without
+// it the clock endpoints still measure and set the clock, with the default
+// clock-sync configuration.
+func WithClockSync(svc *clocksync.Service) Option {
+	return func(s *Server) {
+		s.def.clock = svc
+	}
+}
+
+// WithBlacklist uses a blacklist manager with a persistent state file. Without
+// it the blacklist endpoints keep their record in memory.
+func WithBlacklist(m *blacklist.Manager) Option {
+	return func(s *Server) {
+		s.def.blacklist = m
+	}
+}
+
+// WithJournal records the price changes, presets and mode changes the device
+// accepts in j, whether sent through the API or by other users of the client
+// (see journal.Observe), and serves it on GET /transactions.
+func WithJournal(j *journal.Journal) Option {
+	return func(s *Server) {
+		s.def.journal = j
+	}
+}
+
+// WithAuditor keeps the PAF records read on GET /fiscal/supply in a, and
+// serves a on GET /fiscal/supplies. Share it with a collector storing through
+// a fiscal.Sink to audit every captured supply. Without it the server keeps
+// the last PAF records read through the API only.
+func WithAuditor(a *fiscal.Auditor) Option {
+	return func(s *Server) {
+		s.def.auditor = a
+	}
+}
+
+// WithMonitor streams the events of a monitor the caller runs on GET /events
+// and GET /ws. Without it the server creates a monitor that also reports
+// completed supplies, and runs it while at least one stream client is
+// connected.
+func WithMonitor(m *monitor.Monitor) Option {
+	return func(s *Server) {
+		s.def.monitor = m
+	}
+}
+
+// WithAllowedOrigins sets the origins browsers may open GET /ws from, such as
+// "https://dashboard.example.com"; "*" allows any origin. By default only
+// pages served from the API host itself may connect. Requests without an
+// Origin header, sent by non-browser clients, are always accepted.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(s *Server) {
+		s.origins = origins
+	}
+}
+
+// WithFleet serves the devices of reg under /devices/:id, each with the
+// default services of a device, and their overview on GET /devices.
+func WithFleet(reg *fleet.Registry) Option {
+	return func(s *Server) {
+		s.fleet = reg
+	}
+}
+
+// WithFleetState keeps the state of every fleet device in dir: its journal
+// in <id>.journal, served on /devices/:id/transactions and audited on
+// /devices/:id/fiscal/supplies, and its blacklist record in
+// <id>.blacklist.json. Without it fleet devices have no journal and keep
+// their blacklist record in memory.
+func WithFleetState(dir string) Option {
+	return func(s *Server) {
+		s.stateDir = dir
+	}
+}
+
+// WithFleetCollector runs a supply collector on every fleet device, storing
+// its supplies, audited, in the journal of WithFleetState, which it requires.
+// The collector acknowledges the supplies it stores, so it must be the only
+// consumer of the device supplies.
+func WithFleetCollector() Option {
+	return func(s *Server) {
+		s.collect = true
+	}
 }
 
-func NewServer(client *companytec.Client) *Server {
+// WithCache puts a response cache with cfg in front of every device, the
+// default one and those of the fleet. Status, visualization, totalizer and
+// price reads are then served from it, with X-Cache (HIT or MISS), Age and
+// X-Cache-Age-Ms headers, and presets, mode and price changes invalidate the
+// nozzle they touch.
+func WithCache(cfg cache.Config) Option {
+	return func(s *Server) {
+		s.cache = &cfg
+	}
+}
+
+// WithMetrics serves m on GET /metrics, records the API requests in it and
+// instruments every device, the default one as "default" and those of the
+// fleet by ID.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithTracing creates a span with tp for every API request. Commands sent
+// by the handlers are traced as its children by clients using the same
+// provider (see companytec.WithTracerProvider and tracing.Install).
+func WithTracing(tp trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracer = tp
+	}
+}
+
+// NewServer creates a server for client, the default device. client may be
+// nil when the server only serves a fleet.
+func NewServer(client *companytec.Client, opts ...Option) *Server {
 	s := &Server{
-		client: client,
-		router: gin.Default(),
+		def:     &device{client: client},
+		devices: make(map[*fleet.Device]*device),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger != nil {
+		s.router = gin.New()
+		s.router.Use(gin.Recovery(), s.requestLogger)
+	} else {
+		s.router = gin.Default()
+	}
+	if client == nil {
+		s.def = nil
+	} else {
+		s.setupDevice("default", s.def)
+	}
+	if s.tracer != nil {
+		s.router.Use(tracing.Middleware(s.tracer))
+	}
+	if s.metrics != nil {
+		s.router.Use(s.metrics.Middleware())
+	}
+	if s.fleet != nil {
+		s.fleet.OnRemove(s.removeDevice)
+		if s.metrics != nil || s.collect {
+			// Instrument the fleet and start its collectors now rather
+			// than on the first request of each device
+			for _, fd := range s.fleet.List(nil) {
+				if _, err := s.fleetDevice(fd); err != nil && s.logger != nil {
+					s.logger.Error("fleet device setup failed", "device", fd.ID, "error", err)
+				}
+			}
+		}
 	}
 	s.setupRoutes()
 	return s
@@ -28,23 +209,72 @@ func (s *Server) Run(port int) error {
 }
 
 func (s *Server) setupRoutes() {
-	s.router.GET("/status", s.handleStatus)
-	s.router.GET("/calendar", s.handleCalendar)
-	s.router.GET("/supply", s.handleSupply)
-	s.router.GET("/visualization", s.handleVisualization)
-	s.router.GET("/total/:nozzle/:mode", s.handleTotal)
-	s.router.GET("/price/:nozzle", s.handlePrice)
-	
-	s.router.POST("/preset", s.handlePreset)
-	s.router.POST("/mode", s.handleMode)
-	s.router.POST("/price", s.handleChangePrice)
+	s.deviceRoutes(s.router.Group("/", s.defaultDevice))
+
+	if s.metrics != nil {
+		s.router.GET("/metrics", gin.WrapH(s.metrics.Handler()))
+	}
+	s.router.GET("/devices", s.handleDevices)
+	s.router.GET("/devices/:device", s.withDevice, s.handleDevice)
+	s.deviceRoutes(s.router.Group("/devices/:device", s.withDevice))
+}
+
+// deviceRoutes registers the endpoints of a device.
+func (s *Server) deviceRoutes(r *gin.RouterGroup) {
+	r.GET("/status", s.handleStatus)
+	r.GET("/queue", s.handleQueue)
+	r.GET("/calendar", s.handleCalendar)
+	r.GET("/supply", s.handleSupply)
+	r.GET("/supply/dual", s.handleSupplyDual)
+	r.GET("/supply/:position", s.handleSupplyAt)
+	r.GET("/supplies", s.handleDumpSupplies)
+	r.GET("/pointers", s.handlePointers)
+	r.PUT("/pointers/read", s.handleMoveReadPointer)
+	r.GET("/visualization", s.handleVisualization)
+	r.GET("/total/:nozzle/:mode", s.handleTotal)
+	r.GET("/price/:nozzle", s.handlePrice)
+
+	r.POST("/preset", s.handlePreset)
+	r.POST("/mode", s.handleMode)
+	r.POST("/price", s.handleChangePrice)
+
+	r.GET("/identifier", s.handlePendingIdentifier)
+	r.POST("/identifier/increment", s.handleIncrementIdentifier)
+	r.GET("/identifiers", s.handleListIdentifiers)
+	r.GET("/identifiers/:position", s.handleGetIdentifier)
+	r.POST("/identifiers", s.handleRecordIdentifier)
+	r.DELETE("/identifiers", s.handleClearIdentifiers)
+	r.DELETE("/identifiers/:id", s.handleDeleteIdentifier)
+	r.POST("/preset/identified", s.handlePresetIdentified)
+
+	r.GET("/clock", s.handleClock)
+	r.POST("/clock", s.handleSetClock)
+	r.GET("/clock/sync", s.handleClockSyncStatus)
+	r.POST("/clock/sync", s.handleClockSync)
+
+	r.GET("/blacklist", s.handleListBlacklist)
+	r.GET("/blacklist/:id", s.handleGetBlacklisted)
+	r.PUT("/blacklist/:id", s.handleBlacklist)
+	r.DELETE("/blacklist/:id", s.handleUnblacklist)
+	r.DELETE("/blacklist", s.handleClearBlacklist)
+	r.POST("/blacklist/sync", s.handleSyncBlacklist)
+
+	r.GET("/fiscal/supply", s.handleFiscalSupply)
+	r.GET("/fiscal/supplies", s.handleFiscalSupplies)
+	r.POST("/fiscal/validate", s.handleFiscalValidate)
+
+	r.GET("/transactions", s.handleTransactions)
+
+	r.GET("/events", s.handleEvents)
+	r.GET("/ws", s.handleWebSocket)
 }
 
 // -- Helpers --
 
 func (s *Server) ensureConnected(c *gin.Context) bool {
-	if !s.client.IsConnected() {
-		if err := s.client.Connect(); err != nil {
+	d := s.device(c)
+	if !d.client.IsConnected() {
+		if err := d.client.ConnectCtx(c.Request.Context()); err != nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to connect to device", "details": err.Error()})
 			return false
 		}
@@ -52,192 +282,178 @@ func (s *Server) ensureConnected(c *gin.Context) bool {
 	return true
 }
 
+// commandError responds with an HTTP status matching a failed device command.
+// Protocol errors map to 502 (bad frame) or 409 (device rejected the command),
+// rejected parameters to 400, a full command queue to 503 and an expired
+// request deadline to 504.
+func (s *Server) commandError(c *gin.Context, err error) {
+	c.Error(err)
+	var perr *companytec.ProtocolError
+	if errors.As(err, &perr) {
+		status := http.StatusBadGateway
+		if perr.Kind == companytec.ErrNAK {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "kind": perr.Kind.String(), "raw": string(perr.Raw)})
+		return
+	}
+	if errors.Is(err, companytec.ErrInvalidParameter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, companytec.ErrQueueFull) {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
 // -- Handlers --
 
 func (s *Server) handleStatus(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
-
-	resp, err := s.client.GetStatus()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	// Parse status
-	// format: (&SLBCAF...)
-	// remove (& and )
-	if len(resp) < 3 {
-		c.JSON(http.StatusOK, gin.H{"raw": resp})
+	d := s.device(c)
+	if !s.ensureConnected(c) {
 		return
 	}
-	data := resp[1 : len(resp)-1] // Remove ( and )
-	// status codes start after 'S'? JS: substring(1, Math.min(33, data.length - 10))
-	// JS: const data = response.substring(1, response.length - 1);
-	// JS: const statusCodes = data.substring(1, Math.min(33, data.length - 10));
-	// Actually typical response is (&Snnnnnn...)
-	// Let's safe guard.
-	if len(data) < 2 {
-		c.JSON(http.StatusOK, gin.H{"raw": resp})
+
+	statuses, err := d.status(c)
+	if err != nil {
+		s.commandError(c, err)
 		return
 	}
-	
-	statusCodes := data[1:] // Skip 'S'
-	// Cap at 32 nozzles
-	if len(statusCodes) > 32 {
-		statusCodes = statusCodes[:32]
-	}
-
-	var nozzles []gin.H
-	for i, r := range statusCodes {
-		code := string(r)
-		if code != "F" {
-			nozzles = append(nozzles, gin.H{
-				"position":   i + 1,
-				"nozzle":     fmt.Sprintf("%02X", i+1),
-				"statusCode": code,
-				"status":     getStatusDescription(code),
-			})
+
+	// null when no nozzle is present, as the endpoint always answered
+	var nozzles []companytec.NozzleStatus
+	for _, n := range statuses {
+		if n.Present() {
+			nozzles = append(nozzles, n)
 		}
 	}
-	
 	c.JSON(http.StatusOK, gin.H{"nozzles": nozzles})
 }
 
-func getStatusDescription(code string) string {
-	switch code {
-	case "L": return "Available"
-	case "B": return "Blocked"
-	case "C": return "Finished"
-	case "A": return "Refueling"
-	case "E": return "Waiting"
-	case "F": return "Not Present"
-	case "P": return "Ready"
-	default: return "Unknown"
+// handleQueue reports the metrics of the device command queue.
+func (s *Server) handleQueue(c *gin.Context) {
+	st := s.device(c).client.QueueStats()
+	classes := make([]gin.H, 0, len(st.Classes))
+	for _, cs := range st.Classes {
+		classes = append(classes, gin.H{
+			"priority":  cs.Priority.String(),
+			"depth":     cs.Depth,
+			"sent":      cs.Sent,
+			"waitMs":    cs.Wait.Milliseconds(),
+			"maxWaitMs": cs.MaxWait.Milliseconds(),
+		})
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"depth":     st.Depth,
+		"limit":     st.Limit,
+		"rejected":  st.Rejected,
+		"coalesced": st.Coalesced,
+		"classes":   classes,
+	})
 }
 
 func (s *Server) handleCalendar(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
-	resp, err := s.client.ReadCalendar()
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	resp, err := d.client.ReadCalendarCtx(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"calendar": resp})
+	clock, err := companytec.ParseCalendar(resp)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"calendar": resp})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"calendar": resp, "clock": clock})
 }
 
 func (s *Server) handleSupply(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
-	resp, err := s.client.ReadSupply52()
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
+	resp, err := d.client.ReadSupply52Ctx(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
-	if resp == "(0)" {
-		c.JSON(http.StatusOK, nil)
+	supply, err := companytec.ParseSupply(resp)
+	if err != nil {
+		s.commandError(c, err)
 		return
 	}
-	// Parse supply
-	// JS: data = response.substring(1, response.length - 3)
-	if len(resp) < 4 {
-		c.JSON(http.StatusOK, gin.H{"raw": resp})
+	if supply == nil {
+		c.JSON(http.StatusOK, nil)
 		return
 	}
-	data := resp[1 : len(resp)-3] // skip ( and checksum+end )
+	c.JSON(http.StatusOK, legacySupply(resp, supply))
+}
 
-	parsed := gin.H{}
-	if len(data) >= 24 {
-		parsed["totalToPay"] = data[0:6]
-		parsed["volume"] = data[6:12]
-		parsed["price"] = data[12:16]
-		parsed["commaCode"] = data[16:18]
-		parsed["supplyTime"] = data[18:22]
-		parsed["nozzle"] = data[22:24]
+func (s *Server) handleSupplyDual(c *gin.Context) {
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
 	}
-	if len(data) >= 30 {
-		parsed["day"] = data[24:26]
-		parsed["hour"] = data[26:28]
-		parsed["minute"] = data[28:30]
+	supply, err := d.client.SupplyDualCtx(c.Request.Context())
+	if err != nil {
+		s.commandError(c, err)
+		return
 	}
-	
-	c.JSON(http.StatusOK, parsed)
+	c.JSON(http.StatusOK, supply)
 }
 
 func (s *Server) handleVisualization(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
-	resp, err := s.client.GetVisualization()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	if resp == "(0)" {
-		c.JSON(http.StatusOK, []interface{}{})
+	d := s.device(c)
+	if !s.ensureConnected(c) {
 		return
 	}
-	
-	// JS: data = response.substring(1, response.length - 1)
-	if len(resp) < 2 {
-		c.JSON(http.StatusOK, gin.H{"raw": resp})
+	entries, err := d.visualization(c)
+	if err != nil {
+		s.commandError(c, err)
 		return
 	}
-	data := resp[1 : len(resp)-1]
-	
-	var nozzles []gin.H
-	for i := 0; i+8 <= len(data); i += 8 {
-		nozzles = append(nozzles, gin.H{
-			"nozzle": data[i : i+2],
-			"value":  data[i+2 : i+8],
-		})
-	}
-	c.JSON(http.StatusOK, nozzles)
+	c.JSON(http.StatusOK, legacyVisualization(entries))
 }
 
 func (s *Server) handleTotal(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
 	nozzle := c.Param("nozzle")
 	mode := c.Param("mode")
-	
-	resp, err := s.client.ReadTotal(nozzle, mode)
+
+	total, err := d.total(c, nozzle, mode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
-	
-	// JS: data = response.substring(1, response.length - 3)
-	// format: (&TnnvvvvvvCC)
-	if len(resp) < 4 {
-		c.JSON(http.StatusOK, gin.H{"raw": resp})
-		return
-	}
-	data := resp[1 : len(resp)-3]
-	
-	// Remove header char from data? JS: mode = data[0]
-	// Actually &T + nozzle + mode was sent.
-	// Response to &T08L is usually (&T08LvvvvvvCC) or similar? 
-	// JS ParseTotal:
-	//   data = response.substring(1, response.length - 3)
-	//   mode: data[0]
-	//   nozzle: data.substring(1, 3)
-	//   value: data.substring(3)
-	
-	result := gin.H{"raw": resp}
-	if len(data) >= 3 {
-		result["mode"] = string(data[0])
-		result["nozzle"] = data[1:3]
-		result["value"] = data[3:]
-	}
-	c.JSON(http.StatusOK, result)
+	c.JSON(http.StatusOK, legacyTotal(total))
 }
 
 func (s *Server) handlePrice(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
 	nozzle := c.Param("nozzle")
-	
-	resp, err := s.client.ReadPrice(nozzle, "U")
+
+	price, err := d.price(c, nozzle, "U")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"price": resp})
+	c.JSON(http.StatusOK, legacyPrice(price))
 }
 
 // -- POST Handlers --
@@ -248,16 +464,19 @@ type PresetRequest struct {
 }
 
 func (s *Server) handlePreset(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
 	var req PresetRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	resp, err := s.client.SetPreset(req.Nozzle, req.Value)
+
+	resp, err := d.setPreset(c, req.Nozzle, req.Value)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"result": resp})
@@ -269,16 +488,19 @@ type ModeRequest struct {
 }
 
 func (s *Server) handleMode(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
 	var req ModeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	resp, err := s.client.SetOperatingMode(req.Nozzle, req.Mode)
+
+	resp, err := d.setOperatingMode(c, req.Nozzle, req.Mode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"result": resp})
@@ -291,16 +513,19 @@ type PriceRequest struct {
 }
 
 func (s *Server) handleChangePrice(c *gin.Context) {
-	if !s.ensureConnected(c) { return }
+	d := s.device(c)
+	if !s.ensureConnected(c) {
+		return
+	}
 	var req PriceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	resp, err := s.client.ChangePrice(req.Nozzle, req.Level, req.Price)
+
+	resp, err := d.changePrice(c, req.Nozzle, req.Level, req.Price)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.commandError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"result": resp})
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/companytec"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestCommandError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&companytec.ProtocolError{Kind: companytec.ErrBadChecksum, Command: "(&S)", Raw: []byte("(S)")}, http.StatusBadGateway},
+		{&companytec.ProtocolError{Kind: companytec.ErrNAK, Command: "(&S)", Raw: []byte("(0)")}, http.StatusConflict},
+		{fmt.Errorf("supply: %w", &companytec.ProtocolError{Kind: companytec.ErrTruncated}), http.StatusBadGateway},
+		{fmt.Errorf("%w: bad nozzle", companytec.ErrInvalidParameter), http.StatusBadRequest},
+		{companytec.ErrQueueFull, http.StatusServiceUnavailable},
+		{fmt.Errorf("read interrupted: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{fmt.Errorf("read error: EOF"), http.StatusInternalServerError},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		s.commandError(c, tt.err)
+		if w.Code != tt.status {
+			t.Errorf("%v: status %d, want %d", tt.err, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+// simulated returns a client connected to sim over a net.Pipe.
+func simulated(t *testing.T, sim *simulator.Simulator, opts ...companytec.Option) *companytec.Client {
+	t.Helper()
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	opts = append([]companytec.Option{companytec.WithDialer(dialer), companytec.WithTimeout(time.Second)}, opts...)
+	c := companytec.New("simulator:2001", opts...)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+// newSimulatedServer returns a server whose default device is sim.
+func newSimulatedServer(t *testing.T, sim *simulator.Simulator, opts ...Option) *Server {
+	t.Helper()
+	opts = append([]Option{WithLogger(slog.New(slog.DiscardHandler))}, opts...)
+	return NewServer(simulated(t, sim), opts...)
+}
+
+// do serves a request and decodes its JSON response into out, if not nil. It
+// fails the test when the response status is not want.
+func do(t *testing.T, s *Server, method, path, body string, want int, out any) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	if w.Code != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, w.Code, want, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, w.Body.String())
+		}
+	}
+}
+
+// nozzleStatus returns the status of a nozzle as served on GET /status.
+func nozzleStatus(t *testing.T, s *Server, code string) companytec.StatusCode {
+	t.Helper()
+	var resp struct{ Nozzles []companytec.NozzleStatus }
+	do(t, s, "GET", "/status", "", http.StatusOK, &resp)
+	for _, n := range resp.Nozzles {
+		if n.Nozzle == code {
+			return n.Code
+		}
+	}
+	t.Fatalf("nozzle %s missing from %+v", code, resp.Nozzles)
+	return ""
+}
+
+func TestSimulatedDispensing(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	if err := sim.Lift("01"); err != nil {
+		t.Fatal(err)
+	}
+	if got := nozzleStatus(t, s, "01"); got != companytec.StatusWaiting {
+		t.Fatalf("lifted nozzle in status %s", got)
+	}
+	do(t, s, "POST", "/mode", `{"nozzle":"01","mode":"A"}`, http.StatusOK, nil)
+	if got := nozzleStatus(t, s, "01"); got != companytec.StatusRefueling {
+		t.Fatalf("authorized nozzle in status %s", got)
+	}
+	sim.Dispense("01", 1500)
+	var vis []struct{ Nozzle, Value string }
+	do(t, s, "GET", "/visualization", "", http.StatusOK, &vis)
+	if len(vis) != 1 || vis[0].Value < fmt.Sprintf("%06d", 1500*5890/1000) {
+		t.Errorf("visualization %+v", vis)
+	}
+	sim.Hang("01")
+	if got := nozzleStatus(t, s, "01"); got != companytec.StatusFinished {
+		t.Errorf("hung nozzle in status %s", got)
+	}
+
+	var supply struct{ Parsed companytec.SupplyRecord }
+	do(t, s, "GET", "/supply", "", http.StatusOK, &supply)
+	if supply.Parsed.Nozzle != "01" || supply.Parsed.Volume < 1500 || supply.Parsed.Record != 1 {
+		t.Errorf("supply %+v", supply.Parsed)
+	}
+	var total struct{ Parsed companytec.Total }
+	do(t, s, "GET", "/total/01/L", "", http.StatusOK, &total)
+	if total.Parsed.Value != int64(supply.Parsed.Volume) {
+		t.Errorf("totalizer %d, want the volume of the only supply %d", total.Parsed.Value, supply.Parsed.Volume)
+	}
+}
+
+func TestSimulatedSupplyMemory(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true, MemorySize: 8})
+	s := newSimulatedServer(t, sim)
+	for _, volume := range []int{100, 200, 300} {
+		sim.Lift("02")
+		sim.Dispense("02", volume)
+		sim.Hang("02")
+	}
+
+	var p companytec.MemoryPointers
+	do(t, s, "GET", "/pointers", "", http.StatusOK, &p)
+	if p.Write != 3 || p.Read != 0 {
+		t.Errorf("pointers %+v", p)
+	}
+
+	var entries []companytec.SupplyEntry
+	do(t, s, "GET", "/supplies?from=0&to=7", "", http.StatusOK, &entries)
+	if len(entries) != 3 || entries[2].Position != 2 || entries[2].Volume < 300 {
+		t.Errorf("supplies %+v", entries)
+	}
+
+	var supply struct{ Parsed companytec.SupplyRecord }
+	do(t, s, "GET", "/supply", "", http.StatusOK, &supply)
+	if supply.Parsed.Record != 1 {
+		t.Errorf("first supply %+v", supply.Parsed)
+	}
+	if _, err := simulated(t, sim).Increment(); err != nil {
+		t.Fatal(err)
+	}
+	do(t, s, "GET", "/supply", "", http.StatusOK, &supply)
+	if supply.Parsed.Record != 2 {
+		t.Errorf("supply after &I %+v", supply.Parsed)
+	}
+	if pending := sim.Supplies(); len(pending) != 2 {
+		t.Errorf("%d supplies pending, want 2", len(pending))
+	}
+}
+
+func TestSimulatedWrites(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	s := newSimulatedServer(t, sim)
+
+	do(t, s, "POST", "/price", `{"nozzle":"01","level":"0","price":"6199"}`, http.StatusOK, nil)
+	var price struct{ Parsed companytec.Price }
+	do(t, s, "GET", "/price/01", "", http.StatusOK, &price)
+	if len(price.Parsed.Levels) == 0 || price.Parsed.Levels[0] != 6199 {
+		t.Errorf("price %+v after the change", price.Parsed)
+	}
+
+	do(t, s, "POST", "/preset", `{"nozzle":"01","value":"1000"}`, http.StatusOK, nil)
+	if got := nozzleStatus(t, s, "01"); got != companytec.StatusReady {
+		t.Errorf("preset nozzle in status %s", got)
+	}
+
+	do(t, s, "POST", "/mode", `{"nozzle":"02","mode":"B"}`, http.StatusOK, nil)
+	if got := nozzleStatus(t, s, "02"); got != companytec.StatusBlocked {
+		t.Errorf("blocked nozzle in status %s", got)
+	}
+	// The device refuses a preset on a blocked nozzle
+	do(t, s, "POST", "/preset", `{"nozzle":"02","value":"1000"}`, http.StatusConflict, nil)
+	do(t, s, "POST", "/mode", `{"nozzle":"02","mode":"L"}`, http.StatusOK, nil)
+	do(t, s, "POST", "/preset", `{"nozzle":"02","value":"1000"}`, http.StatusOK, nil)
+
+	do(t, s, "POST", "/price", `{"nozzle":"01"}`, http.StatusBadRequest, nil)
+}
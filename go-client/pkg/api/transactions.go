@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"companytec-client/pkg/journal"
+)
+
+// maxTransactionsPage bounds the limit of GET /transactions.
+const maxTransactionsPage = 1000
+
+// handleTransactions queries the journal. Filters: kind, nozzle, identifier,
+// from and to (RFC 3339); pages follow ?cursor= with the returned next value.
+func (s *Server) handleTransactions(c *gin.Context) {
+	d := s.device(c)
+	if d.journal == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no journal configured"})
+		return
+	}
+
+	q := journal.Query{
+		Kind:       journal.Kind(c.Query("kind")),
+		Nozzle:     c.Query("nozzle"),
+		Identifier: c.Query("identifier"),
+	}
+	var err error
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 time"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 time"})
+			return
+		}
+	}
+	if v := c.Query("cursor"); v != "" {
+		if q.After, err = strconv.ParseUint(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor must be a number"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxTransactionsPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxTransactionsPage)})
+			return
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	entries, next, err := d.journal.Query(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{"transactions": entries}
+	if next != 0 {
+		resp["next"] = strconv.FormatUint(next, 10)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/journal"
+	"companytec-client/pkg/simulator"
+)
+
+func TestTransactions(t *testing.T) {
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	client := simulated(t, simulator.New(simulator.Config{}))
+	s := NewServer(client, WithLogger(slog.New(slog.DiscardHandler)), WithJournal(j))
+
+	do(t, s, "POST", "/price", `{"nozzle":"01","level":"0","price":"6199"}`, http.StatusOK, nil)
+	do(t, s, "POST", "/preset", `{"nozzle":"02","value":"1000"}`, http.StatusOK, nil)
+	// Changes made outside the API, as from the interactive menu
+	if _, err := client.SetOperatingMode("03", "B"); err != nil {
+		t.Fatal(err)
+	}
+
+	var page struct {
+		Transactions []journal.Entry
+		Next         string
+	}
+	// The journal writes observed commands in the background
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		do(t, s, "GET", "/transactions", "", http.StatusOK, &page)
+		if len(page.Transactions) == 3 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(page.Transactions) != 3 || page.Transactions[2].Kind != journal.KindModeChange || page.Next != "" {
+		t.Fatalf("transactions %+v", page)
+	}
+	do(t, s, "GET", "/transactions?kind=preset", "", http.StatusOK, &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].Value != "1000" {
+		t.Errorf("presets %+v", page.Transactions)
+	}
+	do(t, s, "GET", "/transactions?limit=2", "", http.StatusOK, &page)
+	if len(page.Transactions) != 2 || page.Next != "2" {
+		t.Errorf("first page %+v", page)
+	}
+	do(t, s, "GET", "/transactions?cursor=2", "", http.StatusOK, &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].Nozzle != "03" {
+		t.Errorf("second page %+v", page)
+	}
+
+	do(t, s, "GET", "/transactions?from=yesterday", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/transactions?limit=0", "", http.StatusBadRequest, nil)
+	do(t, s, "GET", "/transactions?from=2024-03-15T10:00:00Z&to=2024-03-15T09:00:00Z", "", http.StatusBadRequest, nil)
+}
+
+func TestTransactionsWithoutJournal(t *testing.T) {
+	s := newSimulatedServer(t, simulator.New(simulator.Config{}))
+	do(t, s, "GET", "/transactions", "", http.StatusNotFound, nil)
+}
@@ -0,0 +1,320 @@
+// Package blacklist manages the identifier blacklist of a device.
+//
+// The device cannot list its blacklist (&M99 only clears, adds and removes),
+// so a Manager keeps its own record of what it wrote, optionally persisted to
+// a JSON state file. Sync uses that record to apply the minimal diff. Until
+// the record is known to match the device (no state file yet, or a previous
+// operation failed halfway), Sync starts by clearing the device blacklist.
+package blacklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"companytec-client/pkg/companytec"
+)
+
+// Action is what Sync did, or would do, for an entry.
+type Action string
+
+const (
+	ActionAdd    Action = "add"
+	ActionRemove Action = "remove"
+	ActionKeep   Action = "keep"
+	ActionClear  Action = "clear"
+)
+
+// Result reports the outcome of a Sync for one entry. The clear step, when
+// one is needed, is reported with an empty ID.
+type Result struct {
+	ID     string `json:"id,omitempty"`
+	Action Action `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// state is the persisted record of the device blacklist.
+type state struct {
+	// Known tells that IDs is exactly the device blacklist. It is false until
+	// the blacklist was cleared once through the Manager.
+	Known bool     `json:"known"`
+	IDs   []string `json:"ids"`
+}
+
+// Manager mirrors the device blacklist. Its methods are safe for concurrent
+// use.
+type Manager struct {
+	client *companytec.Client
+	path   string
+
+	mu    sync.Mutex
+	known bool
+	ids   map[string]bool
+}
+
+// New creates a Manager. path is the JSON state file; it is read when present
+// and rewritten after every change. An empty path keeps the record in memory.
+func New(client *companytec.Client, path string) (*Manager, error) {
+	m := &Manager{client: client, path: path, ids: make(map[string]bool)}
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("blacklist state %s: %w", path, err)
+	}
+	m.known = st.Known
+	for _, id := range st.IDs {
+		m.ids[strings.ToUpper(id)] = true
+	}
+	return m, nil
+}
+
+// List returns the blacklisted identifiers, sorted, and whether the list is
+// known to match the device.
+func (m *Manager) List() ([]string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedLocked(), m.known
+}
+
+// Contains reports whether id was blacklisted through the Manager.
+func (m *Manager) Contains(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ids[strings.ToUpper(id)]
+}
+
+// Add blacklists an identifier on the device.
+func (m *Manager) Add(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id = strings.ToUpper(id)
+	if _, err := m.client.BlacklistIdentifierCtx(ctx, id); err != nil {
+		m.uncertainLocked(err)
+		return err
+	}
+	m.ids[id] = true
+	return m.saveLocked()
+}
+
+// Remove removes an identifier from the device blacklist.
+func (m *Manager) Remove(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id = strings.ToUpper(id)
+	if _, err := m.client.UnblacklistIdentifierCtx(ctx, id); err != nil {
+		m.uncertainLocked(err)
+		return err
+	}
+	delete(m.ids, id)
+	return m.saveLocked()
+}
+
+// Clear empties the device blacklist. Afterwards the record is known to match
+// the device.
+func (m *Manager) Clear(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clearLocked(ctx)
+}
+
+func (m *Manager) clearLocked(ctx context.Context) error {
+	if _, err := m.client.ClearBlacklistCtx(ctx); err != nil {
+		m.known = false
+		m.saveLocked()
+		return err
+	}
+	clear(m.ids)
+	m.known = true
+	return m.saveLocked()
+}
+
+// Plan computes the operations that turn the device blacklist into desired,
+// without sending anything. It clears and re-adds everything only when the
+// current list is unknown: otherwise it applies the diff, so identifiers that
+// stay blacklisted are never unblocked, even for a moment.
+func (m *Manager) Plan(desired []string) ([]Result, error) {
+	want, err := normalize(desired)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.planLocked(want), nil
+}
+
+func (m *Manager) planLocked(want []string) []Result {
+	if !m.known {
+		plan := []Result{{Action: ActionClear}}
+		for _, id := range want {
+			plan = append(plan, Result{ID: id, Action: ActionAdd})
+		}
+		return plan
+	}
+
+	wanted := make(map[string]bool, len(want))
+	for _, id := range want {
+		wanted[id] = true
+	}
+	var adds, removes, keeps []Result
+	for _, id := range want {
+		if m.ids[id] {
+			keeps = append(keeps, Result{ID: id, Action: ActionKeep})
+		} else {
+			adds = append(adds, Result{ID: id, Action: ActionAdd})
+		}
+	}
+	for _, id := range m.sortedLocked() {
+		if !wanted[id] {
+			removes = append(removes, Result{ID: id, Action: ActionRemove})
+		}
+	}
+
+	plan := append(removes, adds...)
+	return append(plan, keeps...)
+}
+
+// Sync makes the device blacklist equal to desired and reports the outcome of
+// every entry. Entries the device rejects are reported and skipped; a
+// connection error or a failed clear aborts the remaining entries, which are
+// reported as not attempted. The returned error is the first failure.
+func (m *Manager) Sync(ctx context.Context, desired []string) ([]Result, error) {
+	want, err := normalize(desired)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plan := m.planLocked(want)
+	var first, abort error
+	for i := range plan {
+		r := &plan[i]
+		if abort != nil {
+			r.Error = "not attempted: " + abort.Error()
+			continue
+		}
+
+		var err error
+		switch r.Action {
+		case ActionClear:
+			err = m.clearLocked(ctx)
+			if err != nil {
+				abort = err
+			}
+		case ActionAdd:
+			if _, err = m.client.BlacklistIdentifierCtx(ctx, r.ID); err == nil {
+				m.ids[r.ID] = true
+			}
+		case ActionRemove:
+			if _, err = m.client.UnblacklistIdentifierCtx(ctx, r.ID); err == nil {
+				delete(m.ids, r.ID)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		r.Error = err.Error()
+		if first == nil {
+			first = err
+		}
+		if m.uncertainLocked(err) {
+			abort = err
+		}
+	}
+
+	if err := m.saveLocked(); err != nil && first == nil {
+		first = err
+	}
+	return plan, first
+}
+
+// uncertainLocked marks the record as unknown when err leaves it unclear
+// whether the command reached the device, i.e. for anything but a device
+// answer. It reports whether it did.
+func (m *Manager) uncertainLocked(err error) bool {
+	var perr *companytec.ProtocolError
+	if errors.As(err, &perr) || errors.Is(err, companytec.ErrInvalidParameter) {
+		return false
+	}
+	m.known = false
+	m.saveLocked()
+	return true
+}
+
+func (m *Manager) sortedLocked() []string {
+	ids := make([]string, 0, len(m.ids))
+	for id := range m.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// saveLocked writes the state file atomically.
+func (m *Manager) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state{Known: m.known, IDs: m.sortedLocked()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// normalize upper-cases, validates and deduplicates identifiers, keeping the
+// first occurrence order.
+func normalize(ids []string) ([]string, error) {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.ToUpper(strings.TrimSpace(id))
+		if !isIdentifier(id) {
+			return nil, fmt.Errorf("%w: identifier must be 16 hex chars, got %q", companytec.ErrInvalidParameter, id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+func isIdentifier(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, r := range id {
+		if !strings.ContainsRune("0123456789ABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
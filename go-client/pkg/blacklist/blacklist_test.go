@@ -0,0 +1,175 @@
+package blacklist
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+const (
+	id1 = "B328000000000001"
+	id2 = "B328000000000002"
+	id3 = "B328000000000003"
+)
+
+// newManager returns a manager for a simulated device.
+func newManager(t *testing.T, path string) (*Manager, *simulator.Simulator) {
+	t.Helper()
+	sim := simulator.New(simulator.Config{})
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	client := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Disconnect)
+	m, err := New(client, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, sim
+}
+
+func actions(results []Result) []string {
+	var out []string
+	for _, r := range results {
+		out = append(out, string(r.Action)+" "+r.ID)
+	}
+	return out
+}
+
+func TestAddRemove(t *testing.T) {
+	ctx := context.Background()
+	m, sim := newManager(t, "")
+
+	if err := m.Add(ctx, "b328000000000001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(ctx, id2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove(ctx, id1); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.Blacklist(); !slices.Equal(got, []string{id2}) {
+		t.Errorf("device blacklist %v", got)
+	}
+	ids, known := m.List()
+	if !slices.Equal(ids, []string{id2}) || known {
+		t.Errorf("list %v, known %v: the blacklist was never cleared", ids, known)
+	}
+	if !m.Contains(id2) || m.Contains(id1) {
+		t.Error("Contains disagrees with List")
+	}
+
+	if err := m.Add(ctx, "B328"); !errors.Is(err, companytec.ErrInvalidParameter) {
+		t.Errorf("short identifier: %v", err)
+	}
+	if err := m.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ids, known := m.List(); len(ids) != 0 || !known {
+		t.Errorf("after clear: %v, known %v", ids, known)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	m, sim := newManager(t, "")
+
+	// The device blacklist is unknown: Sync starts by clearing it
+	results, err := m.Sync(ctx, []string{id1, id2, id1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"clear ", "add " + id1, "add " + id2}
+	if got := actions(results); !slices.Equal(got, want) {
+		t.Errorf("first sync %q, want %q", got, want)
+	}
+
+	// Then only the difference is applied
+	results, err = m.Sync(ctx, []string{id2, id3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"remove " + id1, "add " + id3, "keep " + id2}
+	if got := actions(results); !slices.Equal(got, want) {
+		t.Errorf("second sync %q, want %q", got, want)
+	}
+	if got := sim.Blacklist(); !slices.Equal(got, []string{id2, id3}) {
+		t.Errorf("device blacklist %v", got)
+	}
+
+	// Plan sends nothing. A known list is never cleared, even when that
+	// takes fewer commands: id2 would be accepted until it was re-added
+	plan, err := m.Plan([]string{id2, id1, "B328000000000004", "B328000000000005"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"remove " + id3, "add " + id1, "add B328000000000004", "add B328000000000005", "keep " + id2}
+	if got := actions(plan); !slices.Equal(got, want) {
+		t.Errorf("plan %q, want %q", got, want)
+	}
+	if plan, _ := m.Plan(nil); !slices.Equal(actions(plan), []string{"remove " + id2, "remove " + id3}) {
+		t.Errorf("plan to empty the blacklist %q", actions(plan))
+	}
+	if len(sim.Blacklist()) != 2 {
+		t.Error("Plan changed the device")
+	}
+
+	if _, err := m.Sync(ctx, []string{"not an id"}); !errors.Is(err, companytec.ErrInvalidParameter) {
+		t.Errorf("invalid identifier: %v", err)
+	}
+}
+
+func TestSyncConnectionLost(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newManager(t, "")
+	if err := m.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m.client.Disconnect()
+	results, err := m.Sync(ctx, []string{id1, id2})
+	if err == nil {
+		t.Fatal("sync succeeded without a connection")
+	}
+	if len(results) != 2 || results[0].Error == "" || results[1].Error != "not attempted: "+err.Error() {
+		t.Errorf("results %+v", results)
+	}
+	if _, known := m.List(); known {
+		t.Error("the record is still known after a command may have been lost")
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "blacklist.json")
+	m, _ := newManager(t, path)
+	if _, err := m.Sync(ctx, []string{id1, id2}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ = newManager(t, path)
+	ids, known := m.List()
+	if !slices.Equal(ids, []string{id1, id2}) || !known {
+		t.Errorf("reloaded %v, known %v", ids, known)
+	}
+	plan, err := m.Plan([]string{id1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan); !slices.Equal(got, []string{"remove " + id2, "keep " + id1}) {
+		t.Errorf("plan from the reloaded state %q", got)
+	}
+}
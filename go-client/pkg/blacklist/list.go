@@ -0,0 +1,96 @@
+package blacklist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format of a desired blacklist file.
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+// Parse reads a desired blacklist.
+//
+// CSV: the identifier is the first column; a header row and blank lines are
+// skipped. JSON: either an array of identifiers or an array of objects with an
+// "id" field, e.g. [{"id": "B328000000000001", "name": "lost card"}].
+func Parse(r io.Reader, format Format) ([]string, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatJSON:
+		return parseJSON(r)
+	}
+	return nil, fmt.Errorf("unknown blacklist format %q", format)
+}
+
+// ReadFile reads a desired blacklist, taking the format from the extension
+// (.json, anything else is CSV).
+func ReadFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	format := FormatCSV
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = FormatJSON
+	}
+	return Parse(f, format)
+}
+
+func parseCSV(r io.Reader) ([]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var ids []string
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSpace(rec[0])
+		if id == "" {
+			continue
+		}
+		if line == 1 && !isIdentifier(strings.ToUpper(id)) {
+			continue // header
+		}
+		ids = append(ids, id)
+	}
+}
+
+func parseJSON(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err == nil {
+		return ids, nil
+	}
+	var entries []struct {
+		ID string `json:"id"`
+	}
+	ids = nil
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("blacklist must be an array of identifiers or of {\"id\": ...} objects: %w", err)
+	}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids, nil
+}
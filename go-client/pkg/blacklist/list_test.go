@@ -0,0 +1,61 @@
+package blacklist
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		format Format
+		input  string
+		want   []string
+	}{
+		{FormatCSV, "id,name\nB328000000000001,lost card\n\n B328000000000002\n", []string{"B328000000000001", "B328000000000002"}},
+		{FormatCSV, "b328000000000001\n", []string{"b328000000000001"}},
+		{FormatJSON, `["B328000000000001","B328000000000002"]`, []string{"B328000000000001", "B328000000000002"}},
+		{FormatJSON, `[{"id":"B328000000000001","name":"lost card"}]`, []string{"B328000000000001"}},
+		{FormatJSON, `[]`, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(strings.NewReader(tt.input), tt.format)
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.format, tt.input, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s %q: %q, want %q", tt.format, tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{`{"id":"B328000000000001"}`, `[1,2]`} {
+		if _, err := Parse(strings.NewReader(input), FormatJSON); err == nil {
+			t.Errorf("JSON %q accepted", input)
+		}
+	}
+	if _, err := Parse(strings.NewReader(""), "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"list.json": `["B328000000000001"]`,
+		"list.csv":  "B328000000000001\n",
+		"list.txt":  "B328000000000001\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := ReadFile(path)
+		if err != nil || !slices.Equal(ids, []string{"B328000000000001"}) {
+			t.Errorf("%s: %q, %v", name, ids, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package cache keeps the decoded responses of read-only commands for a short
+// time, so that concurrent readers of status, visualization, totals and prices
+// do not each query the device.
+//
+// Concurrent misses of the same value are fetched once. Writes made through
+// the Cache, or reported with Invalidate, drop the values of the nozzle they
+// touch and the device-wide status and visualization.
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// Config configures a Cache. Zero TTLs get the defaults; a negative TTL
+// disables caching of that command, concurrent reads are still fetched once.
+type Config struct {
+	// StatusTTL is the lifetime of &S status. Defaults to 500ms.
+	StatusTTL time.Duration
+	// VisualizationTTL is the lifetime of &V visualization. Defaults to
+	// 500ms.
+	VisualizationTTL time.Duration
+	// TotalTTL is the lifetime of &T totalizers. Defaults to 1 second.
+	TotalTTL time.Duration
+	// PriceTTL is the lifetime of &T unit prices. Defaults to 5 seconds.
+	PriceTTL time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.StatusTTL == 0 {
+		cfg.StatusTTL = 500 * time.Millisecond
+	}
+	if cfg.VisualizationTTL == 0 {
+		cfg.VisualizationTTL = 500 * time.Millisecond
+	}
+	if cfg.TotalTTL == 0 {
+		cfg.TotalTTL = time.Second
+	}
+	if cfg.PriceTTL == 0 {
+		cfg.PriceTTL = 5 * time.Second
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+}
+
+// Info describes where a value came from.
+type Info struct {
+	// Hit is set when the value was not fetched for this call: it was cached
+	// or fetched by a concurrent call.
+	Hit bool
+	// Age is the time since the value was read from the device.
+	Age time.Duration
+}
+
+// Stats counts the cache lookups.
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Shared        uint64 // Misses answered by a concurrent fetch
+	Invalidations uint64
+}
+
+// Cache caches the reads of a client. Values are shared between callers and
+// must not be modified. Its methods are safe for concurrent use.
+type Cache struct {
+	client *companytec.Client
+	cfg    Config
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	flight  map[string]*call
+	// gen is incremented by invalidations; a fetch started before one is
+	// not stored.
+	gen   uint64
+	stats Stats
+}
+
+type entry struct {
+	value  any
+	at     time.Time
+	nozzle string // Empty for device-wide values
+}
+
+type call struct {
+	done  chan struct{}
+	value any
+	at    time.Time
+	err   error
+}
+
+// New creates a Cache for client.
+func New(client *companytec.Client, cfg Config) *Cache {
+	cfg.setDefaults()
+	return &Cache{
+		client:  client,
+		cfg:     cfg,
+		entries: make(map[string]*entry),
+		flight:  make(map[string]*call),
+	}
+}
+
+// Status returns the status of every nozzle (&S).
+func (c *Cache) Status(ctx context.Context) ([]companytec.NozzleStatus, Info, error) {
+	return get(c, ctx, "S", "", c.cfg.StatusTTL, c.client.StatusCtx)
+}
+
+// Visualization returns the visualization of the dispensing nozzles (&V).
+func (c *Cache) Visualization(ctx context.Context) ([]companytec.VisualizationEntry, Info, error) {
+	return get(c, ctx, "V", "", c.cfg.VisualizationTTL, c.client.VisualizationCtx)
+}
+
+// Total returns a totalizer of a nozzle. Mode: L=Volume, $=Value
+func (c *Cache) Total(ctx context.Context, nozzle, mode string) (*companytec.Total, Info, error) {
+	return get(c, ctx, "T"+nozzle+mode, nozzle, c.cfg.TotalTTL, func(ctx context.Context) (*companytec.Total, error) {
+		return c.client.TotalCtx(ctx, nozzle, mode)
+	})
+}
+
+// Price returns the unit prices of a nozzle. Mode: U=2 levels, u=3 levels
+func (c *Cache) Price(ctx context.Context, nozzle, mode string) (*companytec.Price, Info, error) {
+	return get(c, ctx, "U"+nozzle+mode, nozzle, c.cfg.PriceTTL, func(ctx context.Context) (*companytec.Price, error) {
+		return c.client.PriceCtx(ctx, nozzle, mode)
+	})
+}
+
+// ChangePrice changes a unit price and invalidates the nozzle.
+func (c *Cache) ChangePrice(ctx context.Context, nozzle, level, price string) (string, error) {
+	defer c.Invalidate(nozzle)
+	return c.client.ChangePriceCtx(ctx, nozzle, level, price)
+}
+
+// SetOperatingMode changes the operating mode of a nozzle and invalidates it.
+func (c *Cache) SetOperatingMode(ctx context.Context, nozzle, mode string) (string, error) {
+	defer c.Invalidate(nozzle)
+	return c.client.SetOperatingModeCtx(ctx, nozzle, mode)
+}
+
+// SetPreset presets a nozzle and invalidates it.
+func (c *Cache) SetPreset(ctx context.Context, nozzle, value string) (string, error) {
+	defer c.Invalidate(nozzle)
+	return c.client.SetPresetCtx(ctx, nozzle, value)
+}
+
+// SetPresetIdentified presets a nozzle for an identifier and invalidates it.
+func (c *Cache) SetPresetIdentified(ctx context.Context, p companytec.IdentifiedPreset) (string, error) {
+	defer c.Invalidate(p.Nozzle)
+	return c.client.SetPresetIdentifiedCtx(ctx, p)
+}
+
+// Invalidate drops the values of nozzle and the device-wide values. Call it
+// after writing to the device without going through the Cache; a failed
+// write invalidates too, since the device may have applied it.
+func (c *Cache) Invalidate(nozzle string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.stats.Invalidations++
+	for key, e := range c.entries {
+		if e.nozzle == "" || strings.EqualFold(e.nozzle, nozzle) {
+			delete(c.entries, key)
+		}
+	}
+	// Reads in flight may predate the write: later reads do not join them
+	clear(c.flight)
+}
+
+// Stats returns the lookup counters.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func get[T any](c *Cache, ctx context.Context, key, nozzle string, ttl time.Duration, fetch func(context.Context) (T, error)) (T, Info, error) {
+	for {
+		c.mu.Lock()
+		now := c.cfg.Now()
+		if e, ok := c.entries[key]; ok {
+			if age := now.Sub(e.at); age < ttl {
+				c.stats.Hits++
+				c.mu.Unlock()
+				return e.value.(T), Info{Hit: true, Age: age}, nil
+			}
+			delete(c.entries, key)
+		}
+
+		cl, ok := c.flight[key]
+		if !ok {
+			cl = &call{done: make(chan struct{})}
+			c.flight[key] = cl
+			gen := c.gen
+			c.stats.Misses++
+			c.mu.Unlock()
+
+			v, err := fetch(ctx)
+			c.mu.Lock()
+			cl.value, cl.err, cl.at = v, err, c.cfg.Now()
+			if c.flight[key] == cl {
+				delete(c.flight, key)
+			}
+			if err == nil && ttl > 0 && gen == c.gen {
+				c.entries[key] = &entry{value: v, at: cl.at, nozzle: nozzle}
+			}
+			c.mu.Unlock()
+			close(cl.done)
+			return v, Info{}, err
+		}
+		c.stats.Shared++
+		c.mu.Unlock()
+
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			var zero T
+			return zero, Info{}, ctx.Err()
+		}
+		if isContextError(cl.err) && ctx.Err() == nil {
+			// The fetching call gave up, not the device
+			continue
+		}
+		if cl.err != nil {
+			var zero T
+			return zero, Info{}, cl.err
+		}
+		return cl.value.(T), Info{Hit: true, Age: c.cfg.Now().Sub(cl.at)}, nil
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+// clock is a manual clock for Config.Now.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// newCache returns a cache of a client talking to sim, whose TTLs run on clk.
+func newCache(t *testing.T, sim *simulator.Simulator, clk *clock, cfg Config) *Cache {
+	t.Helper()
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	client := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Disconnect)
+	cfg.Now = clk.Now
+	return New(client, cfg)
+}
+
+func TestTTL(t *testing.T) {
+	clk := &clock{t: time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)}
+	c := newCache(t, simulator.New(simulator.Config{}), clk, Config{})
+	ctx := context.Background()
+
+	if _, info, err := c.Status(ctx); err != nil || info.Hit {
+		t.Fatalf("first status: %+v, %v", info, err)
+	}
+	clk.Advance(300 * time.Millisecond)
+	if _, info, err := c.Status(ctx); err != nil || !info.Hit || info.Age != 300*time.Millisecond {
+		t.Fatalf("cached status: %+v, %v", info, err)
+	}
+	clk.Advance(200 * time.Millisecond)
+	if _, info, err := c.Status(ctx); err != nil || info.Hit {
+		t.Fatalf("expired status: %+v, %v", info, err)
+	}
+
+	// Prices outlive the status
+	if _, _, err := c.Price(ctx, "01", "U"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(4 * time.Second)
+	if _, info, _ := c.Price(ctx, "01", "U"); !info.Hit {
+		t.Error("price expired before its TTL")
+	}
+	if _, info, _ := c.Price(ctx, "01", "u"); info.Hit {
+		t.Error("the 3-level price was served from the 2-level one")
+	}
+
+	if st := c.Stats(); st != (Stats{Hits: 2, Misses: 4}) {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	clk := &clock{t: time.Now()}
+	c := newCache(t, simulator.New(simulator.Config{}), clk, Config{TotalTTL: -1})
+	for range 2 {
+		if _, info, err := c.Total(context.Background(), "01", "L"); err != nil || info.Hit {
+			t.Fatalf("uncached total: %+v, %v", info, err)
+		}
+	}
+}
+
+func TestWritesInvalidate(t *testing.T) {
+	clk := &clock{t: time.Now()}
+	c := newCache(t, simulator.New(simulator.Config{}), clk, Config{})
+	ctx := context.Background()
+
+	for _, nozzle := range []string{"01", "02"} {
+		if _, _, err := c.Price(ctx, nozzle, "U"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Status(ctx)
+	if _, err := c.ChangePrice(ctx, "01", "0", "6199"); err != nil {
+		t.Fatal(err)
+	}
+	price, info, err := c.Price(ctx, "01", "U")
+	if err != nil || info.Hit || price.Levels[0] != 6199 {
+		t.Errorf("price after the change: %+v, %+v, %v", price, info, err)
+	}
+	if _, info, _ := c.Price(ctx, "02", "U"); !info.Hit {
+		t.Error("the change dropped the price of another nozzle")
+	}
+	if _, info, _ := c.Status(ctx); info.Hit {
+		t.Error("the change kept the device status")
+	}
+
+	// A refused write invalidates too
+	if _, err := c.SetOperatingMode(ctx, "02", "B"); err != nil {
+		t.Fatal(err)
+	}
+	c.Price(ctx, "02", "U")
+	if _, err := c.SetPreset(ctx, "02", "1000"); err == nil {
+		t.Fatal("preset accepted on a blocked nozzle")
+	}
+	if _, info, _ := c.Price(ctx, "02", "U"); info.Hit {
+		t.Error("a failed preset kept the nozzle values")
+	}
+	if _, err := c.SetPresetIdentified(ctx, companytec.IdentifiedPreset{Nozzle: "02"}); err == nil {
+		t.Error("incomplete identified preset accepted")
+	}
+	if st := c.Stats(); st.Invalidations != 4 {
+		t.Errorf("%d invalidations, want 4", st.Invalidations)
+	}
+}
+
+func TestConcurrentMisses(t *testing.T) {
+	c := New(nil, Config{})
+	release := make(chan struct{})
+	fetches := 0
+	fetch := func(ctx context.Context) (int, error) {
+		fetches++
+		<-release
+		return fetches, nil
+	}
+	inFlight := func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.flight) > 0
+	}
+
+	first := make(chan int)
+	go func() {
+		v, _, _ := get(c, context.Background(), "k", "", time.Second, fetch)
+		first <- v
+	}()
+	for !inFlight() {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan Info)
+	go func() {
+		_, info, _ := get(c, context.Background(), "k", "", time.Second, fetch)
+		second <- info
+	}()
+	for c.Stats().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if v := <-first; v != 1 {
+		t.Errorf("first read %d", v)
+	}
+	if info := <-second; !info.Hit {
+		t.Errorf("shared read %+v", info)
+	}
+	if fetches != 1 {
+		t.Errorf("%d fetches, want 1", fetches)
+	}
+}
+
+func TestInvalidateDuringFetch(t *testing.T) {
+	c := New(nil, Config{})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		get(c, context.Background(), "k", "01", time.Second, func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+		close(done)
+	}()
+	<-started
+	c.Invalidate("01")
+	close(release)
+	<-done
+
+	// The value read before the write is not kept
+	v, info, _ := get(c, context.Background(), "k", "01", time.Second, func(ctx context.Context) (int, error) {
+		return 2, nil
+	})
+	if v != 2 || info.Hit {
+		t.Errorf("read after the write: %d, %+v", v, info)
+	}
+}
+
+func TestFetcherGivesUp(t *testing.T) {
+	c := New(nil, Config{})
+	started := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go get(c, ctx, "k", "", time.Second, func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	<-started
+	done := make(chan error)
+	go func() {
+		v, _, err := get(c, context.Background(), "k", "", time.Second, func(ctx context.Context) (int, error) {
+			return 3, nil
+		})
+		if err == nil && v != 3 {
+			err = errors.New("wrong value")
+		}
+		done <- err
+	}()
+	for c.Stats().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	// The waiting read fetches again instead of failing with the context
+	// error of the first
+	if err := <-done; err != nil {
+		t.Errorf("waiting read: %v", err)
+	}
+}
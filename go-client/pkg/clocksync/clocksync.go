@@ -0,0 +1,248 @@
+// Package clocksync keeps the device clock in step with the host clock.
+//
+// The device clock only has second resolution (minute resolution for the
+// legacy &R/&H calendar), so skew is estimated the way NTP does it: the host
+// time of a reading is taken as the midpoint of the round trip.
+package clocksync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// Config configures a Service. Zero values get sensible defaults.
+type Config struct {
+	// Interval between two checks. Defaults to 10 minutes.
+	Interval time.Duration
+	// Threshold is the skew above which the device clock is corrected.
+	// Defaults to 2 seconds, or 1 minute with Calendar.
+	Threshold time.Duration
+	// Calendar uses the legacy &R/&H calendar instead of &KR1/&KW1, for
+	// devices without the extended clock.
+	Calendar bool
+	// Location is the time zone the device clock is kept in. Defaults to
+	// time.Local.
+	Location *time.Location
+	// Now returns the host time. Defaults to time.Now.
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 2 * time.Second
+		if cfg.Calendar {
+			cfg.Threshold = time.Minute
+		}
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+}
+
+// Measurement is a single comparison of the device and host clocks.
+type Measurement struct {
+	Device    time.Time
+	Host      time.Time     // Host time at the middle of the round trip
+	Skew      time.Duration // Device minus host; positive when the device is ahead
+	RoundTrip time.Duration
+}
+
+// Report is the outcome of a check. The measurement is the one taken before
+// any correction.
+type Report struct {
+	Measurement
+	CheckedAt time.Time
+	Corrected bool
+	Error     string
+}
+
+// Status summarizes the service for the API.
+type Status struct {
+	Interval       time.Duration
+	Threshold      time.Duration
+	Last           *Report
+	Corrections    int
+	LastCorrection *time.Time
+}
+
+// Service periodically measures the device clock skew and corrects it when it
+// exceeds the threshold. Its methods are safe for concurrent use.
+type Service struct {
+	client *companytec.Client
+	cfg    Config
+
+	mu          sync.Mutex
+	last        *Report
+	corrections int
+	lastCorrect time.Time
+}
+
+// New creates a Service for client. Call Run to start the periodic checks.
+func New(client *companytec.Client, cfg Config) *Service {
+	cfg.setDefaults()
+	return &Service{client: client, cfg: cfg}
+}
+
+// Run checks the clock immediately and then every Interval until ctx is done.
+// Failed checks are logged and recorded in the status; they do not stop Run.
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Check(ctx); err != nil && ctx.Err() == nil {
+			s.cfg.Logger.Warn("clock check failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Measure compares the device clock with the host clock without changing it.
+func (s *Service) Measure(ctx context.Context) (Measurement, error) {
+	start := s.cfg.Now()
+	var clock *companytec.ClockReading
+	var err error
+	if s.cfg.Calendar {
+		clock, err = s.client.CalendarCtx(ctx)
+	} else {
+		clock, err = s.client.ClockExtendedCtx(ctx)
+	}
+	if err != nil {
+		return Measurement{}, err
+	}
+	rtt := s.cfg.Now().Sub(start)
+
+	host := start.Add(rtt / 2).In(s.cfg.Location)
+	device := clock.Time(host)
+	if s.cfg.Calendar {
+		// The calendar has no seconds; compare at minute resolution
+		host = host.Truncate(time.Minute)
+	} else {
+		host = host.Truncate(time.Second)
+	}
+	return Measurement{
+		Device:    device,
+		Host:      host,
+		Skew:      device.Sub(host),
+		RoundTrip: rtt,
+	}, nil
+}
+
+// Check measures the skew and corrects the device clock when it exceeds the
+// threshold.
+func (s *Service) Check(ctx context.Context) (Report, error) {
+	m, err := s.Measure(ctx)
+	report := Report{Measurement: m, CheckedAt: s.cfg.Now()}
+	if err == nil && abs(m.Skew) > s.cfg.Threshold {
+		s.cfg.Logger.Info("correcting device clock", "skew", m.Skew)
+		if err = s.set(ctx, m.RoundTrip); err == nil {
+			report.Corrected = true
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	s.record(report)
+	return report, err
+}
+
+// Sync sets the device clock to the host time regardless of the skew.
+func (s *Service) Sync(ctx context.Context) (Report, error) {
+	m, err := s.Measure(ctx)
+	report := Report{Measurement: m, CheckedAt: s.cfg.Now()}
+	if err == nil {
+		err = s.set(ctx, m.RoundTrip)
+	}
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		report.Corrected = true
+	}
+	s.record(report)
+	return report, err
+}
+
+// set writes the host time to the device. The device only takes whole
+// seconds, so it waits for the next second boundary, less the estimated one
+// way delay, and sends the time of that boundary.
+func (s *Service) set(ctx context.Context, rtt time.Duration) error {
+	var err error
+	if s.cfg.Calendar {
+		_, err = s.client.SetCalendarCtx(ctx, s.cfg.Now().In(s.cfg.Location))
+		return err
+	}
+
+	now := s.cfg.Now()
+	target := now.Truncate(time.Second).Add(time.Second)
+	if wait := target.Sub(now) - rtt/2; wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	_, err = s.client.SetClockExtendedCtx(ctx, target.In(s.cfg.Location))
+	return err
+}
+
+func (s *Service) record(r Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = &r
+	if r.Corrected {
+		s.corrections++
+		s.lastCorrect = r.CheckedAt
+	}
+}
+
+// Status returns the last report and correction counters.
+func (s *Service) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		Interval:    s.cfg.Interval,
+		Threshold:   s.cfg.Threshold,
+		Corrections: s.corrections,
+	}
+	if s.last != nil {
+		last := *s.last
+		st.Last = &last
+	}
+	if !s.lastCorrect.IsZero() {
+		t := s.lastCorrect
+		st.LastCorrection = &t
+	}
+	return st
+}
+
+func (m Measurement) String() string {
+	return fmt.Sprintf("device %s, host %s, skew %s (rtt %s)",
+		m.Device.Format(time.DateTime), m.Host.Format(time.DateTime), m.Skew, m.RoundTrip)
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,159 @@
+package clocksync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+// clock is a manual host clock shared by the simulator and the service.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// newService returns a service for a simulated device whose clock is offset
+// from the host clock. The host clock stands just before a second boundary
+// so setting the extended clock does not wait.
+func newService(t *testing.T, offset time.Duration, cfg Config) *Service {
+	t.Helper()
+	clk := &clock{t: time.Date(2024, 3, 15, 10, 30, 0, 999e6, time.UTC)}
+	sim := simulator.New(simulator.Config{Now: clk.Now, ClockOffset: offset})
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	client := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Disconnect)
+
+	cfg.Now = clk.Now
+	cfg.Location = time.UTC
+	return New(client, cfg)
+}
+
+func TestMeasure(t *testing.T) {
+	s := newService(t, -90*time.Second, Config{})
+	m, err := s.Measure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Skew != -90*time.Second {
+		t.Errorf("skew %s, want -1m30s (%s)", m.Skew, m)
+	}
+	if st := s.Status(); st.Last != nil || st.Corrections != 0 {
+		t.Errorf("Measure recorded a report: %+v", st)
+	}
+}
+
+func TestCheckCorrectsDrift(t *testing.T) {
+	s := newService(t, 45*time.Second, Config{})
+	report, err := s.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Corrected || report.Skew != 45*time.Second {
+		t.Errorf("report %+v", report)
+	}
+
+	// The device clock has second resolution
+	m, err := s.Measure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if abs(m.Skew) > time.Second {
+		t.Errorf("skew %s after the correction", m.Skew)
+	}
+	report, err = s.Check(context.Background())
+	if err != nil || report.Corrected {
+		t.Errorf("second check: %+v, %v", report, err)
+	}
+
+	st := s.Status()
+	if st.Corrections != 1 || st.LastCorrection == nil || st.Last == nil || st.Last.Corrected {
+		t.Errorf("status %+v", st)
+	}
+}
+
+func TestCheckWithinThreshold(t *testing.T) {
+	s := newService(t, time.Second, Config{Threshold: 5 * time.Second})
+	report, err := s.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Corrected {
+		t.Errorf("a skew of %s below the threshold was corrected", report.Skew)
+	}
+
+	report, err = s.Sync(context.Background())
+	if err != nil || !report.Corrected {
+		t.Errorf("sync: %+v, %v", report, err)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	s := newService(t, 5*time.Minute, Config{Calendar: true})
+	if s.cfg.Threshold != time.Minute {
+		t.Errorf("calendar threshold %s, want 1m", s.cfg.Threshold)
+	}
+	report, err := s.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Corrected || report.Skew != 5*time.Minute {
+		t.Errorf("report %+v", report)
+	}
+	m, err := s.Measure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Skew != 0 {
+		t.Errorf("skew %s after the correction", m.Skew)
+	}
+}
+
+func TestCheckError(t *testing.T) {
+	s := newService(t, 0, Config{})
+	s.client.Disconnect()
+	if _, err := s.Check(context.Background()); err == nil {
+		t.Fatal("check succeeded on a closed client")
+	}
+	if st := s.Status(); st.Last == nil || st.Last.Error == "" {
+		t.Errorf("failed check not recorded: %+v", st)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newService(t, 30*time.Second, Config{Interval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	// Run checks at once, then waits for the interval
+	deadline := time.Now().Add(time.Second)
+	for s.Status().Last == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v", err)
+	}
+	if st := s.Status(); st.Corrections != 1 {
+		t.Errorf("status %+v after the first check", st)
+	}
+}
@@ -0,0 +1,47 @@
+package companytec
+
+import (
+	"context"
+	"strings"
+)
+
+// ClearBlacklist removes every identifier from the device blacklist (&M99 c).
+func (c *Client) ClearBlacklist() (string, error) {
+	return c.ClearBlacklistCtx(context.Background())
+}
+
+// ClearBlacklistCtx is like ClearBlacklist but honours ctx.
+func (c *Client) ClearBlacklistCtx(ctx context.Context) (string, error) {
+	cmd := c.BuildCommand("&M99", "c")
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// BlacklistIdentifier adds an identifier to the device blacklist (&M99 b).
+// Blacklisted identifiers cannot authorize supplies.
+func (c *Client) BlacklistIdentifier(id string) (string, error) {
+	return c.BlacklistIdentifierCtx(context.Background(), id)
+}
+
+// BlacklistIdentifierCtx is like BlacklistIdentifier but honours ctx.
+func (c *Client) BlacklistIdentifierCtx(ctx context.Context, id string) (string, error) {
+	if err := checkIdentifier(id); err != nil {
+		return "", err
+	}
+	cmd := c.BuildCommand("&M99", "b"+strings.ToUpper(id))
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// UnblacklistIdentifier removes an identifier from the device blacklist
+// (&M99 l).
+func (c *Client) UnblacklistIdentifier(id string) (string, error) {
+	return c.UnblacklistIdentifierCtx(context.Background(), id)
+}
+
+// UnblacklistIdentifierCtx is like UnblacklistIdentifier but honours ctx.
+func (c *Client) UnblacklistIdentifierCtx(ctx context.Context, id string) (string, error) {
+	if err := checkIdentifier(id); err != nil {
+		return "", err
+	}
+	cmd := c.BuildCommand("&M99", "l"+strings.ToUpper(id))
+	return c.SendCommandCtx(ctx, cmd)
+}
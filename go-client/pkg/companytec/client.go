@@ -1,49 +1,125 @@
 package companytec
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"log/slog"
 	"net"
+	"strconv"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client handles communication with the Companytec device
 type Client struct {
-	host      string
-	port      int
+	addr      string
 	conn      net.Conn
+	decoder   *FrameDecoder // Frames of conn
 	connected bool
-	mu        sync.Mutex // Protects concurrent access to the connection
-	timeout   time.Duration
+	closed    bool     // Set by Disconnect; disables automatic reconnect
+	mu        ctxMutex // Protects concurrent access to the connection
+	sched     *scheduler
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	policy       ReconnectPolicy
+	dialer       Dialer
+	tlsConfig    *tls.Config
+	wrapConn     func(net.Conn) net.Conn
+	maxFrame     int
+	memorySize   int // Supplies in the device ring buffer
+	unsolicited  chan UnsolicitedFrame
+	logger       *slog.Logger
+	tracer       trace.Tracer
+	wireTrace    bool   // Log every frame, see WithWireTrace
+	wireSeq      uint64 // Exchanges traced so far; protected by mu
+
+	stateMu      sync.Mutex // Protects state and listeners
+	state        ConnState
+	listeners    map[int]func(StateChange)
+	cmdListeners map[int]func(context.Context, CommandResult)
+	nextListener int
 }
 
-// NewClient creates a new CompanytecClient
+// NewClient creates a new CompanytecClient with the default options
 func NewClient(host string, port int) *Client {
-	return &Client{
-		host:    host,
-		port:    port,
-		timeout: 5 * time.Second,
+	return New(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// New creates a Client for the device at addr (host:port). Without options
+// every operation times out after 5 seconds.
+func New(addr string, opts ...Option) *Client {
+	c := &Client{
+		addr:         addr,
+		mu:           newCtxMutex(),
+		sched:        newScheduler(DefaultQueueLimit),
+		readTimeout:  5 * time.Second,
+		writeTimeout: 5 * time.Second,
+		policy:       DefaultReconnectPolicy(),
+		dialer:       &net.Dialer{},
+		logger:       slog.New(slog.DiscardHandler),
+		tracer:       defaultTracer(),
+		maxFrame:     DefaultMaxFrameLength,
+		memorySize:   DefaultMemorySize,
+		unsolicited:  make(chan UnsolicitedFrame, unsolicitedBuffer),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetReconnectPolicy replaces the policy used to redial a lost connection.
+func (c *Client) SetReconnectPolicy(policy ReconnectPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
 }
 
 // Connect establishes connection to the device
 func (c *Client) Connect() error {
-	c.mu.Lock()
+	return c.ConnectCtx(context.Background())
+}
+
+// ConnectCtx is like Connect but gives up when ctx is done.
+func (c *Client) ConnectCtx(ctx context.Context) error {
+	if err := c.mu.LockCtx(ctx); err != nil {
+		return err
+	}
 	defer c.mu.Unlock()
 
+	c.closed = false
 	if c.connected && c.conn != nil {
 		return nil
 	}
 
-	address := fmt.Sprintf("%s:%d", c.host, c.port)
-	conn, err := net.DialTimeout("tcp", address, c.timeout)
+	if err := c.dialLocked(ctx); err != nil {
+		c.setState(StateDown, err)
+		return err
+	}
+	return nil
+}
+
+// dialLocked opens a new connection. c.mu must be held.
+func (c *Client) dialLocked(ctx context.Context) (err error) {
+	ctx, span := c.tracer.Start(ctx, "companytec dial", trace.WithAttributes(attrServerAddress.String(c.addr)))
+	defer func() { endSpan(span, err) }()
+	c.setState(StateConnecting, nil)
+
+	conn, err := c.dial(ctx)
 	if err != nil {
+		c.logger.Warn("dial failed", "addr", c.addr, "error", err)
 		return err
 	}
+	c.logger.Info("connected", "addr", c.addr)
 
 	c.conn = conn
+	c.decoder = NewFrameDecoder(conn, c.maxFrame)
 	c.connected = true
+	c.setState(StateConnected, nil)
 	return nil
 }
 
@@ -52,9 +128,17 @@ func (c *Client) Disconnect() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.closed = true
+	c.closeLocked()
+	c.setState(StateDown, nil)
+}
+
+// closeLocked drops the current connection. c.mu must be held.
+func (c *Client) closeLocked() {
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
+		c.decoder = nil
 	}
 	c.connected = false
 }
@@ -68,6 +152,12 @@ func (c *Client) IsConnected() bool {
 
 // calculateChecksum calculates sum of ASCII values and returns hex string of LSB
 func (c *Client) calculateChecksum(command string) string {
+	return Checksum(command)
+}
+
+// Checksum implements calculateChecksum for frames that are not tied to a
+// Client, such as responses being validated or built by a simulator.
+func Checksum(command string) string {
 	sum := 0
 	// Sum ASCII values of all characters except the delimiters (handled by passing inner string)
 	// JS: for (let i = 1; i < command.length; i++) -> JS implementation skips the first char?
@@ -77,11 +167,11 @@ func (c *Client) calculateChecksum(command string) string {
 	//   checksum = calc(body)
 	//     calc loop: starts at 1. So it skips '('.
 	//   return `${body}${checksum})`
-	
+
 	// Replicating JS logic exactly:
 	// If input is "(&A", loop starts at index 1 -> '&', 'A'
 	// '&' (38) + 'A' (65) = 103
-	
+
 	runes := []rune(command)
 	for i := 1; i < len(runes); i++ {
 		sum += int(runes[i])
@@ -99,131 +189,507 @@ func (c *Client) BuildCommand(header, parameters string) string {
 	return fmt.Sprintf("%s%s)", commandBody, checksum)
 }
 
-// SendCommand sends a command and waits for response
+// SendCommand sends a command and waits for response.
+// A lost connection is redialed following the reconnect policy, and read-only
+// commands are resent once on the new connection.
+//
+// Commands wait for the connection in a queue ordered by priority (see
+// CommandPriority); ErrQueueFull is returned when the queue is full. A
+// read-only command identical to one already in flight is not sent again but
+// shares its response.
 func (c *Client) SendCommand(command string) (string, error) {
-	c.mu.Lock()
+	return c.SendCommandCtx(context.Background(), command)
+}
+
+// SendCommandCtx is like SendCommand but gives up when ctx is done, whether it
+// is still waiting for the connection or already waiting for the response.
+func (c *Client) SendCommandCtx(ctx context.Context, command string) (string, error) {
+	if isIdempotent(command) {
+		return c.sched.coalesce(ctx, command, func() (string, error) {
+			return c.send(ctx, command)
+		})
+	}
+	return c.send(ctx, command)
+}
+
+// send waits for the connection in the queue, exchanges command and reports
+// it to the OnCommand observers.
+func (c *Client) send(ctx context.Context, command string) (string, error) {
+	res := CommandResult{
+		Command:  command,
+		Header:   CommandHeader(command),
+		Priority: priorityOf(ctx, command),
+		Start:    time.Now(),
+	}
+	ctx, span := c.tracer.Start(ctx, "companytec "+res.Header,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrHeader.String(res.Header),
+			attrPriority.String(res.Priority.String()),
+			attrBytesSent.Int(len(command)),
+		))
+	if nozzle := commandNozzle(command); nozzle != "" {
+		span.SetAttributes(attrNozzle.String(nozzle))
+	}
+
+	if err := c.sched.acquire(ctx, res.Priority); err != nil {
+		res.Wait = time.Since(res.Start)
+		res.Err = err
+		span.SetAttributes(attrQueueWait.Float64(msec(res.Wait)))
+		endSpan(span, err)
+		c.logFailure(ctx, res)
+		c.notifyCommand(ctx, res)
+		return "", err
+	}
+	held := time.Now()
+	res.Wait = held.Sub(res.Start)
+	span.SetAttributes(attrQueueWait.Float64(msec(res.Wait)))
+	func() {
+		defer c.sched.release()
+		res.Response, res.Err = c.exchange(ctx, command)
+	}()
+	res.Duration = time.Since(held)
+	span.SetAttributes(
+		attrBytesReceived.Int(len(res.Response)),
+		attrChecksum.String(checksumResult(command, res.Response, res.Err)),
+	)
+	endSpan(span, res.Err)
+	c.logFailure(ctx, res)
+	c.notifyCommand(ctx, res)
+	return res.Response, res.Err
+}
+
+// logFailure logs a failed command at warn level, or at debug level when the
+// caller gave up on it.
+func (c *Client) logFailure(ctx context.Context, res CommandResult) {
+	if res.Err == nil {
+		return
+	}
+	level := slog.LevelWarn
+	if isContextError(res.Err) {
+		level = slog.LevelDebug
+	}
+	c.logger.LogAttrs(ctx, level, "command failed",
+		slog.String("addr", c.addr),
+		slog.String("header", res.Header),
+		slog.String("error", RedactError(res.Err)),
+	)
+}
+
+// exchange sends command on the connection, redialing it if needed. The
+// caller holds the connection in the queue.
+func (c *Client) exchange(ctx context.Context, command string) (string, error) {
+	start := time.Now()
+	if err := c.mu.LockCtx(ctx); err != nil {
+		return "", err
+	}
 	defer c.mu.Unlock()
+	trace.SpanFromContext(ctx).SetAttributes(attrLockWait.Float64(msec(time.Since(start))))
 
 	if !c.connected || c.conn == nil {
-		return "", fmt.Errorf("not connected to device")
+		if c.closed {
+			return "", fmt.Errorf("not connected to device")
+		}
+		if err := c.reconnectLocked(ctx, nil); err != nil {
+			return "", err
+		}
 	}
 
-	// Set deadline for write and read
-	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	response, err := c.exchangeLocked(ctx, command)
+	if err != nil && contextErr(ctx) == nil && !c.connected && isIdempotent(command) {
+		if rerr := c.reconnectLocked(ctx, err); rerr != nil {
+			return "", fmt.Errorf("%v (%v)", err, rerr)
+		}
+		response, err = c.exchangeLocked(ctx, command)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := ValidateResponse(command, response); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// exchangeLocked writes a command and reads one response. On I/O errors the
+// connection is dropped. c.mu must be held.
+func (c *Client) exchangeLocked(ctx context.Context, command string) (response string, err error) {
+	ctx, span := c.tracer.Start(ctx, "companytec round trip")
+	defer func() {
+		span.SetAttributes(attrBytesReceived.Int(len(response)))
+		endSpan(span, err)
+	}()
+
+	// Set deadline for the write, bounded by the context deadline
+	conn := c.conn
+	conn.SetDeadline(deadlineCtx(ctx, c.writeTimeout))
+
+	// Cancellation wakes up a blocked write or read by expiring the deadline
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if c.wireTrace {
+		sent := time.Now()
+		c.wireSeq++
+		seq := c.wireSeq
+		c.traceTX(ctx, seq, command)
+		defer func() {
+			c.traceRX(ctx, seq, command, response, time.Since(sent), err)
+		}()
+	}
+
+	// Frames already read do not answer this command
+	for {
+		frame, ok := c.decoder.Buffered()
+		if !ok {
+			break
+		}
+		c.unsolicitedLocked("", frame)
+	}
 
 	// Write
-	_, err := c.conn.Write([]byte(command))
-	if err != nil {
-		c.Disconnect()
-		return "", fmt.Errorf("write error: %v", err)
-	}
-
-	// Read
-	// The protocol seems to end with ')'. We can read until then.
-	// However, the JS code just waits for 'data'.
-	// Safer to use a bufio Reader.
-	reader := bufio.NewReader(c.conn)
-	
-	// Because responses might contain binary data or specific formats, 
-	// but generally they look like `(&...)`.
-	// We'll read until we hit a reasonable end or just read a chunk if we can't determine boundary easily.
-	// Given typical simplistic TCP protocols, reading until ')' is common. 
-	// Use ReadString(')') but be careful if ')' is inside data (unlikely for this legacy protocol style).
-	// Let's assume ReadString(')') is correct based on BuildCommand ending in ')'.
-	
-	response, err := reader.ReadString(')')
+	_, err = conn.Write([]byte(command))
 	if err != nil {
-		// Try to recover any partial data if needed, but usually error means connection issue or timeout
-		if response == "" {
-			c.Disconnect()
-			return "", fmt.Errorf("read error: %v", err)
+		return "", c.ioErrorLocked(ctx, "write", err)
+	}
+
+	// Read, skipping the frames that do not answer the command
+	conn.SetReadDeadline(deadlineCtx(ctx, c.readTimeout))
+	dec := c.decoder
+	dropped := dec.Dropped()
+	for {
+		response, err = dec.Next()
+		if err != nil {
+			partial := dec.Discard()
+			rerr := c.ioErrorLocked(ctx, "read", err)
+			if partial == "" || contextErr(ctx) != nil {
+				return "", rerr
+			}
+			// The rest of the frame could still come on the connection, so it
+			// was dropped like after any failed read. Validation reports the
+			// incomplete frame as truncated.
+			return partial, nil
+		}
+		if answers(command, response) {
+			break
 		}
-		// If we got some data but hit EOF/error
+		c.unsolicitedLocked(command, response)
+	}
+	if n := dec.Dropped() - dropped; n > 0 {
+		c.logger.Warn("noise dropped", "addr", c.addr, "bytes", n)
 	}
 
 	// Clear deadline
 	c.conn.SetDeadline(time.Time{})
-	
+
 	return response, nil
 }
 
+// deadlineCtx returns the deadline for an operation bounded by timeout and by
+// the context deadline. A zero timeout only uses the context deadline.
+func deadlineCtx(ctx context.Context, timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return deadline
+}
+
+// contextErr is like ctx.Err but also reports context.DeadlineExceeded as
+// soon as the deadline of ctx has passed: a connection deadline taken from ctx
+// can expire a moment before ctx itself is done.
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// ioErrorLocked drops the connection after a failed write or read and builds
+// the error to return. When ctx caused the failure, its error is returned so
+// callers can match context.Canceled and context.DeadlineExceeded; the
+// connection is still dropped since a late response would otherwise be read
+// as the answer to the next command. c.mu must be held.
+func (c *Client) ioErrorLocked(ctx context.Context, op string, err error) error {
+	c.closeLocked()
+	c.logger.Warn("connection dropped", "addr", c.addr, "op", op, "error", err)
+	if ctxErr := contextErr(ctx); ctxErr != nil {
+		c.setState(StateDegraded, ctxErr)
+		return fmt.Errorf("%s interrupted: %w", op, ctxErr)
+	}
+	c.setState(StateDegraded, err)
+	return fmt.Errorf("%s error: %v", op, err)
+}
+
+// ctxMutex is a mutex whose Lock can be abandoned when a context is done.
+type ctxMutex struct {
+	ch chan struct{}
+}
+
+func newCtxMutex() ctxMutex {
+	return ctxMutex{ch: make(chan struct{}, 1)}
+}
+
+func (m *ctxMutex) Lock() {
+	m.ch <- struct{}{}
+}
+
+// LockCtx acquires the mutex or returns ctx.Err() if ctx is done first.
+func (m *ctxMutex) LockCtx(ctx context.Context) error {
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *ctxMutex) Unlock() {
+	<-m.ch
+}
+
 // -- Supply Commands --
 
 func (c *Client) ReadSupply52() (string, error) {
+	return c.ReadSupply52Ctx(context.Background())
+}
+
+// ReadSupply52Ctx is like ReadSupply52 but honours ctx.
+func (c *Client) ReadSupply52Ctx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("&A", "")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// Supply reads the current supply and decodes it. It returns nil when the
+// device has no supply stored.
+func (c *Client) Supply() (*SupplyRecord, error) {
+	return c.SupplyCtx(context.Background())
+}
+
+// SupplyCtx is like Supply but honours ctx.
+func (c *Client) SupplyCtx(ctx context.Context) (*SupplyRecord, error) {
+	resp, err := c.ReadSupply52Ctx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSupply(resp)
 }
 
+// ReadSupplyIdentified reads the current supply. It is the same &A command as
+// ReadSupply52: a device with identification enabled answers in the
+// identified format (75 chars), which Supply and ParseSupply decode.
 func (c *Client) ReadSupplyIdentified() (string, error) {
-	cmd := c.BuildCommand("&A", "") // Same command, response length differs? Based on JS it calls same.
-	return c.SendCommand(cmd)
+	return c.ReadSupplyIdentifiedCtx(context.Background())
+}
+
+// ReadSupplyIdentifiedCtx is like ReadSupplyIdentified but honours ctx.
+func (c *Client) ReadSupplyIdentifiedCtx(ctx context.Context) (string, error) {
+	return c.ReadSupply52Ctx(ctx)
+}
+
+// ReadSupplyDualIdentification reads the current supply with its attendant
+// and customer identifiers (&@, 87 chars).
+func (c *Client) ReadSupplyDualIdentification() (string, error) {
+	return c.ReadSupplyDualIdentificationCtx(context.Background())
+}
+
+// ReadSupplyDualIdentificationCtx is like ReadSupplyDualIdentification but
+// honours ctx.
+func (c *Client) ReadSupplyDualIdentificationCtx(ctx context.Context) (string, error) {
+	cmd := c.BuildCommand("&@", "")
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// SupplyDual reads and decodes the current supply with its attendant and
+// customer identifiers and odometer. It returns nil when the device has no
+// supply stored.
+func (c *Client) SupplyDual() (*SupplyRecord, error) {
+	return c.SupplyDualCtx(context.Background())
+}
+
+// SupplyDualCtx is like SupplyDual but honours ctx.
+func (c *Client) SupplyDualCtx(ctx context.Context) (*SupplyRecord, error) {
+	resp, err := c.ReadSupplyDualIdentificationCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSupplyDual(resp)
 }
 
 func (c *Client) ReadSupplyPAF1() (string, error) {
+	return c.ReadSupplyPAF1Ctx(context.Background())
+}
+
+// ReadSupplyPAF1Ctx is like ReadSupplyPAF1 but honours ctx.
+func (c *Client) ReadSupplyPAF1Ctx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("&A2", "")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 func (c *Client) ReadSupplyPAF2() (string, error) {
+	return c.ReadSupplyPAF2Ctx(context.Background())
+}
+
+// ReadSupplyPAF2Ctx is like ReadSupplyPAF2 but honours ctx.
+func (c *Client) ReadSupplyPAF2Ctx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("&A3", "")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 func (c *Client) ReadMemoryPointers() (string, error) {
+	return c.ReadMemoryPointersCtx(context.Background())
+}
+
+// ReadMemoryPointersCtx is like ReadMemoryPointers but honours ctx.
+func (c *Client) ReadMemoryPointersCtx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("&T99", "P")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 func (c *Client) Increment() (string, error) {
+	return c.IncrementCtx(context.Background())
+}
+
+// IncrementCtx is like Increment but honours ctx.
+func (c *Client) IncrementCtx(ctx context.Context) (string, error) {
 	// JS: const command = "(&I)"; -> Direct string, likely manually checksummed or doesn't need it?
-	// JS code: start with '('. 
-	// "(&I)" checksum? 
-	// calcChecksum("(&I") -> '&'(38) + 'I'(73) = 111 (0x6F). 
+	// JS code: start with '('.
+	// "(&I)" checksum?
+	// calcChecksum("(&I") -> '&'(38) + 'I'(73) = 111 (0x6F).
 	// If it was manual, it would be "(&I6F)".
 	// JS code just sends "(&I)". Maybe some commands don't use the standard buildCommand?
 	// Let's follow JS strictly.
-	return c.SendCommand("(&I)")
+	return c.SendCommandCtx(ctx, "(&I)")
 }
 
 // -- Visualization Commands --
 
 func (c *Client) GetVisualization() (string, error) {
-	return c.SendCommand("(&V)")
+	return c.GetVisualizationCtx(context.Background())
+}
+
+// GetVisualizationCtx is like GetVisualization but honours ctx.
+func (c *Client) GetVisualizationCtx(ctx context.Context) (string, error) {
+	return c.SendCommandCtx(ctx, "(&V)")
+}
+
+// Visualization returns the value being dispensed by every active nozzle.
+func (c *Client) Visualization() ([]VisualizationEntry, error) {
+	return c.VisualizationCtx(context.Background())
+}
+
+// VisualizationCtx is like Visualization but honours ctx.
+func (c *Client) VisualizationCtx(ctx context.Context) ([]VisualizationEntry, error) {
+	resp, err := c.GetVisualizationCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVisualization(resp)
 }
 
 func (c *Client) GetVisualizationIdentified() (string, error) {
+	return c.GetVisualizationIdentifiedCtx(context.Background())
+}
+
+// GetVisualizationIdentifiedCtx is like GetVisualizationIdentified but honours ctx.
+func (c *Client) GetVisualizationIdentifiedCtx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("?V", "")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 // -- Identifier Commands --
 
 func (c *Client) ReadIdentifier() (string, error) {
+	return c.ReadIdentifierCtx(context.Background())
+}
+
+// ReadIdentifierCtx is like ReadIdentifier but honours ctx.
+func (c *Client) ReadIdentifierCtx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("?A", "")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 func (c *Client) ReadIdentifierFromMemory(position int) (string, error) {
+	return c.ReadIdentifierFromMemoryCtx(context.Background(), position)
+}
+
+// ReadIdentifierFromMemoryCtx is like ReadIdentifierFromMemory but honours ctx.
+func (c *Client) ReadIdentifierFromMemoryCtx(ctx context.Context, position int) (string, error) {
+	if position < 0 || position > MaxIdentifierPosition {
+		return "", invalidf("identifier position must be between 0 and %d, got %d", MaxIdentifierPosition, position)
+	}
 	posStr := fmt.Sprintf("%06d", position)
 	cmd := c.BuildCommand("?LF", posStr)
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 // -- Status Commands --
 
 func (c *Client) GetStatus() (string, error) {
-	return c.SendCommand("(&S)")
+	return c.GetStatusCtx(context.Background())
+}
+
+// GetStatusCtx is like GetStatus but honours ctx.
+func (c *Client) GetStatusCtx(ctx context.Context) (string, error) {
+	return c.SendCommandCtx(ctx, "(&S)")
+}
+
+// Status returns the status of every nozzle position.
+func (c *Client) Status() ([]NozzleStatus, error) {
+	return c.StatusCtx(context.Background())
+}
+
+// StatusCtx is like Status but honours ctx.
+func (c *Client) StatusCtx(ctx context.Context) ([]NozzleStatus, error) {
+	resp, err := c.GetStatusCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatus(resp)
 }
 
 // -- Pump Management --
 
 // ReadTotal reads total. Mode: L=Volume, $=Value
 func (c *Client) ReadTotal(nozzle, mode string) (string, error) {
+	return c.ReadTotalCtx(context.Background(), nozzle, mode)
+}
+
+// ReadTotalCtx is like ReadTotal but honours ctx.
+func (c *Client) ReadTotalCtx(ctx context.Context, nozzle, mode string) (string, error) {
 	cmd := c.BuildCommand("&T", nozzle+mode)
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// Total reads and decodes a totalizer. Mode: L=Volume, $=Value
+func (c *Client) Total(nozzle, mode string) (*Total, error) {
+	return c.TotalCtx(context.Background(), nozzle, mode)
+}
+
+// TotalCtx is like Total but honours ctx.
+func (c *Client) TotalCtx(ctx context.Context, nozzle, mode string) (*Total, error) {
+	resp, err := c.ReadTotalCtx(ctx, nozzle, mode)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTotal(resp)
 }
 
 func (c *Client) ChangePrice(nozzle, level, price string) (string, error) {
+	return c.ChangePriceCtx(context.Background(), nozzle, level, price)
+}
+
+// ChangePriceCtx is like ChangePrice but honours ctx.
+func (c *Client) ChangePriceCtx(ctx context.Context, nozzle, level, price string) (string, error) {
 	// Pad price to 4 chars
 	// JS: price.toString().padStart(4, "0")
 	// JS: `${nozzle}${level}0${priceStr}`
@@ -234,38 +700,134 @@ func (c *Client) ChangePrice(nozzle, level, price string) (string, error) {
 	}
 	params := fmt.Sprintf("%s%s0%s", nozzle, level, price)
 	cmd := c.BuildCommand("&U", params)
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 func (c *Client) ReadPrice(nozzle, mode string) (string, error) {
+	return c.ReadPriceCtx(context.Background(), nozzle, mode)
+}
+
+// ReadPriceCtx is like ReadPrice but honours ctx.
+func (c *Client) ReadPriceCtx(ctx context.Context, nozzle, mode string) (string, error) {
 	if mode == "" {
 		mode = "U"
 	}
 	cmd := c.BuildCommand("&T", nozzle+mode)
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// Price reads and decodes the unit prices of a nozzle. Mode: U=2 levels, u=3 levels
+func (c *Client) Price(nozzle, mode string) (*Price, error) {
+	return c.PriceCtx(context.Background(), nozzle, mode)
+}
+
+// PriceCtx is like Price but honours ctx.
+func (c *Client) PriceCtx(ctx context.Context, nozzle, mode string) (*Price, error) {
+	resp, err := c.ReadPriceCtx(ctx, nozzle, mode)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrice(resp)
 }
 
 func (c *Client) SetPreset(nozzle, value string) (string, error) {
+	return c.SetPresetCtx(context.Background(), nozzle, value)
+}
+
+// SetPresetCtx is like SetPreset but honours ctx.
+func (c *Client) SetPresetCtx(ctx context.Context, nozzle, value string) (string, error) {
 	// Pad value to 6 chars
 	if len(value) < 6 {
 		value = fmt.Sprintf("%06s", value)
 	}
 	cmd := c.BuildCommand("&P", nozzle+value)
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 func (c *Client) SetOperatingMode(nozzle, mode string) (string, error) {
+	return c.SetOperatingModeCtx(context.Background(), nozzle, mode)
+}
+
+// SetOperatingModeCtx is like SetOperatingMode but honours ctx.
+func (c *Client) SetOperatingModeCtx(ctx context.Context, nozzle, mode string) (string, error) {
 	cmd := c.BuildCommand("&M", nozzle+mode)
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
 }
 
 // -- Clock Commands --
 
 func (c *Client) ReadCalendar() (string, error) {
-	return c.SendCommand("(&R)")
+	return c.ReadCalendarCtx(context.Background())
+}
+
+// ReadCalendarCtx is like ReadCalendar but honours ctx.
+func (c *Client) ReadCalendarCtx(ctx context.Context) (string, error) {
+	return c.SendCommandCtx(ctx, "(&R)")
+}
+
+// Calendar reads and decodes the device calendar.
+func (c *Client) Calendar() (*ClockReading, error) {
+	return c.CalendarCtx(context.Background())
+}
+
+// CalendarCtx is like Calendar but honours ctx.
+func (c *Client) CalendarCtx(ctx context.Context) (*ClockReading, error) {
+	resp, err := c.ReadCalendarCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCalendar(resp)
 }
 
 func (c *Client) ReadClockExtended() (string, error) {
+	return c.ReadClockExtendedCtx(context.Background())
+}
+
+// ReadClockExtendedCtx is like ReadClockExtended but honours ctx.
+func (c *Client) ReadClockExtendedCtx(ctx context.Context) (string, error) {
 	cmd := c.BuildCommand("&KR1", "")
-	return c.SendCommand(cmd)
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// ClockExtended reads and decodes the extended device clock.
+func (c *Client) ClockExtended() (*ClockReading, error) {
+	return c.ClockExtendedCtx(context.Background())
+}
+
+// ClockExtendedCtx is like ClockExtended but honours ctx.
+func (c *Client) ClockExtendedCtx(ctx context.Context) (*ClockReading, error) {
+	resp, err := c.ReadClockExtendedCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseClockExtended(resp)
+}
+
+// SetCalendar sets the device calendar (&H) to t's day, hour and minute.
+func (c *Client) SetCalendar(t time.Time) (string, error) {
+	return c.SetCalendarCtx(context.Background(), t)
+}
+
+// SetCalendarCtx is like SetCalendar but honours ctx.
+func (c *Client) SetCalendarCtx(ctx context.Context, t time.Time) (string, error) {
+	cmd := fmt.Sprintf("(&H%02d%02d%02d)", t.Day(), t.Hour(), t.Minute())
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// SetClockExtended sets the extended device clock (&KW1) to t, down to the
+// second. The device keeps wall time, so convert t to the device time zone
+// first.
+func (c *Client) SetClockExtended(t time.Time) (string, error) {
+	return c.SetClockExtendedCtx(context.Background(), t)
+}
+
+// SetClockExtendedCtx is like SetClockExtended but honours ctx.
+func (c *Client) SetClockExtendedCtx(ctx context.Context, t time.Time) (string, error) {
+	if t.Year() < 2000 || t.Year() > 2099 {
+		return "", invalidf("clock year must be between 2000 and 2099, got %d", t.Year())
+	}
+	params := fmt.Sprintf("%02d%02d%02d%02d%02d%02d%02d", t.Year()%100, int(t.Month()), t.Day(),
+		int(t.Weekday())+1, t.Hour(), t.Minute(), t.Second())
+	cmd := c.BuildCommand("&KW1", params)
+	return c.SendCommandCtx(ctx, cmd)
 }
@@ -0,0 +1,197 @@
+package companytec
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDevice is a Dialer connecting to a device that answers every command
+// with answer(command), over a net.Pipe. An empty answer sends nothing.
+type fakeDevice struct {
+	answer func(command string) string
+
+	mu       sync.Mutex
+	commands []string
+	dials    int
+	conns    []net.Conn
+	refuse   bool
+}
+
+func (d *fakeDevice) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if d.refuse {
+		return nil, errors.New("connection refused")
+	}
+	client, device := net.Pipe()
+	d.conns = append(d.conns, device)
+	go d.serve(device)
+	return client, nil
+}
+
+func (d *fakeDevice) serve(conn net.Conn) {
+	defer conn.Close()
+	dec := NewFrameDecoder(conn, 0)
+	for {
+		command, err := dec.Next()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.commands = append(d.commands, command)
+		d.mu.Unlock()
+		if resp := d.answer(command); resp != "" {
+			if _, err := conn.Write([]byte(resp)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Commands returns the commands received so far.
+func (d *fakeDevice) Commands() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.commands...)
+}
+
+// Dials returns the number of connections attempted.
+func (d *fakeDevice) Dials() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+// Drop closes the open connections from the device side.
+func (d *fakeDevice) Drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+}
+
+// Refuse makes the following dials fail.
+func (d *fakeDevice) Refuse(refuse bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refuse = refuse
+}
+
+// newTestClient returns a client connected to d, with short timeouts and no
+// reconnect backoff.
+func newTestClient(t *testing.T, d *fakeDevice, opts ...Option) *Client {
+	t.Helper()
+	policy := DefaultReconnectPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.Jitter = 0
+	c := New("device:2001", append([]Option{
+		WithDialer(d),
+		WithTimeout(200 * time.Millisecond),
+		WithReconnectPolicy(policy),
+	}, opts...)...)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+func TestSendCommandProtocolErrors(t *testing.T) {
+	d := &fakeDevice{answer: func(command string) string {
+		switch commandParams(command) {
+		case "01L":
+			return checked("L0100012345678")
+		case "02L":
+			return "(L0200012345678FF)"
+		}
+		return noData
+	}}
+	c := newTestClient(t, d)
+
+	total, err := c.Total("01", "L")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Value != 12345678 {
+		t.Errorf("total = %+v", total)
+	}
+
+	var perr *ProtocolError
+	resp, err := c.ReadTotal("02", "L")
+	if !errors.As(err, &perr) || perr.Kind != ErrBadChecksum {
+		t.Fatalf("bad checksum: got %v", err)
+	}
+	if resp != "(L0200012345678FF)" || string(perr.Raw) != resp {
+		t.Errorf("bad checksum: response %q, raw %q", resp, perr.Raw)
+	}
+
+	_, err = c.ReadTotal("03", "L")
+	if !errors.As(err, &perr) || perr.Kind != ErrNAK {
+		t.Fatalf("(0): got %v, want a NAK", err)
+	}
+	if !c.IsConnected() {
+		t.Error("a protocol error dropped the connection")
+	}
+}
+
+func TestSendCommandCtxCancel(t *testing.T) {
+	d := &fakeDevice{answer: func(string) string { return "" }}
+	c := newTestClient(t, d, WithTimeout(5*time.Second), WithReconnectPolicy(ReconnectPolicy{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := c.GetStatusCtx(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancellation took %s to wake the read", elapsed)
+	}
+	// A late answer must not be read as the response to the next command
+	if c.IsConnected() {
+		t.Error("connection kept after an interrupted read")
+	}
+}
+
+func TestSendCommandCtxDeadline(t *testing.T) {
+	d := &fakeDevice{answer: func(string) string { return "" }}
+	c := newTestClient(t, d, WithTimeout(5*time.Second), WithReconnectPolicy(ReconnectPolicy{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetStatusCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the context deadline did not bound the read: %s", elapsed)
+	}
+}
+
+func TestSendCommandCtxWaitingForLock(t *testing.T) {
+	d := &fakeDevice{answer: func(string) string { return "(SLL)" }}
+	c := newTestClient(t, d)
+
+	c.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.SendCommandCtx(ctx, "(&S)")
+	c.mu.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if n := len(d.Commands()); n != 0 {
+		t.Errorf("%d commands sent after giving up on the lock", n)
+	}
+	if _, err := c.GetStatus(); err != nil {
+		t.Errorf("status after an abandoned lock: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package companytec
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CollectedSupply is a supply captured by a SupplyCollector.
+type CollectedSupply struct {
+	Key         string    `json:"key"`
+	CollectedAt time.Time `json:"collectedAt"`
+	SupplyRecord
+}
+
+// Checkpoint is the resume point of a collector: the last supply stored and
+// the number of supplies stored so far.
+type Checkpoint struct {
+	Key   string    `json:"key,omitempty"`
+	Count int64     `json:"count"`
+	At    time.Time `json:"at,omitzero"`
+}
+
+// SupplySink persists the supplies captured by a SupplyCollector.
+type SupplySink interface {
+	// Store persists s durably and reports whether it did; the supply is
+	// acknowledged on the device once Store returns a nil error. Storing a
+	// key that was already stored, at any point in the past, must succeed
+	// without storing it twice and report stored as false.
+	Store(ctx context.Context, s CollectedSupply) (stored bool, err error)
+	// Checkpoint returns the last supply stored, zero when nothing was.
+	Checkpoint(ctx context.Context) (Checkpoint, error)
+}
+
+// SupplyKey returns a key identifying a supply across reads and restarts: the
+// nozzle, the record counter, the final totalizer and the end time. Reading
+// the same stored supply again yields the same key.
+func SupplyKey(rec SupplyRecord) string {
+	return fmt.Sprintf("%s-%04d-%010d-%02d%02d%02d%02d", rec.Nozzle, rec.Record, rec.FinalTotal, rec.Month, rec.Day, rec.Hour, rec.Minute)
+}
+
+// SupplyCollector captures every supply exactly once. It reads the current
+// supply (&A), stores it through a SupplySink and only then acknowledges it
+// (&I), so a crash between the two never loses a supply: after a restart the
+// device returns it again and the collector recognises it by its key.
+//
+// The collector must be the only consumer acknowledging supplies on the
+// device. Its methods are safe for concurrent use.
+type SupplyCollector struct {
+	client   *Client
+	sink     SupplySink
+	interval time.Duration
+
+	mu         sync.Mutex
+	checkpoint Checkpoint
+	resumed    bool
+}
+
+// NewSupplyCollector creates a collector storing into sink. interval is the
+// wait between two polls when no supply is pending; it defaults to 2 seconds.
+func NewSupplyCollector(client *Client, sink SupplySink, interval time.Duration) *SupplyCollector {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	return &SupplyCollector{client: client, sink: sink, interval: interval}
+}
+
+// Checkpoint returns the last supply stored by the collector, or the one
+// recorded by the sink before the first collection.
+func (c *SupplyCollector) Checkpoint(ctx context.Context) (Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.resumeLocked(ctx); err != nil {
+		return Checkpoint{}, err
+	}
+	return c.checkpoint, nil
+}
+
+// CollectOne captures the current supply. It returns nil when the device has
+// no supply pending. A supply the sink already holds (the process stopped or
+// &I failed after it was stored) is acknowledged without being stored again,
+// and returned with stored set to false.
+func (c *SupplyCollector) CollectOne(ctx context.Context) (s *CollectedSupply, stored bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.resumeLocked(ctx); err != nil {
+		return nil, false, err
+	}
+
+	rec, err := c.client.SupplyCtx(ctx)
+	if err != nil || rec == nil {
+		return nil, false, err
+	}
+	s = &CollectedSupply{Key: SupplyKey(*rec), CollectedAt: time.Now(), SupplyRecord: *rec}
+
+	stored, err = c.sink.Store(ctx, *s)
+	if err != nil {
+		return nil, false, fmt.Errorf("store supply %s: %w", s.Key, err)
+	}
+	if stored {
+		c.checkpoint = Checkpoint{Key: s.Key, Count: c.checkpoint.Count + 1, At: s.CollectedAt}
+	}
+
+	// &I is never retried automatically: if it reached the device before the
+	// connection dropped, the next read returns the following supply; if not,
+	// it returns this one again and the sink skips it by its key.
+	if _, err := c.client.IncrementCtx(ctx); err != nil {
+		return s, stored, fmt.Errorf("acknowledge supply %s: %w", s.Key, err)
+	}
+	return s, stored, nil
+}
+
+// Drain collects supplies until the device has none pending and returns the
+// number stored.
+func (c *SupplyCollector) Drain(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		s, stored, err := c.CollectOne(ctx)
+		if stored {
+			n++
+		}
+		if err != nil || s == nil {
+			return n, err
+		}
+	}
+}
+
+// Run drains the pending supplies every interval until ctx is done. Failed
+// collections are logged and retried at the next tick; they do not stop Run.
+func (c *SupplyCollector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := c.Drain(ctx); err != nil && ctx.Err() == nil {
+			c.client.logger.Warn("supply collection failed", "stored", n, "error", RedactError(err))
+		} else if n > 0 {
+			c.client.logger.Info("supplies collected", "stored", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// resumeLocked loads the checkpoint from the sink once.
+func (c *SupplyCollector) resumeLocked(ctx context.Context) error {
+	if c.resumed {
+		return nil
+	}
+	cp, err := c.sink.Checkpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("read checkpoint: %w", err)
+	}
+	c.checkpoint = cp
+	c.resumed = true
+	return nil
+}
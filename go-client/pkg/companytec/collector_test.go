@@ -0,0 +1,156 @@
+package companytec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// supplyDevice answers &A with the first of its pending records and
+// acknowledges it on &I. An &I is left unanswered while drop is positive.
+type supplyDevice struct {
+	mu      sync.Mutex
+	pending []int
+	drop    int
+}
+
+func supplyFrame(record int) string {
+	return checked(fmt.Sprintf("00100000100058790000020115083003%04d%010d00", record, 1000*record))
+}
+
+func (s *supplyDevice) answer(command string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch CommandHeader(command) {
+	case "&A":
+		if len(s.pending) == 0 {
+			return noData
+		}
+		return supplyFrame(s.pending[0])
+	case "&I":
+		if s.drop > 0 {
+			s.drop--
+			return ""
+		}
+		if len(s.pending) == 0 {
+			return noData
+		}
+		s.pending = s.pending[1:]
+		return command
+	}
+	return noData
+}
+
+func openSink(t *testing.T) (*FileSink, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "supplies.jsonl")
+	sink, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink, path
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestCollectorDrain(t *testing.T) {
+	dev := &supplyDevice{pending: []int{1, 2, 3}}
+	c := newTestClient(t, &fakeDevice{answer: dev.answer})
+	sink, path := openSink(t)
+	collector := NewSupplyCollector(c, sink, 0)
+
+	n, err := collector.Drain(context.Background())
+	if err != nil || n != 3 {
+		t.Fatalf("drained %d, %v", n, err)
+	}
+	if got := lines(t, path); got != 3 {
+		t.Errorf("%d supplies in the file, want 3", got)
+	}
+	cp, err := collector.Checkpoint(context.Background())
+	if err != nil || cp.Count != 3 || !strings.HasPrefix(cp.Key, "01-0003-") {
+		t.Errorf("checkpoint %+v, %v", cp, err)
+	}
+}
+
+func TestCollectorUnacknowledged(t *testing.T) {
+	dev := &supplyDevice{pending: []int{1, 2}, drop: 1}
+	c := newTestClient(t, &fakeDevice{answer: dev.answer})
+	sink, path := openSink(t)
+	collector := NewSupplyCollector(c, sink, 0)
+	ctx := context.Background()
+
+	// Stored, but &I got no answer
+	s, stored, err := collector.CollectOne(ctx)
+	if err == nil || !stored || s == nil || s.Record != 1 {
+		t.Fatalf("first collection: %+v, %v, %v", s, stored, err)
+	}
+	// The device returns the supply again: acknowledged, not stored
+	s, stored, err = collector.CollectOne(ctx)
+	if err != nil || stored || s == nil || s.Record != 1 {
+		t.Fatalf("second collection: %+v, %v, %v", s, stored, err)
+	}
+	s, stored, err = collector.CollectOne(ctx)
+	if err != nil || !stored || s.Record != 2 {
+		t.Fatalf("third collection: %+v, %v, %v", s, stored, err)
+	}
+	if got := lines(t, path); got != 2 {
+		t.Errorf("%d supplies in the file, want 2", got)
+	}
+}
+
+func TestCollectorSkipsStoredKeys(t *testing.T) {
+	// The read pointer was moved back: supply 1 comes again after 2, when
+	// it is no longer the last one stored
+	dev := &supplyDevice{pending: []int{1, 2, 1, 3}}
+	c := newTestClient(t, &fakeDevice{answer: dev.answer})
+	sink, path := openSink(t)
+	collector := NewSupplyCollector(c, sink, 0)
+
+	n, err := collector.Drain(context.Background())
+	if err != nil || n != 3 {
+		t.Fatalf("drained %d, %v, want 3 stored", n, err)
+	}
+	if got := lines(t, path); got != 3 {
+		t.Errorf("%d supplies in the file, want 3", got)
+	}
+	if cp, _ := collector.Checkpoint(context.Background()); cp.Count != 3 {
+		t.Errorf("checkpoint %+v", cp)
+	}
+	if len(dev.pending) != 0 {
+		t.Errorf("supplies %v left unacknowledged", dev.pending)
+	}
+}
+
+func TestCollectorRun(t *testing.T) {
+	dev := &supplyDevice{pending: []int{1, 2}}
+	c := newTestClient(t, &fakeDevice{answer: dev.answer})
+	sink, _ := openSink(t)
+	collector := NewSupplyCollector(c, sink, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- collector.Run(ctx) }()
+	for {
+		if cp, _ := sink.Checkpoint(ctx); cp.Count == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+}
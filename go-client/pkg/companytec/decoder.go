@@ -0,0 +1,165 @@
+package companytec
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// DefaultMaxFrameLength bounds the frames read from the device. The longest
+// fixed frames (PAF supplies) are 127 bytes; visualizations grow with the
+// number of nozzles.
+const DefaultMaxFrameLength = 1024
+
+// readChunk is the size of the reads made by a FrameDecoder.
+const readChunk = 512
+
+// FrameDecoder splits a byte stream into frames, from an opening ( to the
+// next closing ). Bytes outside frames are line noise and are dropped. A (
+// inside a frame starts it over, so the decoder resyncs on the next frame
+// after a partial one, and frames longer than the maximum length are dropped
+// whole. Bytes read beyond a frame are kept for the next one.
+//
+// A FrameDecoder is not safe for concurrent use.
+type FrameDecoder struct {
+	r       io.Reader
+	max     int
+	store   []byte
+	buf     []byte // Unconsumed bytes, at the start of store
+	dropped int64
+}
+
+// NewFrameDecoder reads frames from r, dropping those longer than maxLength
+// bytes. maxLength defaults to DefaultMaxFrameLength when 0 or less.
+func NewFrameDecoder(r io.Reader, maxLength int) *FrameDecoder {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxFrameLength
+	}
+	maxLength = max(maxLength, 2)
+	d := &FrameDecoder{
+		r:     r,
+		max:   maxLength,
+		store: make([]byte, maxLength+readChunk),
+	}
+	d.buf = d.store[:0]
+	return d
+}
+
+// Next returns the next frame, reading as needed. On a read error the bytes
+// of an incomplete frame are kept, so Next can be called again after a
+// timeout; see Discard.
+func (d *FrameDecoder) Next() (string, error) {
+	for {
+		if frame, ok := d.frame(); ok {
+			return frame, nil
+		}
+		// d.buf is shorter than d.max, leaving at least readChunk bytes
+		n := copy(d.store, d.buf)
+		m, err := d.r.Read(d.store[n:])
+		d.buf = d.store[:n+m]
+		if err != nil {
+			if frame, ok := d.frame(); ok {
+				return frame, nil
+			}
+			return "", err
+		}
+	}
+}
+
+// Buffered returns the next frame if it was already read, without reading.
+func (d *FrameDecoder) Buffered() (string, bool) {
+	return d.frame()
+}
+
+// Discard drops the unconsumed bytes and returns those of the incomplete
+// frame, if any.
+func (d *FrameDecoder) Discard() string {
+	partial := string(d.buf)
+	d.buf = d.store[:0]
+	return partial
+}
+
+// Dropped returns the number of bytes dropped so far: noise, incomplete
+// frames followed by a new one, and oversized frames.
+func (d *FrameDecoder) Dropped() int64 {
+	return d.dropped
+}
+
+// frame consumes the next complete frame of d.buf, dropping the bytes that
+// cannot be part of one. Afterwards d.buf is empty or starts with a (.
+func (d *FrameDecoder) frame() (string, bool) {
+	for {
+		start := bytes.IndexByte(d.buf, '(')
+		if start < 0 {
+			d.drop(len(d.buf))
+			return "", false
+		}
+		d.drop(start)
+
+		end := bytes.IndexAny(d.buf[1:], "()")
+		if end < 0 {
+			if len(d.buf) >= d.max {
+				// The closing delimiter would come too late
+				d.drop(len(d.buf))
+			}
+			return "", false
+		}
+		end++
+		switch {
+		case d.buf[end] == '(':
+			d.drop(end)
+		case end+1 > d.max:
+			d.drop(end + 1)
+		default:
+			frame := string(d.buf[:end+1])
+			d.buf = d.buf[end+1:]
+			return frame, true
+		}
+	}
+}
+
+func (d *FrameDecoder) drop(n int) {
+	d.dropped += int64(n)
+	d.buf = d.buf[n:]
+}
+
+// UnsolicitedFrame is a frame the device sent without being asked, or that
+// does not answer the command in flight. See Client.Unsolicited.
+type UnsolicitedFrame struct {
+	Time  time.Time
+	Frame string
+	// Command is the command in flight when the frame was read, or "" when
+	// it was read before a command was sent.
+	Command string
+}
+
+// unsolicitedBuffer is the capacity of the Unsolicited channel.
+const unsolicitedBuffer = 16
+
+// Unsolicited returns the channel on which the frames that do not answer a
+// command are delivered: extra frames sent after a response, or answers that
+// do not match the command in flight (wrong header). They are skipped rather
+// than taken as the response. Frames are dropped when the channel is full.
+func (c *Client) Unsolicited() <-chan UnsolicitedFrame {
+	return c.unsolicited
+}
+
+// unsolicitedLocked delivers frame on the Unsolicited channel. c.mu must be
+// held. The frame is logged with every tag-like run of hex digits masked,
+// since its command is unknown.
+func (c *Client) unsolicitedLocked(command, frame string) {
+	c.logger.Debug("unsolicited frame", "addr", c.addr, "frame", maskHexRuns(frame), "command", RedactCommand(command))
+	select {
+	case c.unsolicited <- UnsolicitedFrame{Time: time.Now(), Frame: frame, Command: command}:
+	default:
+		c.logger.Warn("unsolicited frame dropped, channel full", "addr", c.addr)
+	}
+}
+
+// answers reports whether frame can be the response to command, as opposed
+// to a frame with the header or the shape of another command's response (see
+// ValidateResponse). Frames the device garbled or rejected still answer it.
+func answers(command, frame string) bool {
+	perr, ok := ValidateResponse(command, frame).(*ProtocolError)
+	return !ok || perr.Kind != ErrUnexpectedHeader
+}
@@ -0,0 +1,129 @@
+package companytec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsolicited returns the frames delivered on c.Unsolicited so far.
+func unsolicited(c *Client) []UnsolicitedFrame {
+	var frames []UnsolicitedFrame
+	for {
+		select {
+		case f := <-c.Unsolicited():
+			frames = append(frames, f)
+		default:
+			return frames
+		}
+	}
+}
+
+func TestStaleFramesSkipped(t *testing.T) {
+	supply := checked(strings.Repeat("0", 48))
+	d := &fakeDevice{answer: func(command string) string {
+		switch CommandHeader(command) {
+		case "&A":
+			// A status the device sent late, then the supply
+			return "(SLL)" + supply
+		case "&R":
+			return "(L0100012345678D)(15103003)"
+		case "&S":
+			return "(SLL)(SEL)"
+		}
+		return noData
+	}}
+	c := newTestClient(t, d)
+
+	if resp, err := c.ReadSupply52(); err != nil || resp != supply {
+		t.Fatalf("supply %q, %v", resp, err)
+	}
+	if resp, err := c.SendCommand("(&R)"); err != nil || resp != "(15103003)" {
+		t.Fatalf("calendar %q, %v", resp, err)
+	}
+	got := unsolicited(c)
+	if len(got) != 2 || got[0].Frame != "(SLL)" || got[0].Command != c.BuildCommand("&A", "") ||
+		got[1].Frame != "(L0100012345678D)" || got[1].Command != "(&R)" {
+		t.Errorf("unsolicited %+v", got)
+	}
+
+	// A second frame after the response does not answer the next command
+	if resp, err := c.SendCommand("(&S)"); err != nil || resp != "(SLL)" {
+		t.Fatalf("status %q, %v", resp, err)
+	}
+	if resp, err := c.SendCommand("(&S)"); err != nil || resp != "(SLL)" {
+		t.Fatalf("second status %q, %v", resp, err)
+	}
+	if got := unsolicited(c); len(got) != 1 || got[0].Frame != "(SEL)" || got[0].Command != "" {
+		t.Errorf("unsolicited %+v", got)
+	}
+}
+
+func TestTruncatedReadDropsConnection(t *testing.T) {
+	total := New("").BuildCommand("&T", "01L")
+	partial := true
+	d := &fakeDevice{answer: func(command string) string {
+		if command != total {
+			return noData
+		}
+		if partial {
+			partial = false
+			return "(L01000"
+		}
+		return checked("L0100012345678")
+	}}
+	c := newTestClient(t, d)
+
+	_, err := c.SendCommand(total)
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Kind != ErrTruncated || string(perr.Raw) != "(L01000" {
+		t.Fatalf("partial frame: %v", err)
+	}
+	// The rest of the frame must not be read as the next answer
+	if c.IsConnected() || c.State() != StateDegraded {
+		t.Errorf("connection kept after a truncated read, state %s", c.State())
+	}
+	if resp, err := c.SendCommand(total); err != nil || resp != checked("L0100012345678") {
+		t.Errorf("after the truncated read: %q, %v", resp, err)
+	}
+	if n := d.Dials(); n != 2 {
+		t.Errorf("%d dials, want a new connection", n)
+	}
+}
+
+func TestTimeoutWithoutAnswer(t *testing.T) {
+	d := &fakeDevice{answer: func(string) string { return "" }}
+	c := newTestClient(t, d, WithReadTimeout(20*time.Millisecond))
+	_, err := c.SendCommand("(&S)")
+	var perr *ProtocolError
+	if err == nil || errors.As(err, &perr) {
+		t.Fatalf("silent device: %v, want an I/O error", err)
+	}
+	if c.IsConnected() {
+		t.Error("connection kept after a read timeout")
+	}
+}
+
+func TestFrameDecoderBuffered(t *testing.T) {
+	dec := NewFrameDecoder(strings.NewReader("(SLL)(SEL)(L0"), 0)
+	if _, ok := dec.Buffered(); ok {
+		t.Error("frame buffered before any read")
+	}
+	if frame, err := dec.Next(); err != nil || frame != "(SLL)" {
+		t.Fatalf("first frame %q, %v", frame, err)
+	}
+	// The rest of the read stays buffered
+	if frame, ok := dec.Buffered(); !ok || frame != "(SEL)" {
+		t.Errorf("buffered %q, %v", frame, ok)
+	}
+	if _, ok := dec.Buffered(); ok {
+		t.Error("incomplete frame returned as buffered")
+	}
+	if partial := dec.Discard(); partial != "(L0" {
+		t.Errorf("discarded %q", partial)
+	}
+	if _, ok := dec.Buffered(); ok {
+		t.Error("frame buffered after discard")
+	}
+}
@@ -0,0 +1,88 @@
+package companytec
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrorKind classifies a ProtocolError.
+type ErrorKind int
+
+const (
+	// ErrBadChecksum means the response checksum does not match its contents.
+	ErrBadChecksum ErrorKind = iota + 1
+	// ErrTruncated means the response is missing its delimiters or is too short.
+	ErrTruncated
+	// ErrUnexpectedHeader means the response does not echo the command it answers.
+	ErrUnexpectedHeader
+	// ErrNAK means the device rejected the command, e.g. by answering (0).
+	ErrNAK
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrBadChecksum:
+		return "bad_checksum"
+	case ErrTruncated:
+		return "truncated"
+	case ErrUnexpectedHeader:
+		return "unexpected_header"
+	case ErrNAK:
+		return "nak"
+	default:
+		return "unknown"
+	}
+}
+
+// ProtocolError is returned when a response frame fails validation.
+// Use errors.As to inspect it.
+type ProtocolError struct {
+	Kind    ErrorKind
+	Command string // Command frame that was sent
+	Raw     []byte // Response bytes as received
+	Detail  string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.format(e.Command, string(e.Raw))
+}
+
+func (e *ProtocolError) format(command, response string) string {
+	msg := fmt.Sprintf("protocol error (%s) for %q: response %q", e.Kind, command, response)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// LogValue logs the error as a group with the identifier tags of the frames
+// masked (see RedactCommand and RedactResponse).
+func (e *ProtocolError) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("kind", e.Kind.String()),
+		slog.String("command", RedactCommand(e.Command)),
+		slog.String("response", RedactResponse(e.Command, string(e.Raw))),
+	}
+	if e.Detail != "" {
+		attrs = append(attrs, slog.String("detail", e.Detail))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func newProtocolError(kind ErrorKind, command, response, detail string) *ProtocolError {
+	return &ProtocolError{
+		Kind:    kind,
+		Command: command,
+		Raw:     []byte(response),
+		Detail:  detail,
+	}
+}
+
+// ErrInvalidParameter is wrapped by errors returned when a command parameter
+// is rejected before anything is sent to the device.
+var ErrInvalidParameter = errors.New("invalid parameter")
+
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidParameter}, args...)...)
+}
@@ -0,0 +1,131 @@
+package companytec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSink is a SupplySink appending supplies to a JSON lines file, synced to
+// disk before Store returns. The keys already in the file are loaded on open
+// so that a supply is never written twice, even across restarts.
+type FileSink struct {
+	mu         sync.Mutex
+	f          sinkFile
+	size       int64 // End of the last complete line
+	broken     error // Set when a failed write could not be rolled back
+	keys       map[string]bool
+	checkpoint Checkpoint
+}
+
+// sinkFile is the part of *os.File a FileSink uses.
+type sinkFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// OpenFileSink opens or creates the file at path. A partial last line, left
+// by a crash in the middle of a write, is discarded.
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileSink{f: f, keys: make(map[string]bool)}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("supply file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileSink) load() error {
+	var good int64
+	r := bufio.NewReader(s.f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// EOF, possibly after a partial line
+			break
+		}
+		var supply CollectedSupply
+		if err := json.Unmarshal(bytes.TrimSpace(line), &supply); err != nil {
+			return fmt.Errorf("line at offset %d: %w", good, err)
+		}
+		good += int64(len(line))
+		s.keys[supply.Key] = true
+		s.checkpoint = Checkpoint{Key: supply.Key, Count: s.checkpoint.Count + 1, At: supply.CollectedAt}
+	}
+	s.size = good
+	return s.rollback()
+}
+
+// rollback truncates the file to the end of its last complete line and moves
+// the write offset there.
+func (s *FileSink) rollback() error {
+	if err := s.f.Truncate(s.size); err != nil {
+		return err
+	}
+	_, err := s.f.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// Store appends supply unless its key is already in the file. A failed write
+// is removed from the file so that the next line starts clean; when that is
+// not possible, Store fails from then on.
+func (s *FileSink) Store(ctx context.Context, supply CollectedSupply) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken != nil {
+		return false, fmt.Errorf("supply file unusable after a failed write: %w", s.broken)
+	}
+	if s.keys[supply.Key] {
+		return false, nil
+	}
+	line, err := json.Marshal(supply)
+	if err != nil {
+		return false, err
+	}
+	line = append(line, '\n')
+	if _, err := s.f.Write(line); err != nil {
+		return false, s.failedWrite(err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return false, s.failedWrite(err)
+	}
+	s.size += int64(len(line))
+	s.keys[supply.Key] = true
+	s.checkpoint = Checkpoint{Key: supply.Key, Count: s.checkpoint.Count + 1, At: supply.CollectedAt}
+	return true, nil
+}
+
+// failedWrite drops what a failed write may have left in the file, and
+// returns err.
+func (s *FileSink) failedWrite(err error) error {
+	if rerr := s.rollback(); rerr != nil {
+		s.broken = rerr
+		return fmt.Errorf("%w (rolling back: %v)", err, rerr)
+	}
+	return err
+}
+
+// Checkpoint returns the last supply in the file.
+func (s *FileSink) Checkpoint(ctx context.Context) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoint, nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
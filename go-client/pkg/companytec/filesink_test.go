@@ -0,0 +1,143 @@
+package companytec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	sink, path := openSink(t)
+	ctx := context.Background()
+	at := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	supplies := []CollectedSupply{
+		{Key: "a", CollectedAt: at, SupplyRecord: SupplyRecord{Nozzle: "01", Record: 1}},
+		{Key: "b", CollectedAt: at.Add(time.Minute), SupplyRecord: SupplyRecord{Nozzle: "01", Record: 2}},
+	}
+	for _, s := range supplies {
+		if stored, err := sink.Store(ctx, s); err != nil || !stored {
+			t.Fatalf("store %s: %v, %v", s.Key, stored, err)
+		}
+	}
+	if stored, err := sink.Store(ctx, supplies[0]); err != nil || stored {
+		t.Errorf("store a again: %v, %v", stored, err)
+	}
+	sink.Close()
+
+	// A crash left half a line behind
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"key":"c","nozz`)
+	f.Close()
+
+	reopened, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	cp, err := reopened.Checkpoint(ctx)
+	if err != nil || cp != (Checkpoint{Key: "b", Count: 2, At: at.Add(time.Minute)}) {
+		t.Errorf("checkpoint %+v, %v", cp, err)
+	}
+	if stored, _ := reopened.Store(ctx, supplies[0]); stored {
+		t.Error("key loaded from the file stored again")
+	}
+	if stored, err := reopened.Store(ctx, CollectedSupply{Key: "c"}); err != nil || !stored {
+		t.Errorf("store c: %v, %v", stored, err)
+	}
+	if got := lines(t, path); got != 3 {
+		t.Errorf("%d lines, want 3", got)
+	}
+}
+
+// failingFile fails the writes, syncs and truncations of a sink file while
+// the matching error is set. A failing write still writes half its data.
+type failingFile struct {
+	sinkFile
+	writeErr, syncErr, truncateErr error
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.writeErr != nil {
+		n, _ := f.sinkFile.Write(p[:len(p)/2])
+		return n, f.writeErr
+	}
+	return f.sinkFile.Write(p)
+}
+
+func (f *failingFile) Sync() error {
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	return f.sinkFile.Sync()
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.sinkFile.Truncate(size)
+}
+
+func TestFileSinkFailedWrite(t *testing.T) {
+	sink, path := openSink(t)
+	ctx := context.Background()
+	f := &failingFile{sinkFile: sink.f}
+	sink.f = f
+	diskFull := errors.New("no space left on device")
+
+	if _, err := sink.Store(ctx, CollectedSupply{Key: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	f.writeErr = diskFull
+	if stored, err := sink.Store(ctx, CollectedSupply{Key: "b"}); stored || !errors.Is(err, diskFull) {
+		t.Fatalf("short write: %v, %v", stored, err)
+	}
+	f.writeErr, f.syncErr = nil, diskFull
+	if stored, err := sink.Store(ctx, CollectedSupply{Key: "b"}); stored || !errors.Is(err, diskFull) {
+		t.Fatalf("failed sync: %v, %v", stored, err)
+	}
+	// The failed lines were removed, so the next one starts clean
+	f.syncErr = nil
+	for _, key := range []string{"b", "c"} {
+		if stored, err := sink.Store(ctx, CollectedSupply{Key: key}); !stored || err != nil {
+			t.Fatalf("store %s after the failures: %v, %v", key, stored, err)
+		}
+	}
+	sink.Close()
+
+	reopened, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatalf("reopening after failed writes: %v", err)
+	}
+	defer reopened.Close()
+	if cp, _ := reopened.Checkpoint(ctx); cp.Key != "c" || cp.Count != 3 {
+		t.Errorf("checkpoint %+v", cp)
+	}
+	if got := lines(t, path); got != 3 {
+		t.Errorf("%d lines, want 3", got)
+	}
+}
+
+func TestFileSinkBroken(t *testing.T) {
+	sink, path := openSink(t)
+	ctx := context.Background()
+	f := &failingFile{sinkFile: sink.f, writeErr: errors.New("I/O error"), truncateErr: errors.New("read-only file system")}
+	sink.f = f
+
+	if _, err := sink.Store(ctx, CollectedSupply{Key: "a"}); err == nil {
+		t.Fatal("failed write reported as stored")
+	}
+	// The partial line could not be removed: appending would corrupt the file
+	f.writeErr, f.truncateErr = nil, nil
+	if stored, err := sink.Store(ctx, CollectedSupply{Key: "b"}); stored || err == nil {
+		t.Errorf("store after a failed rollback: %v, %v", stored, err)
+	}
+	if got := lines(t, path); got != 0 {
+		t.Errorf("%d lines written after a failed rollback", got)
+	}
+}
@@ -0,0 +1,196 @@
+package companytec
+
+import "strings"
+
+// emptyResponseHeaders lists the commands for which (0) is a regular "nothing
+// to report" answer rather than a rejection.
+var emptyResponseHeaders = map[string]bool{
+	"&A":  true, // no supply stored (also &A2, &A3)
+	"&@":  true,
+	"&I":  true,
+	"&L":  true,
+	"&V":  true, // no nozzle dispensing
+	"?A":  true,
+	"?I":  true,
+	"?LF": true,
+	"?V":  true,
+}
+
+// headers lists the protocol headers, longest first where one is the
+// prefix of another.
+var headers = []string{
+	"&KR", "&KW", "&S", "&V", "&T", "&R", "&A", "&@", "&L", "&I", "&M", "&U", "&P", "&H",
+	"?LF", "?A", "?V", "?F", "?I",
+}
+
+// CommandHeader returns the protocol header of a command frame without
+// parameters, e.g. &T for (&T08L2E) and ?LF for (?LF000001C5). Commands
+// unknown to the client give their first two characters, and strings that
+// are not frames "".
+func CommandHeader(command string) string {
+	if len(command) < 3 || command[0] != '(' {
+		return ""
+	}
+	for _, h := range headers {
+		if strings.HasPrefix(command[1:], h) {
+			return h
+		}
+	}
+	return command[1:3]
+}
+
+// KnownHeader reports whether header, as returned by CommandHeader, is one of
+// the protocol headers the client knows.
+func KnownHeader(header string) bool {
+	for _, h := range headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// commandParams returns the parameters of a command frame, without header and
+// checksum.
+func commandParams(command string) string {
+	start := 1 + len(CommandHeader(command))
+	if len(command) <= start {
+		return ""
+	}
+	body := command[start : len(command)-1]
+	if HasChecksum(command) {
+		body = body[:len(body)-2]
+	}
+	return body
+}
+
+// HasChecksum reports whether a command frame was built with BuildCommand,
+// i.e. ends with a valid checksum. Responses to those commands carry one too.
+func HasChecksum(command string) bool {
+	if len(command) < 5 || command[len(command)-1] != ')' {
+		return false
+	}
+	body := command[:len(command)-3]
+	return Checksum(body) == strings.ToUpper(command[len(command)-3:len(command)-1])
+}
+
+// ValidateResponse checks that response is a well formed answer to command:
+// delimited, with a valid checksum when command has one, and with the header,
+// echo or length of the responses to command. It returns a *ProtocolError
+// describing the first problem found.
+func ValidateResponse(command, response string) error {
+	if len(response) < 2 || response[len(response)-1] != ')' {
+		return newProtocolError(ErrTruncated, command, response, "missing final delimiter")
+	}
+	if response[0] != '(' {
+		return newProtocolError(ErrTruncated, command, response, "missing initial delimiter")
+	}
+
+	header := CommandHeader(command)
+	if response == noData {
+		if emptyResponseHeaders[header] {
+			return nil
+		}
+		return newProtocolError(ErrNAK, command, response, "command rejected by device")
+	}
+
+	data := response[1 : len(response)-1]
+	checked := HasChecksum(command)
+	if checked {
+		if len(data) < 3 {
+			return newProtocolError(ErrTruncated, command, response, "too short for checksum")
+		}
+		data = data[:len(data)-2]
+	}
+	if data == "" {
+		return newProtocolError(ErrTruncated, command, response, "empty response")
+	}
+
+	// A frame answering another command, such as a late answer to a raw
+	// command, is reported as such whatever its checksum
+	if detail := checkShape(command, data); detail != "" {
+		return newProtocolError(ErrUnexpectedHeader, command, response, detail)
+	}
+	if checked {
+		want := Checksum(response[:len(response)-3])
+		if got := strings.ToUpper(response[len(response)-3 : len(response)-1]); got != want {
+			return newProtocolError(ErrBadChecksum, command, response, "expected "+want+", got "+got)
+		}
+	}
+	return nil
+}
+
+// echoHeaders lists the commands the device answers by echoing them.
+var echoHeaders = map[string]bool{
+	"&U": true, "&P": true, "&M": true, "&H": true, "&I": true, "&KW": true,
+	"?F": true, "?I": true,
+}
+
+// checkShape checks that data, the body of a response without checksum, has
+// the shape of an answer to command, so that a frame answering another
+// command is not taken for it. It returns what was expected, or "" when data
+// fits.
+func checkShape(command, data string) string {
+	header := CommandHeader(command)
+	if echoHeaders[header] {
+		if !strings.HasPrefix(data, header) {
+			return "expected echo of " + header
+		}
+		return ""
+	}
+
+	switch header {
+	case "&S":
+		if data[0] != 'S' {
+			return "expected S"
+		}
+	case "&T":
+		// &T<nozzle><mode> is answered as <mode><nozzle>..., &T99 as P99...
+		params := commandParams(command)
+		switch {
+		case params == "99":
+			if !strings.HasPrefix(data, "P99") {
+				return "expected P99"
+			}
+		case len(params) == 3:
+			if echo := params[2:] + params[:2]; !strings.HasPrefix(data, echo) {
+				return "expected " + echo
+			}
+		}
+	case "&V":
+		if len(data)%8 != 0 {
+			return "expected 8-char visualization entries"
+		}
+	case "&A", "&L", "&@":
+		// Every supply format starts with total to pay, volume and price
+		if len(data) < 30 || !isDigits(data[:16]) {
+			return "expected a supply"
+		}
+	case "&R":
+		if (len(data) != 6 && len(data) != 8) || !isDigits(data) {
+			return "expected DDHHMM[MM]"
+		}
+	case "&KR":
+		if len(data) < 14 || !isDigits(data) {
+			return "expected YYMMDDWWHHMMSS"
+		}
+	case "?A":
+		if len(data) < 16 || !isHex(data[:16]) {
+			return "expected an identifier"
+		}
+	case "?LF":
+		if len(data) < 35 {
+			return "expected an identifier record"
+		}
+	}
+	return ""
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
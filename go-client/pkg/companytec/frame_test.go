@@ -0,0 +1,240 @@
+package companytec
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"testing/quick"
+)
+
+// checkRoundTrip checks that a command built from header and params carries a
+// valid checksum and gives its header and params back.
+func checkRoundTrip(t *testing.T, header, params string) {
+	t.Helper()
+	if header == "" {
+		// Every command has a header; "(00)" is too short to carry a checksum
+		return
+	}
+	command := New("device:2001").BuildCommand(header, params)
+	if !HasChecksum(command) {
+		t.Fatalf("BuildCommand(%q, %q) = %q: checksum not recognised", header, params, command)
+	}
+	if len(header) == 2 {
+		// A known header may take a character of params, as ?L of ?LF
+		if got, gotParams := CommandHeader(command), commandParams(command); got+gotParams != header+params {
+			t.Errorf("header and params of %q = %q, %q, want %q, %q", command, got, gotParams, header, params)
+		}
+	}
+}
+
+func FuzzBuildCommand(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"&S", ""}, {"&A", ""}, {"&T", "01L"}, {"&T", "99"}, {"&U", "0105879"},
+		{"&P", "01001000"}, {"&M", "01A"}, {"&M99", "b00A1B2C3D4E5F601"},
+		{"&KW1", "26030202140731"}, {"?F", "01P00A1B2C3D4E5F6011N00010000V00000"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(checkRoundTrip)
+}
+
+func TestChecksumProperties(t *testing.T) {
+	// Any command round-trips
+	roundTrip := func(header, params string) bool {
+		checkRoundTrip(t, header, params)
+		return !t.Failed()
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Changing one ASCII character of the parameters breaks the checksum
+	corrupt := func(params []byte, pos uint, c byte) bool {
+		if len(params) == 0 {
+			return true
+		}
+		for i := range params {
+			params[i] = ' ' + params[i]%('~'-' ')
+		}
+		command := New("device:2001").BuildCommand("&T", string(params))
+		i := 3 + int(pos%uint(len(params)))
+		c = ' ' + c%('~'-' ')
+		if command[i] == c {
+			return true
+		}
+		changed := command[:i] + string(c) + command[i+1:]
+		return !HasChecksum(changed)
+	}
+	if err := quick.Check(corrupt, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	total := New("device:2001").BuildCommand("&T", "01L")
+	supply := New("device:2001").BuildCommand("&A", "")
+	pointers := New("device:2001").BuildCommand("&T", "99")
+	clock := New("device:2001").BuildCommand("&KR1", "")
+	price := New("device:2001").BuildCommand("&U", "0106199")
+	tests := []struct {
+		command, response string
+		kind              ErrorKind // 0 when valid
+	}{
+		{"(&S)", "(SLLFF)", 0},
+		{"(&S)", "(LLFF)", ErrUnexpectedHeader},
+		{"(&S)", "(SLLFF", ErrTruncated},
+		{"(&S)", "SLLFF)", ErrTruncated},
+		{"(&S)", "(0)", ErrNAK},
+		{"(&V)", "(0)", 0},
+		{total, checked("L0100012345678"), 0},
+		{total, checked("$0100012345678"), ErrUnexpectedHeader},
+		{total, checked("L0200012345678"), ErrUnexpectedHeader},
+		{total, "(L0100012345678FF)", ErrBadChecksum},
+		{total, "(L)", ErrTruncated},
+		{total, "(0)", ErrNAK},
+		{supply, "(0)", 0},
+		{supply, "()", ErrTruncated},
+
+		// Frames with the shape of another command's response
+		{supply, checked(strings.Repeat("0", 48)), 0},
+		{supply, "(SLL)", ErrUnexpectedHeader},
+		{supply, checked("L0100012345678"), ErrUnexpectedHeader},
+		{supply, "(" + strings.Repeat("0", 48) + "FF)", ErrBadChecksum},
+		{pointers, checked("P9900120034"), 0},
+		{pointers, checked("L0100012345678"), ErrUnexpectedHeader},
+		{"(&V)", "(0100123402005678)", 0},
+		{"(&V)", "(SLL)", ErrUnexpectedHeader},
+		{"(&R)", "(151030)", 0},
+		{"(&R)", "(SLL)", ErrUnexpectedHeader},
+		{clock, checked("24031506103000"), 0},
+		{clock, checked("1510300"), ErrUnexpectedHeader},
+		{"(?A)", "(ABCDEF0123456789)", 0},
+		{"(?A)", "(SLL)", ErrUnexpectedHeader},
+		{price, price, 0},
+		{price, checked("L0100012345678"), ErrUnexpectedHeader},
+		{price, "(0)", ErrNAK},
+	}
+	for _, tt := range tests {
+		err := ValidateResponse(tt.command, tt.response)
+		if tt.kind == 0 {
+			if err != nil {
+				t.Errorf("%s answered %s: %v", tt.command, tt.response, err)
+			}
+			continue
+		}
+		var perr *ProtocolError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s answered %s: got %v, want a %s ProtocolError", tt.command, tt.response, err, tt.kind)
+			continue
+		}
+		if perr.Kind != tt.kind || perr.Command != tt.command || string(perr.Raw) != tt.response {
+			t.Errorf("%s answered %s: got %s for %q with %q", tt.command, tt.response, perr.Kind, perr.Command, perr.Raw)
+		}
+	}
+}
+
+func TestCommandHeaderAndParams(t *testing.T) {
+	command := New("device:2001").BuildCommand("&T", "01L")
+	if got := CommandHeader(command); got != "&T" {
+		t.Errorf("header = %q", got)
+	}
+	if got := commandParams(command); got != "01L" {
+		t.Errorf("params = %q", got)
+	}
+	if got := commandParams("(&S)"); got != "" {
+		t.Errorf("params of (&S) = %q", got)
+	}
+	if got := CommandHeader("&S"); got != "" {
+		t.Errorf("header of an unframed command = %q", got)
+	}
+
+	for command, want := range map[string][2]string{
+		"(&S)":        {"&S", ""},
+		"(&A2)":       {"&A", "2"},
+		"(&KR1)":      {"&KR", "1"},
+		"(?LF000001)": {"?LF", "000001"},
+		"(&Z12)":      {"&Z", "12"},
+	} {
+		header, params := CommandHeader(command), commandParams(command)
+		if header != want[0] || params != want[1] {
+			t.Errorf("%s: header %q, params %q, want %q, %q", command, header, params, want[0], want[1])
+		}
+		if KnownHeader(header) != (header != "&Z") {
+			t.Errorf("KnownHeader(%q) = %v", header, KnownHeader(header))
+		}
+	}
+}
+
+// decodeAll returns the frames of r and the bytes of the incomplete frame
+// left at the end.
+func decodeAll(t *testing.T, r *FrameDecoder) (frames []string, partial string) {
+	t.Helper()
+	for {
+		frame, err := r.Next()
+		if err != nil {
+			return frames, r.Discard()
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func checkDecoder(t *testing.T, data []byte, maxLength int) {
+	t.Helper()
+	dec := NewFrameDecoder(bytes.NewReader(data), maxLength)
+	frames, partial := decodeAll(t, dec)
+
+	limit := dec.max
+	n := len(partial) + int(dec.Dropped())
+	for _, frame := range frames {
+		n += len(frame)
+		if len(frame) < 2 || frame[0] != '(' || frame[len(frame)-1] != ')' {
+			t.Fatalf("frame %q is not delimited", frame)
+		}
+		if strings.ContainsAny(frame[1:len(frame)-1], "()") {
+			t.Fatalf("frame %q contains a delimiter", frame)
+		}
+		if len(frame) > limit {
+			t.Fatalf("frame %q is longer than %d", frame, limit)
+		}
+	}
+	if n != len(data) {
+		t.Fatalf("frames, dropped and partial bytes add up to %d, read %d", n, len(data))
+	}
+
+	// The frames do not depend on how the bytes arrive
+	oneByte := NewFrameDecoder(iotest.OneByteReader(bytes.NewReader(data)), maxLength)
+	frames2, partial2 := decodeAll(t, oneByte)
+	if strings.Join(frames, "") != strings.Join(frames2, "") || len(frames) != len(frames2) || partial != partial2 {
+		t.Fatalf("byte by byte: frames %q and partial %q, want %q and %q", frames2, partial2, frames, partial)
+	}
+}
+
+func FuzzFrameDecoder(f *testing.F) {
+	f.Add([]byte("(&S)"), 0)
+	f.Add([]byte("noise(SLLLL)(SLLLL)\r\n(L0100012345678E1)"), 0)
+	f.Add([]byte("(abc(def)((()))"), 0)
+	f.Add([]byte("(0123456789)(01)"), 8)
+	f.Add([]byte("(SLL"), 4)
+	f.Fuzz(func(t *testing.T, data []byte, maxLength int) {
+		checkDecoder(t, data, maxLength%(2*DefaultMaxFrameLength))
+	})
+}
+
+func TestFrameDecoderResync(t *testing.T) {
+	data := "xx(&S)(SLL)junk(abc(def)(" + strings.Repeat("1", 30) + ")(ok)(trail"
+	dec := NewFrameDecoder(strings.NewReader(data), 20)
+	frames, partial := decodeAll(t, dec)
+	want := []string{"(&S)", "(SLL)", "(def)", "(ok)"}
+	if strings.Join(frames, " ") != strings.Join(want, " ") {
+		t.Errorf("frames = %q, want %q", frames, want)
+	}
+	if partial != "(trail" {
+		t.Errorf("partial = %q, want (trail", partial)
+	}
+	if got := dec.Dropped(); got != 2+4+4+32 {
+		t.Errorf("dropped %d bytes, want 42", got)
+	}
+	checkDecoder(t, []byte(data), 20)
+}
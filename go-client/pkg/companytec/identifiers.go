@@ -0,0 +1,290 @@
+package companytec
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// IdentifierType tells what an identifier tag stands for in identified presets.
+type IdentifierType int
+
+const (
+	IdentifierAttendant IdentifierType = 0
+	IdentifierCustomer  IdentifierType = 1
+	IdentifierOdometer  IdentifierType = 2
+)
+
+// MaxIdentifierPosition is the highest identifier memory position ?F and ?LF
+// can address (6 digits).
+const MaxIdentifierPosition = 999999
+
+// Shift is a daily window in which an identifier is accepted, as hhmm strings.
+// "0000"-"0000" means the shift is not used.
+type Shift struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// IdentifierRecord is an identifier (attendant or fleet card tag) as recorded
+// in the device memory.
+type IdentifierRecord struct {
+	Control   string `json:"control"`   // Control code, 2 hex chars
+	Parameter string `json:"parameter"` // Recording parameter, 1 char
+	ID        string `json:"id"`        // Identifier code, 16 hex chars
+	ShiftA    Shift  `json:"shiftA"`
+	ShiftB    Shift  `json:"shiftB"`
+}
+
+// IdentifierEntry is a record read from a memory position.
+type IdentifierEntry struct {
+	Position int `json:"position"`
+	IdentifierRecord
+}
+
+// IdentifiedPreset authorizes a supply for an identifier.
+type IdentifiedPreset struct {
+	Nozzle     string         `json:"nozzle"`
+	ID         string         `json:"id"`
+	Type       IdentifierType `json:"type"`
+	Authorize  bool           `json:"authorize"`
+	Value      int            `json:"value"`      // Preset value, 6 digits
+	Timeout    int            `json:"timeout"`    // Time until nozzle removal, 2 digits
+	PresetType string         `json:"presetType"` // $ for money, V for volume
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789ABCDEFabcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkIdentifier(id string) error {
+	if len(id) != 16 || !isHex(id) {
+		return invalidf("identifier must be 16 hex chars, got %q", id)
+	}
+	return nil
+}
+
+func (s Shift) params() (string, error) {
+	start, end := s.Start, s.End
+	if start == "" {
+		start = "0000"
+	}
+	if end == "" {
+		end = "0000"
+	}
+	for _, v := range []string{start, end} {
+		if len(v) != 4 {
+			return "", invalidf("shift time must be hhmm, got %q", v)
+		}
+		if _, err := atoi("shift time", v); err != nil {
+			return "", invalidf("shift time must be hhmm, got %q", v)
+		}
+	}
+	return start + end, nil
+}
+
+// params formats the record as ?F parameters.
+func (r IdentifierRecord) params() (string, error) {
+	if len(r.Control) != 2 || !isHex(r.Control) {
+		return "", invalidf("control must be 2 hex chars, got %q", r.Control)
+	}
+	if len(r.Parameter) != 1 {
+		return "", invalidf("parameter must be 1 char, got %q", r.Parameter)
+	}
+	if err := checkIdentifier(r.ID); err != nil {
+		return "", err
+	}
+	a, err := r.ShiftA.params()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.ShiftB.params()
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(r.Control) + r.Parameter + strings.ToUpper(r.ID) + a + b, nil
+}
+
+// ParseIdentifierRecord decodes a ?LF response. It returns nil without error
+// for an empty memory position.
+// Format: (CCPIIIIIIIIIIIIIIIIAAAAaaaaBBBBbbbbKK)
+func ParseIdentifierRecord(resp string) (*IdentifierRecord, error) {
+	if resp == noData {
+		return nil, nil
+	}
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 35 {
+		return nil, fmt.Errorf("identifier record too short %q", resp)
+	}
+	r := &fieldReader{data: data}
+	return &IdentifierRecord{
+		Control:   r.str(2),
+		Parameter: r.str(1),
+		ID:        r.str(16),
+		ShiftA:    Shift{Start: r.str(4), End: r.str(4)},
+		ShiftB:    Shift{Start: r.str(4), End: r.str(4)},
+	}, nil
+}
+
+// ParseIdentifier decodes a ?A response into the pending identifier code. It
+// returns "" when no identifier is waiting.
+func ParseIdentifier(resp string) (string, error) {
+	if resp == noData {
+		return "", nil
+	}
+	data, err := frameBody(resp)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 16 {
+		return "", fmt.Errorf("identifier frame too short %q", resp)
+	}
+	return data[:16], nil
+}
+
+// PendingIdentifier returns the unregistered identifier waiting at the reader,
+// or "" when there is none.
+func (c *Client) PendingIdentifier() (string, error) {
+	return c.PendingIdentifierCtx(context.Background())
+}
+
+// PendingIdentifierCtx is like PendingIdentifier but honours ctx.
+func (c *Client) PendingIdentifierCtx(ctx context.Context) (string, error) {
+	resp, err := c.ReadIdentifierCtx(ctx)
+	if err != nil {
+		return "", err
+	}
+	return ParseIdentifier(resp)
+}
+
+// Identifier reads and decodes the identifier recorded at a memory position.
+// It returns nil when the position is empty.
+func (c *Client) Identifier(position int) (*IdentifierRecord, error) {
+	return c.IdentifierCtx(context.Background(), position)
+}
+
+// IdentifierCtx is like Identifier but honours ctx.
+func (c *Client) IdentifierCtx(ctx context.Context, position int) (*IdentifierRecord, error) {
+	resp, err := c.ReadIdentifierFromMemoryCtx(ctx, position)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIdentifierRecord(resp)
+}
+
+// Identifiers iterates over the identifier memory from position from to to
+// (inclusive), skipping empty positions. Iteration stops at the first error.
+func (c *Client) Identifiers(ctx context.Context, from, to int) iter.Seq2[IdentifierEntry, error] {
+	return func(yield func(IdentifierEntry, error) bool) {
+		for pos := from; pos <= to; pos++ {
+			rec, err := c.IdentifierCtx(ctx, pos)
+			if err != nil {
+				yield(IdentifierEntry{Position: pos}, err)
+				return
+			}
+			if rec == nil {
+				continue
+			}
+			if !yield(IdentifierEntry{Position: pos, IdentifierRecord: *rec}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// RecordIdentifier records an identifier with its shift windows (?F).
+func (c *Client) RecordIdentifier(rec IdentifierRecord) (string, error) {
+	return c.RecordIdentifierCtx(context.Background(), rec)
+}
+
+// RecordIdentifierCtx is like RecordIdentifier but honours ctx.
+func (c *Client) RecordIdentifierCtx(ctx context.Context, rec IdentifierRecord) (string, error) {
+	params, err := rec.params()
+	if err != nil {
+		return "", err
+	}
+	cmd := c.BuildCommand("?F", params)
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// DeleteIdentifier deletes an identifier (?F). Position is the record
+// position, or 0 for identifiers recorded at a fixed position.
+func (c *Client) DeleteIdentifier(control, id string, position int) (string, error) {
+	return c.DeleteIdentifierCtx(context.Background(), control, id, position)
+}
+
+// DeleteIdentifierCtx is like DeleteIdentifier but honours ctx.
+func (c *Client) DeleteIdentifierCtx(ctx context.Context, control, id string, position int) (string, error) {
+	if len(control) != 2 || !isHex(control) {
+		return "", invalidf("control must be 2 hex chars, got %q", control)
+	}
+	if err := checkIdentifier(id); err != nil {
+		return "", err
+	}
+	if position < 0 || position > MaxIdentifierPosition {
+		return "", invalidf("identifier position must be between 0 and %d, got %d", MaxIdentifierPosition, position)
+	}
+	params := fmt.Sprintf("%sA%s00%06d00000000", strings.ToUpper(control), strings.ToUpper(id), position)
+	cmd := c.BuildCommand("?F", params)
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// IncrementIdentifier moves past the pending identifier returned by ?A (?I).
+func (c *Client) IncrementIdentifier() (string, error) {
+	return c.IncrementIdentifierCtx(context.Background())
+}
+
+// IncrementIdentifierCtx is like IncrementIdentifier but honours ctx.
+func (c *Client) IncrementIdentifierCtx(ctx context.Context) (string, error) {
+	cmd := c.BuildCommand("?I", "")
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// ClearIdentifierMemory erases every recorded identifier (?F).
+func (c *Client) ClearIdentifierMemory() (string, error) {
+	return c.ClearIdentifierMemoryCtx(context.Background())
+}
+
+// ClearIdentifierMemoryCtx is like ClearIdentifierMemory but honours ctx.
+func (c *Client) ClearIdentifierMemoryCtx(ctx context.Context) (string, error) {
+	cmd := c.BuildCommand("?F", "00L0000000000000000000000100000000")
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// SetPresetIdentified authorizes a supply for an identifier (?F).
+func (c *Client) SetPresetIdentified(p IdentifiedPreset) (string, error) {
+	return c.SetPresetIdentifiedCtx(context.Background(), p)
+}
+
+// SetPresetIdentifiedCtx is like SetPresetIdentified but honours ctx.
+func (c *Client) SetPresetIdentifiedCtx(ctx context.Context, p IdentifiedPreset) (string, error) {
+	if err := checkIdentifier(p.ID); err != nil {
+		return "", err
+	}
+	if p.Type < IdentifierAttendant || p.Type > IdentifierOdometer {
+		return "", invalidf("invalid identifier type %d", p.Type)
+	}
+	if p.PresetType != "$" && p.PresetType != "V" {
+		return "", invalidf("preset type must be $ or V, got %q", p.PresetType)
+	}
+	if p.Value < 0 || p.Value > 999999 || p.Timeout < 0 || p.Timeout > 99 {
+		return "", invalidf("preset value or timeout out of range")
+	}
+	auth := "N"
+	if p.Authorize {
+		auth = "S"
+	}
+	params := fmt.Sprintf("%sP%s%d%s%06d%02d%s00000",
+		p.Nozzle, strings.ToUpper(p.ID), p.Type, auth, p.Value, p.Timeout, p.PresetType)
+	cmd := c.BuildCommand("?F", params)
+	return c.SendCommandCtx(ctx, cmd)
+}
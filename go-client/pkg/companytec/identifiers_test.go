@@ -0,0 +1,128 @@
+package companytec
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseIdentifierRecord(t *testing.T) {
+	rec, err := ParseIdentifierRecord(checked("011ABCDEF01234567890600140014002200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := IdentifierRecord{
+		Control: "01", Parameter: "1", ID: "ABCDEF0123456789",
+		ShiftA: Shift{Start: "0600", End: "1400"},
+		ShiftB: Shift{Start: "1400", End: "2200"},
+	}
+	if *rec != want {
+		t.Errorf("got %+v, want %+v", *rec, want)
+	}
+
+	if rec, err := ParseIdentifierRecord(noData); rec != nil || err != nil {
+		t.Errorf("empty position: %+v, %v", rec, err)
+	}
+	if _, err := ParseIdentifierRecord(checked("011ABCDEF0123456789")); err == nil {
+		t.Error("short record accepted")
+	}
+}
+
+func TestParseIdentifier(t *testing.T) {
+	id, err := ParseIdentifier(checked("ABCDEF0123456789"))
+	if err != nil || id != "ABCDEF0123456789" {
+		t.Errorf("got %q, %v", id, err)
+	}
+	if id, err := ParseIdentifier(noData); id != "" || err != nil {
+		t.Errorf("no identifier: %q, %v", id, err)
+	}
+	if _, err := ParseIdentifier(checked("ABCDEF")); err == nil {
+		t.Error("short identifier accepted")
+	}
+}
+
+func TestIdentifierCommands(t *testing.T) {
+	d := &fakeDevice{answer: func(command string) string { return command }}
+	c := newTestClient(t, d)
+
+	rec := IdentifierRecord{
+		Control: "0a", Parameter: "1", ID: "abcdef0123456789",
+		ShiftA: Shift{Start: "0600", End: "1400"},
+	}
+	if _, err := c.RecordIdentifier(rec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeleteIdentifier("0A", "ABCDEF0123456789", 12); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.IncrementIdentifier(); err != nil {
+		t.Fatal(err)
+	}
+	preset := IdentifiedPreset{
+		Nozzle: "01", ID: "ABCDEF0123456789", Type: IdentifierCustomer,
+		Authorize: true, Value: 5000, Timeout: 30, PresetType: "$",
+	}
+	if _, err := c.SetPresetIdentified(preset); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		checked("?F0A1ABCDEF0123456789" + "06001400" + "00000000"),
+		checked("?F0AAABCDEF0123456789" + "00" + "000012" + "00000000"),
+		checked("?I"),
+		checked("?F01PABCDEF0123456789" + "1S" + "005000" + "30" + "$" + "00000"),
+	}
+	if got := d.Commands(); !slices.Equal(got, want) {
+		t.Errorf("commands\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestIdentifierValidation(t *testing.T) {
+	d := &fakeDevice{answer: func(command string) string { return command }}
+	c := newTestClient(t, d)
+
+	valid := IdentifierRecord{Control: "01", Parameter: "1", ID: "ABCDEF0123456789"}
+	records := map[string]func(*IdentifierRecord){
+		"short id":      func(r *IdentifierRecord) { r.ID = "ABCDEF" },
+		"non-hex id":    func(r *IdentifierRecord) { r.ID = "ABCDEF012345678Z" },
+		"control":       func(r *IdentifierRecord) { r.Control = "1" },
+		"parameter":     func(r *IdentifierRecord) { r.Parameter = "" },
+		"shift length":  func(r *IdentifierRecord) { r.ShiftA.Start = "600" },
+		"shift numeric": func(r *IdentifierRecord) { r.ShiftB.End = "22h0" },
+	}
+	for name, mutate := range records {
+		rec := valid
+		mutate(&rec)
+		if _, err := c.RecordIdentifier(rec); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("%s: got %v, want ErrInvalidParameter", name, err)
+		}
+	}
+
+	presets := map[string]IdentifiedPreset{
+		"type":        {ID: "ABCDEF0123456789", Type: 3, PresetType: "$"},
+		"preset type": {ID: "ABCDEF0123456789", PresetType: "L"},
+		"value":       {ID: "ABCDEF0123456789", PresetType: "V", Value: 1000000},
+		"timeout":     {ID: "ABCDEF0123456789", PresetType: "V", Timeout: 100},
+		"id":          {ID: "ABC", PresetType: "$"},
+	}
+	for name, p := range presets {
+		if _, err := c.SetPresetIdentified(p); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("preset %s: got %v, want ErrInvalidParameter", name, err)
+		}
+	}
+	if _, err := c.DeleteIdentifier("0", "ABCDEF0123456789", 0); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("delete with a bad control: %v", err)
+	}
+	for _, pos := range []int{-1, MaxIdentifierPosition + 1} {
+		if _, err := c.DeleteIdentifier("00", "ABCDEF0123456789", pos); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("delete at position %d: %v", pos, err)
+		}
+		if _, err := c.Identifier(pos); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("read at position %d: %v", pos, err)
+		}
+	}
+
+	if n := len(d.Commands()); n != 0 {
+		t.Errorf("%d invalid commands sent to the device", n)
+	}
+}
@@ -0,0 +1,167 @@
+package companytec
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// Supply pointer modes for &L.
+const (
+	// PointerConsult reads the supply at a position without moving the read
+	// pointer.
+	PointerConsult = "C"
+	// PointerReposition moves the read pointer to a position, so that &A and
+	// &I continue from there.
+	PointerReposition = "R"
+)
+
+// maxMemoryPosition is the highest position &L can address (4 digits).
+const maxMemoryPosition = 9999
+
+// DefaultMemorySize is the number of supplies the device memory is assumed to
+// hold unless WithMemorySize says otherwise: every position &L can address.
+const DefaultMemorySize = maxMemoryPosition + 1
+
+// MemoryPointers are the positions of the supply ring buffer: Write is where
+// the next supply will be stored, Read the supply &A returns next.
+type MemoryPointers struct {
+	Write int `json:"write"`
+	Read  int `json:"read"`
+}
+
+// Pending returns the number of supplies not yet acknowledged with &I, for a
+// ring buffer of the given size.
+func (p MemoryPointers) Pending(size int) int {
+	if size <= 0 {
+		return p.Write - p.Read
+	}
+	return ((p.Write-p.Read)%size + size) % size
+}
+
+// SupplyEntry is a supply read from a memory position.
+type SupplyEntry struct {
+	Position int `json:"position"`
+	SupplyRecord
+}
+
+// ParseMemoryPointers decodes a &T99P response.
+// Format: (P99WWWWRRRRKK)
+func ParseMemoryPointers(resp string) (*MemoryPointers, error) {
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 11 || data[:3] != "P99" {
+		return nil, fmt.Errorf("memory pointers frame malformed %q", resp)
+	}
+
+	r := &fieldReader{data: data[3:]}
+	p := &MemoryPointers{}
+	p.Write = r.num("write pointer", 4)
+	p.Read = r.num("read pointer", 4)
+	if r.err != nil {
+		return nil, fmt.Errorf("memory pointers: %w", r.err)
+	}
+	return p, nil
+}
+
+// Pointers reads and decodes the supply memory pointers.
+func (c *Client) Pointers() (*MemoryPointers, error) {
+	return c.PointersCtx(context.Background())
+}
+
+// PointersCtx is like Pointers but honours ctx.
+func (c *Client) PointersCtx(ctx context.Context) (*MemoryPointers, error) {
+	resp, err := c.ReadMemoryPointersCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMemoryPointers(resp)
+}
+
+// ReadSupplyPointer reads the supply stored at a memory position (&L). Mode
+// is PointerConsult or PointerReposition.
+func (c *Client) ReadSupplyPointer(mode string, position int) (string, error) {
+	return c.ReadSupplyPointerCtx(context.Background(), mode, position)
+}
+
+// ReadSupplyPointerCtx is like ReadSupplyPointer but honours ctx.
+func (c *Client) ReadSupplyPointerCtx(ctx context.Context, mode string, position int) (string, error) {
+	if mode != PointerConsult && mode != PointerReposition {
+		return "", invalidf("pointer mode must be C or R, got %q", mode)
+	}
+	if position < 0 || position > maxMemoryPosition {
+		return "", invalidf("memory position must be between 0 and %d, got %d", maxMemoryPosition, position)
+	}
+	cmd := c.BuildCommand("&L", fmt.Sprintf("%s%04d", mode, position))
+	return c.SendCommandCtx(ctx, cmd)
+}
+
+// SupplyAt reads and decodes the supply stored at a memory position without
+// moving the read pointer. It returns nil when the position is empty.
+func (c *Client) SupplyAt(position int) (*SupplyRecord, error) {
+	return c.SupplyAtCtx(context.Background(), position)
+}
+
+// SupplyAtCtx is like SupplyAt but honours ctx.
+func (c *Client) SupplyAtCtx(ctx context.Context, position int) (*SupplyRecord, error) {
+	resp, err := c.ReadSupplyPointerCtx(ctx, PointerConsult, position)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSupply(resp)
+}
+
+// MoveReadPointer moves the read pointer to a memory position and returns the
+// supply stored there, or nil when the position is empty. Supplies between
+// the new position and the write pointer are returned again by &A.
+func (c *Client) MoveReadPointer(position int) (*SupplyRecord, error) {
+	return c.MoveReadPointerCtx(context.Background(), position)
+}
+
+// MoveReadPointerCtx is like MoveReadPointer but honours ctx.
+func (c *Client) MoveReadPointerCtx(ctx context.Context, position int) (*SupplyRecord, error) {
+	resp, err := c.ReadSupplyPointerCtx(ctx, PointerReposition, position)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSupply(resp)
+}
+
+// DumpSupplies iterates over the supplies stored from position from to to
+// (inclusive) with &L C, skipping empty positions. The live read pointer is
+// not moved, so it is safe while another consumer acknowledges supplies.
+//
+// The memory is a ring buffer of MemorySize positions. When from is after to
+// the walk wraps around the end of the buffer, so that from the write
+// pointer to the position before it covers the whole memory, oldest supply
+// first. Iteration stops at the first error.
+func (c *Client) DumpSupplies(ctx context.Context, from, to int) iter.Seq2[SupplyEntry, error] {
+	return func(yield func(SupplyEntry, error) bool) {
+		size := c.memorySize
+		if from < 0 || from >= size || to < 0 || to >= size {
+			yield(SupplyEntry{Position: from}, invalidf("invalid memory range %d-%d for a memory of %d supplies", from, to, size))
+			return
+		}
+		for pos := from; ; pos = (pos + 1) % size {
+			rec, err := c.SupplyAtCtx(ctx, pos)
+			if err != nil {
+				yield(SupplyEntry{Position: pos}, err)
+				return
+			}
+			if rec != nil && !yield(SupplyEntry{Position: pos, SupplyRecord: *rec}, nil) {
+				return
+			}
+			if pos == to {
+				return
+			}
+		}
+	}
+}
+
+// MemorySize returns the number of supplies the device memory holds, see
+// WithMemorySize.
+func (c *Client) MemorySize() int {
+	return c.memorySize
+}
@@ -0,0 +1,150 @@
+package companytec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestParseMemoryPointers(t *testing.T) {
+	p, err := ParseMemoryPointers(checked("P9901230098"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != (MemoryPointers{Write: 123, Read: 98}) {
+		t.Errorf("pointers %+v", *p)
+	}
+	for _, resp := range []string{checked("P990123"), checked("U0101230098"), checked("P9901X30098")} {
+		if _, err := ParseMemoryPointers(resp); err == nil {
+			t.Errorf("%q accepted", resp)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	tests := []struct {
+		p    MemoryPointers
+		size int
+		want int
+	}{
+		{MemoryPointers{Write: 10, Read: 4}, 1000, 6},
+		{MemoryPointers{Write: 2, Read: 998}, 1000, 4},
+		{MemoryPointers{Write: 5, Read: 5}, 1000, 0},
+		{MemoryPointers{Write: 10, Read: 4}, 0, 6},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Pending(tt.size); got != tt.want {
+			t.Errorf("%+v.Pending(%d) = %d, want %d", tt.p, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	readOnly := []string{"(&S)", "(&A)", checked("&T99P"), checked("&LC0001"), checked("?LF0001"), "(&KR1)"}
+	writes := []string{"(&I)", checked("&LR0001"), checked("&P01001000"), checked("&M01B"), checked("&U0100005879"), checked("?I")}
+	for _, command := range readOnly {
+		if !IsReadOnly(command) {
+			t.Errorf("%q is not read-only", command)
+		}
+	}
+	for _, command := range writes {
+		if IsReadOnly(command) {
+			t.Errorf("%q is read-only", command)
+		}
+	}
+}
+
+// memoryDevice answers &L C with the supply stored at each position of
+// stored, records keyed by position.
+func memoryDevice(stored map[int]int) *fakeDevice {
+	return &fakeDevice{answer: func(command string) string {
+		params := commandParams(command)
+		if CommandHeader(command) != "&L" || len(params) != 5 {
+			return noData
+		}
+		pos, _ := strconv.Atoi(params[1:])
+		record, ok := stored[pos]
+		if !ok {
+			return noData
+		}
+		return checked(fmt.Sprintf("00100000100058790000020115083003%04d000000100000", record))
+	}}
+}
+
+func TestDumpSuppliesWraps(t *testing.T) {
+	// The buffer wrapped: position 0 holds the newest supply
+	d := memoryDevice(map[int]int{2: 7, 3: 8, 0: 9})
+	c := newTestClient(t, d, WithMemorySize(4))
+
+	var positions, records []int
+	for entry, err := range c.DumpSupplies(context.Background(), 2, 1) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, entry.Position)
+		records = append(records, entry.Record)
+	}
+	if !slices.Equal(positions, []int{2, 3, 0}) || !slices.Equal(records, []int{7, 8, 9}) {
+		t.Errorf("positions %v, records %v", positions, records)
+	}
+	if n := len(d.Commands()); n != 4 {
+		t.Errorf("%d positions read, want the whole memory", n)
+	}
+
+	for entry, err := range c.DumpSupplies(context.Background(), 1, 4) {
+		if !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("range past the memory size: %+v, %v", entry, err)
+		}
+	}
+}
+
+func TestDumpSuppliesRange(t *testing.T) {
+	d := memoryDevice(map[int]int{1: 1, 2: 2, 3: 3})
+	c := newTestClient(t, d)
+
+	var records []int
+	for entry, err := range c.DumpSupplies(context.Background(), 2, 2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, entry.Record)
+	}
+	if !slices.Equal(records, []int{2}) {
+		t.Errorf("single position: records %v", records)
+	}
+
+	// Stopping the iteration stops the reads
+	for range c.DumpSupplies(context.Background(), 1, 3) {
+		break
+	}
+	if n := len(d.Commands()); n != 2 {
+		t.Errorf("%d commands sent, want 2", n)
+	}
+}
+
+func TestMoveReadPointerNotResent(t *testing.T) {
+	d := &fakeDevice{}
+	d.answer = dropFirst(d, "&L", func(string) string { return noData })
+	c := newTestClient(t, d)
+
+	if _, err := c.MoveReadPointer(5); err == nil {
+		t.Fatal("&L R succeeded on a dropped connection")
+	}
+	if n := len(d.Commands()); n != 1 {
+		t.Errorf("&L R sent %d times, want once", n)
+	}
+
+	// &L C only reads and is sent again on the new connection
+	d = &fakeDevice{}
+	d.answer = dropFirst(d, "&L", func(string) string { return noData })
+	c = newTestClient(t, d)
+	if _, err := c.SupplyAt(5); err != nil {
+		t.Errorf("&L C on a dropped connection: %v", err)
+	}
+	if n := len(d.Commands()); n != 2 {
+		t.Errorf("&L C sent %d times, want twice", n)
+	}
+}
@@ -0,0 +1,56 @@
+package companytec
+
+import (
+	"context"
+	"time"
+)
+
+// CommandResult describes a command sent with SendCommand, for observers
+// registered with OnCommand.
+type CommandResult struct {
+	Command  string
+	Header   string // See CommandHeader
+	Priority Priority
+	Start    time.Time     // When the command was queued
+	Wait     time.Duration // Time spent in the queue
+	// Duration is the time the command held the connection, reconnect and
+	// resend included. It is zero when the command never got it.
+	Duration time.Duration
+	Response string
+	Err      error
+}
+
+// OnCommand registers fn to be called after every command sent to the device
+// and returns a function that unregisters it. Reads answered by an identical
+// read in flight are not reported. fn is called synchronously once the
+// connection is released, so it must not block.
+func (c *Client) OnCommand(fn func(context.Context, CommandResult)) (cancel func()) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.cmdListeners == nil {
+		c.cmdListeners = make(map[int]func(context.Context, CommandResult))
+	}
+	id := c.nextListener
+	c.nextListener++
+	c.cmdListeners[id] = fn
+
+	return func() {
+		c.stateMu.Lock()
+		defer c.stateMu.Unlock()
+		delete(c.cmdListeners, id)
+	}
+}
+
+func (c *Client) notifyCommand(ctx context.Context, res CommandResult) {
+	c.stateMu.Lock()
+	listeners := make([]func(context.Context, CommandResult), 0, len(c.cmdListeners))
+	for _, fn := range c.cmdListeners {
+		listeners = append(listeners, fn)
+	}
+	c.stateMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ctx, res)
+	}
+}
@@ -0,0 +1,164 @@
+package companytec
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Dialer opens the connection to the device. *net.Dialer implements it; a
+// custom Dialer can go through a serial-to-TCP gateway or return one end of a
+// net.Pipe in tests.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialerFunc adapts a function to the Dialer interface.
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialContext calls f(ctx, network, address).
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// Option configures a Client created with New.
+type Option func(*Client)
+
+// WithTimeout sets the dial, read and write timeouts at once.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.policy.DialTimeout = d
+		c.readTimeout = d
+		c.writeTimeout = d
+	}
+}
+
+// WithDialTimeout bounds every dial attempt. It is also the DialTimeout of the
+// reconnect policy, so it must be given after WithReconnectPolicy.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.policy.DialTimeout = d
+	}
+}
+
+// WithReadTimeout bounds the wait for a response.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.readTimeout = d
+	}
+}
+
+// WithWriteTimeout bounds the write of a command.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.writeTimeout = d
+	}
+}
+
+// WithDialer replaces the default TCP dialer.
+func WithDialer(d Dialer) Option {
+	return func(c *Client) {
+		c.dialer = d
+	}
+}
+
+// WithTLS wraps the connection in TLS, for devices exposed through a TLS
+// terminating gateway. The server name defaults to the host of the address.
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithConnWrapper wraps every new connection with wrap, once TLS is set up,
+// to observe or alter the frames exchanged with the device. The recording
+// package uses it to capture sessions.
+func WithConnWrapper(wrap func(net.Conn) net.Conn) Option {
+	return func(c *Client) {
+		c.wrapConn = wrap
+	}
+}
+
+// WithMaxFrameLength drops the frames read from the device that are longer
+// than n bytes. It defaults to DefaultMaxFrameLength.
+func WithMaxFrameLength(n int) Option {
+	return func(c *Client) {
+		c.maxFrame = n
+	}
+}
+
+// WithMemorySize sets the number of supplies the device memory holds, which
+// DumpSupplies wraps around. It defaults to DefaultMemorySize, also the
+// maximum.
+func WithMemorySize(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.memorySize = min(n, DefaultMemorySize)
+		}
+	}
+}
+
+// WithLogger sets the structured logger. By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithReconnectPolicy replaces DefaultReconnectPolicy. Use a policy with
+// MaxAttempts 0 to disable automatic reconnects and retries.
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(c *Client) {
+		c.policy = policy
+	}
+}
+
+// dial opens and, when configured, secures a new connection.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if c.policy.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.policy.DialTimeout)
+		defer cancel()
+	}
+
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.tlsConfig == nil {
+		return c.wrap(conn), nil
+	}
+
+	config := c.tlsConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(c.addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c.wrap(tlsConn), nil
+}
+
+// wrap applies the WithConnWrapper wrapper, if any.
+func (c *Client) wrap(conn net.Conn) net.Conn {
+	if c.wrapConn == nil {
+		return conn
+	}
+	return c.wrapConn(conn)
+}
+
+// WithQueueLimit bounds the number of commands waiting for the connection;
+// beyond it SendCommand fails with ErrQueueFull. It defaults to
+// DefaultQueueLimit; 0 or less means no limit.
+func WithQueueLimit(n int) Option {
+	return func(c *Client) {
+		c.sched.limit = max(n, 0)
+	}
+}
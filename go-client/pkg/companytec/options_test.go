@@ -0,0 +1,78 @@
+package companytec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewClientDefaults(t *testing.T) {
+	c := NewClient("10.0.0.5", 2001)
+	if c.addr != "10.0.0.5:2001" {
+		t.Errorf("addr %q", c.addr)
+	}
+	if c.readTimeout != 5*time.Second || c.writeTimeout != 5*time.Second {
+		t.Errorf("timeouts %s/%s, want 5s", c.readTimeout, c.writeTimeout)
+	}
+	if c.policy != DefaultReconnectPolicy() {
+		t.Errorf("policy %+v", c.policy)
+	}
+}
+
+func TestTimeoutOptions(t *testing.T) {
+	c := New("device:2001",
+		WithTimeout(time.Second),
+		WithReadTimeout(2*time.Second),
+		WithReconnectPolicy(ReconnectPolicy{MaxAttempts: 1}),
+		WithDialTimeout(3*time.Second),
+	)
+	if c.readTimeout != 2*time.Second || c.writeTimeout != time.Second {
+		t.Errorf("read/write timeouts %s/%s", c.readTimeout, c.writeTimeout)
+	}
+	if c.policy.MaxAttempts != 1 || c.policy.DialTimeout != 3*time.Second {
+		t.Errorf("policy %+v", c.policy)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	hang := DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	c := New("device:2001", WithDialer(hang), WithDialTimeout(20*time.Millisecond))
+	start := time.Now()
+	if err := c.Connect(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the dial to time out", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dial took %s", elapsed)
+	}
+	if c.State() != StateDown {
+		t.Errorf("state %s after a failed dial", c.State())
+	}
+}
+
+func TestConnWrapperAndLogger(t *testing.T) {
+	var buf bytes.Buffer
+	var wrapped atomic.Int32
+	d := &fakeDevice{answer: func(string) string { return "(SLL)" }}
+	newTestClient(t, d,
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+		WithConnWrapper(func(conn net.Conn) net.Conn {
+			wrapped.Add(1)
+			return conn
+		}),
+	)
+	if wrapped.Load() != 1 {
+		t.Errorf("wrapper called %d times", wrapped.Load())
+	}
+	if !strings.Contains(buf.String(), "msg=connected") {
+		t.Errorf("log %q", buf.String())
+	}
+}
@@ -0,0 +1,150 @@
+package companytec
+
+import (
+	"context"
+	"fmt"
+)
+
+// PAF frame lengths, delimiters and checksum included.
+const (
+	supplyPAF1Length = 123
+	supplyPAF2Length = 127
+)
+
+// PAFSupplyRecord is a supply in one of the fiscal (PAF) formats. Besides the
+// standard fields it carries the nozzle totalizer (encerrante) before and
+// after the supply, the full start and end timestamps and the fiscal register
+// fields. Totalizers have 10 digits in PAF1 and 12 digits in PAF2.
+type PAFSupplyRecord struct {
+	Format     int    `json:"format"` // 1 for &A2, 2 for &A3
+	TotalToPay int    `json:"totalToPay"`
+	Volume     int    `json:"volume"`
+	Price      int    `json:"price"`
+	CommaCode  string `json:"commaCode"`
+	SupplyTime int    `json:"supplyTime"`
+	Nozzle     string `json:"nozzle"`
+
+	Start      ClockReading `json:"start"`
+	End        ClockReading `json:"end"`
+	Record     int          `json:"record"`
+	StartTotal int64        `json:"startTotal"` // Volume totalizer before the supply
+	EndTotal   int64        `json:"endTotal"`   // Volume totalizer after the supply
+	Status     string       `json:"status"`
+
+	Tank       int    `json:"tank"`
+	Pump       int    `json:"pump"`
+	PumpSerial string `json:"pumpSerial"` // Manufacturer serial number of the pump
+	Tag        string `json:"tag,omitempty"`
+	Fiscal     int    `json:"fiscal"` // Fiscal register sequence number
+}
+
+// TotalizerModulus returns the value at which the totalizers of the record's
+// format wrap around.
+func (r PAFSupplyRecord) TotalizerModulus() int64 {
+	if r.Format == 2 {
+		return 1_000_000_000_000
+	}
+	return 10_000_000_000
+}
+
+// Dispensed returns the volume measured by the totalizer, accounting for a
+// wrap around during the supply.
+func (r PAFSupplyRecord) Dispensed() int64 {
+	m := r.TotalizerModulus()
+	return ((r.EndTotal-r.StartTotal)%m + m) % m
+}
+
+// ParseSupplyPAF decodes a &A2 (PAF1) or &A3 (PAF2) response, telling the
+// format apart by length. It returns nil without error when the device has no
+// supply stored.
+//
+// Format: TTTTTTLLLLLLPPPPVVCCCCBB, start and end as YYMMDDhhmmss, RRRR, the
+// start and end totalizers (10 digits, 12 in PAF2), SS, tank (2), pump (2),
+// pump serial (20), tag (16) and fiscal number (5), then the checksum.
+func ParseSupplyPAF(resp string) (*PAFSupplyRecord, error) {
+	if resp == noData {
+		return nil, nil
+	}
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &PAFSupplyRecord{}
+	totalDigits := 10
+	switch len(resp) {
+	case supplyPAF1Length:
+		rec.Format = 1
+	case supplyPAF2Length:
+		rec.Format = 2
+		totalDigits = 12
+	default:
+		return nil, fmt.Errorf("PAF supply frame must be %d or %d chars, got %d", supplyPAF1Length, supplyPAF2Length, len(resp))
+	}
+
+	r := &fieldReader{data: data}
+	rec.TotalToPay = r.num("total to pay", 6)
+	rec.Volume = r.num("volume", 6)
+	rec.Price = r.num("price", 4)
+	rec.CommaCode = r.str(2)
+	rec.SupplyTime = r.num("supply time", 4)
+	rec.Nozzle = r.str(2)
+	rec.Start = readTimestamp(r, "start")
+	rec.End = readTimestamp(r, "end")
+	rec.Record = r.num("record", 4)
+	rec.StartTotal = r.num64("start total", totalDigits)
+	rec.EndTotal = r.num64("end total", totalDigits)
+	rec.Status = r.str(2)
+	rec.Tank = r.num("tank", 2)
+	rec.Pump = r.num("pump", 2)
+	rec.PumpSerial = r.str(20)
+	rec.Tag = tag(r.str(16))
+	rec.Fiscal = r.num("fiscal number", 5)
+	if r.err != nil {
+		return nil, fmt.Errorf("PAF supply: %w", r.err)
+	}
+	return rec, nil
+}
+
+// readTimestamp reads a YYMMDDhhmmss field.
+func readTimestamp(r *fieldReader, field string) ClockReading {
+	return ClockReading{
+		Year:     r.num(field+" year", 2),
+		Month:    r.num(field+" month", 2),
+		Day:      r.num(field+" day", 2),
+		Hour:     r.num(field+" hour", 2),
+		Minute:   r.num(field+" minute", 2),
+		Second:   r.num(field+" second", 2),
+		Extended: true,
+	}
+}
+
+// SupplyPAF1 reads and decodes the current supply in the PAF1 format. It
+// returns nil when the device has no supply stored.
+func (c *Client) SupplyPAF1() (*PAFSupplyRecord, error) {
+	return c.SupplyPAF1Ctx(context.Background())
+}
+
+// SupplyPAF1Ctx is like SupplyPAF1 but honours ctx.
+func (c *Client) SupplyPAF1Ctx(ctx context.Context) (*PAFSupplyRecord, error) {
+	resp, err := c.ReadSupplyPAF1Ctx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSupplyPAF(resp)
+}
+
+// SupplyPAF2 reads and decodes the current supply in the PAF2 format. It
+// returns nil when the device has no supply stored.
+func (c *Client) SupplyPAF2() (*PAFSupplyRecord, error) {
+	return c.SupplyPAF2Ctx(context.Background())
+}
+
+// SupplyPAF2Ctx is like SupplyPAF2 but honours ctx.
+func (c *Client) SupplyPAF2Ctx(ctx context.Context) (*PAFSupplyRecord, error) {
+	resp, err := c.ReadSupplyPAF2Ctx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSupplyPAF(resp)
+}
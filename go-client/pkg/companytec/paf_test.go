@@ -0,0 +1,38 @@
+package companytec
+
+import "testing"
+
+func TestParseSupplyPAF(t *testing.T) {
+	rec, err := ParseSupplyPAF(checked("012345002100587903015402260302140531260302140805004200012324680001234567000102SN0000000000000012AB00A1B2C3D4E5F60100017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Format != 1 || rec.Record != 42 || rec.StartTotal != 1232468 || rec.EndTotal != 1234567 || rec.Fiscal != 17 {
+		t.Errorf("PAF1 %+v", rec)
+	}
+	rec, err = ParseSupplyPAF(checked("0123450021005879030154022603021405312603021408050042000001232468000001234567000102SN0000000000000012AB00A1B2C3D4E5F60100017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Format != 2 || rec.StartTotal != 1232468 || rec.TotalizerModulus() != 1_000_000_000_000 {
+		t.Errorf("PAF2 %+v", rec)
+	}
+
+	if rec, err := ParseSupplyPAF(noData); rec != nil || err != nil {
+		t.Errorf("no supply: %+v, %v", rec, err)
+	}
+	if _, err := ParseSupplyPAF(checked("0123450021005879")); err == nil {
+		t.Error("short frame accepted")
+	}
+}
+
+func TestPAFDispensed(t *testing.T) {
+	rec := PAFSupplyRecord{Format: 1, StartTotal: 9_999_999_900, EndTotal: 50}
+	if got := rec.Dispensed(); got != 150 {
+		t.Errorf("dispensed across the wrap: %d, want 150", got)
+	}
+	rec = PAFSupplyRecord{Format: 2, StartTotal: 9_999_999_900, EndTotal: 10_000_000_050}
+	if got := rec.Dispensed(); got != 150 {
+		t.Errorf("PAF2 dispensed: %d, want 150", got)
+	}
+}
@@ -0,0 +1,487 @@
+package companytec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// noData is the frame the device answers with when there is nothing to report
+// (no stored supply, no nozzle dispensing, ...).
+const noData = "(0)"
+
+// maxNozzles is the number of status positions a concentrator reports.
+const maxNozzles = 32
+
+// StatusCode is the single character status of a nozzle as reported by &S.
+type StatusCode string
+
+const (
+	StatusAvailable  StatusCode = "L"
+	StatusBlocked    StatusCode = "B"
+	StatusFinished   StatusCode = "C"
+	StatusRefueling  StatusCode = "A"
+	StatusWaiting    StatusCode = "E"
+	StatusNotPresent StatusCode = "F"
+	StatusReady      StatusCode = "P"
+)
+
+// Description returns a human readable description of the status code.
+func (s StatusCode) Description() string {
+	switch s {
+	case StatusAvailable:
+		return "Available"
+	case StatusBlocked:
+		return "Blocked"
+	case StatusFinished:
+		return "Finished"
+	case StatusRefueling:
+		return "Refueling"
+	case StatusWaiting:
+		return "Waiting"
+	case StatusNotPresent:
+		return "Not Present"
+	case StatusReady:
+		return "Ready"
+	default:
+		return "Unknown"
+	}
+}
+
+// NozzleStatus is the state of a single nozzle position.
+type NozzleStatus struct {
+	Position int        `json:"position"`
+	Nozzle   string     `json:"nozzle"`
+	Code     StatusCode `json:"statusCode"`
+	Status   string     `json:"status"`
+}
+
+// Present reports whether a nozzle is installed at this position.
+func (n NozzleStatus) Present() bool {
+	return n.Code != StatusNotPresent
+}
+
+// SupplyRecord is a completed supply as stored in the device memory.
+// Fields that are missing from short frames are left at their zero value.
+// Tag is filled by the identified format, AttendantTag and CustomerTag by the
+// dual identification format; tags that were not presented are empty.
+type SupplyRecord struct {
+	TotalToPay int    `json:"totalToPay"`
+	Volume     int    `json:"volume"`
+	Price      int    `json:"price"`
+	CommaCode  string `json:"commaCode"`
+	SupplyTime int    `json:"supplyTime"`
+	Nozzle     string `json:"nozzle"`
+	Day        int    `json:"day"`
+	Hour       int    `json:"hour"`
+	Minute     int    `json:"minute"`
+	Month      int    `json:"month,omitempty"`
+	Record     int    `json:"record,omitempty"`
+	FinalTotal int64  `json:"finalTotal,omitempty"`
+	Status     string `json:"status,omitempty"`
+
+	Tag          string `json:"tag,omitempty"`
+	AttendantTag string `json:"attendantTag,omitempty"`
+	CustomerTag  string `json:"customerTag,omitempty"`
+	Odometer     int    `json:"odometer,omitempty"`
+}
+
+// VisualizationEntry is the value currently dispensed by an active nozzle.
+type VisualizationEntry struct {
+	Nozzle string `json:"nozzle"`
+	Value  int    `json:"value"`
+}
+
+// Total is a totalizer reading for a nozzle. Mode is L for volume and $ for value.
+// Raw is the response it was decoded from.
+type Total struct {
+	Mode   string `json:"mode"`
+	Nozzle string `json:"nozzle"`
+	Value  int64  `json:"value"`
+	Raw    string `json:"-"`
+}
+
+// Price holds the unit prices of a nozzle, one per price level
+// (0=cash, 1=credit, 2=debit). Raw is the response it was decoded from.
+type Price struct {
+	Mode   string `json:"mode"`
+	Nozzle string `json:"nozzle"`
+	Levels []int  `json:"levels"`
+	Raw    string `json:"-"`
+}
+
+// ClockReading is the device clock. Calendar (&R) readings only carry day,
+// hour and minute (and month when present); extended readings (&KR1) carry
+// every field.
+type ClockReading struct {
+	Year     int  `json:"year,omitempty"`
+	Month    int  `json:"month,omitempty"`
+	Day      int  `json:"day"`
+	Weekday  int  `json:"weekday,omitempty"`
+	Hour     int  `json:"hour"`
+	Minute   int  `json:"minute"`
+	Second   int  `json:"second"`
+	Extended bool `json:"extended"`
+}
+
+// Time converts the reading to a time.Time in ref's location, taking any
+// field the device did not report from ref.
+func (r ClockReading) Time(ref time.Time) time.Time {
+	year, month := ref.Year(), ref.Month()
+	if r.Year > 0 || r.Extended {
+		year = 2000 + r.Year
+	}
+	if r.Month > 0 {
+		month = time.Month(r.Month)
+	}
+	return time.Date(year, month, r.Day, r.Hour, r.Minute, r.Second, 0, ref.Location())
+}
+
+// frameData strips the ( and ) delimiters from a response.
+func frameData(resp string) (string, error) {
+	if len(resp) < 2 || resp[0] != '(' || resp[len(resp)-1] != ')' {
+		return "", fmt.Errorf("malformed frame %q", resp)
+	}
+	return resp[1 : len(resp)-1], nil
+}
+
+// frameBody strips the delimiters and the trailing two character checksum,
+// after checking that the checksum matches the frame.
+func frameBody(resp string) (string, error) {
+	data, err := frameData(resp)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 2 {
+		return "", fmt.Errorf("frame too short %q", resp)
+	}
+	want := Checksum(resp[:len(resp)-3])
+	if got := strings.ToUpper(data[len(data)-2:]); got != want {
+		return "", fmt.Errorf("bad checksum in %q: expected %s, got %s", resp, want, got)
+	}
+	return data[:len(data)-2], nil
+}
+
+func atoi(field, s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", field, s)
+	}
+	return n, nil
+}
+
+func atoi64(field, s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", field, s)
+	}
+	return n, nil
+}
+
+// fieldReader slices consecutive fixed width fields out of a frame body and
+// remembers the first conversion error.
+type fieldReader struct {
+	data string
+	pos  int
+	err  error
+}
+
+func (r *fieldReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *fieldReader) str(n int) string {
+	if r.pos+n > len(r.data) {
+		if r.err == nil {
+			r.err = fmt.Errorf("frame too short at offset %d", r.pos)
+		}
+		r.pos = len(r.data)
+		return ""
+	}
+	s := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return s
+}
+
+func (r *fieldReader) num(field string, n int) int {
+	s := r.str(n)
+	if r.err != nil {
+		return 0
+	}
+	v, err := atoi(field, s)
+	if err != nil {
+		r.err = err
+	}
+	return v
+}
+
+func (r *fieldReader) num64(field string, n int) int64 {
+	s := r.str(n)
+	if r.err != nil {
+		return 0
+	}
+	v, err := atoi64(field, s)
+	if err != nil {
+		r.err = err
+	}
+	return v
+}
+
+// ParseStatus decodes a &S response into one entry per nozzle position.
+// Format: (S followed by one status character per position.
+func ParseStatus(resp string) ([]NozzleStatus, error) {
+	data, err := frameData(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != 'S' {
+		return nil, fmt.Errorf("unexpected status frame %q", resp)
+	}
+
+	codes := data[1:]
+	if len(codes) > maxNozzles {
+		codes = codes[:maxNozzles]
+	}
+
+	nozzles := make([]NozzleStatus, 0, len(codes))
+	for i := 0; i < len(codes); i++ {
+		code := StatusCode(codes[i : i+1])
+		nozzles = append(nozzles, NozzleStatus{
+			Position: i + 1,
+			Nozzle:   fmt.Sprintf("%02X", i+1),
+			Code:     code,
+			Status:   code.Description(),
+		})
+	}
+	return nozzles, nil
+}
+
+// Supply frame lengths, delimiters and checksum included.
+const (
+	supplyIdentifiedLength = 75
+	supplyDualLength       = 87
+)
+
+// ParseSupply decodes a &A (or &L) response, in the standard or, when the
+// device has identification enabled, the identified format. It returns nil
+// without error when the device has no supply stored.
+// Format: (TTTTTTLLLLLLPPPPVVCCCCBBDDHHMMNNRRRREEEEEEEEEESSKK)
+// Identified: the standard fields followed by the tag (16) and odometer (7).
+func ParseSupply(resp string) (*SupplyRecord, error) {
+	if resp == noData {
+		return nil, nil
+	}
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 30 {
+		return nil, fmt.Errorf("supply frame too short %q", resp)
+	}
+
+	r := &fieldReader{data: data}
+	rec := &SupplyRecord{}
+	parseSupplyFields(r, rec, true)
+	if len(resp) == supplyIdentifiedLength {
+		rec.Tag = tag(r.str(16))
+		rec.Odometer = r.num("odometer", 7)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("supply: %w", r.err)
+	}
+	return rec, nil
+}
+
+// ParseSupplyDual decodes a &@ response. It returns nil without error when
+// the device has no supply stored.
+// Format: the standard fields without the record counter, then the attendant
+// tag (16), the customer tag (16) and the odometer (7).
+func ParseSupplyDual(resp string) (*SupplyRecord, error) {
+	if resp == noData {
+		return nil, nil
+	}
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) != supplyDualLength {
+		return nil, fmt.Errorf("dual identification supply frame must be %d chars, got %d", supplyDualLength, len(resp))
+	}
+
+	r := &fieldReader{data: data}
+	rec := &SupplyRecord{}
+	parseSupplyFields(r, rec, false)
+	rec.AttendantTag = tag(r.str(16))
+	rec.CustomerTag = tag(r.str(16))
+	rec.Odometer = r.num("odometer", 7)
+	if r.err != nil {
+		return nil, fmt.Errorf("dual identification supply: %w", r.err)
+	}
+	return rec, nil
+}
+
+// tag returns an identifier field, or "" when no identifier was presented
+// (all zeros).
+func tag(s string) string {
+	if strings.Trim(s, "0") == "" {
+		return ""
+	}
+	return s
+}
+
+// parseSupplyFields reads the standard supply fields shared by every supply
+// format. When withRecord is false the record counter is not present.
+func parseSupplyFields(r *fieldReader, rec *SupplyRecord, withRecord bool) {
+	rec.TotalToPay = r.num("total to pay", 6)
+	rec.Volume = r.num("volume", 6)
+	rec.Price = r.num("price", 4)
+	rec.CommaCode = r.str(2)
+	rec.SupplyTime = r.num("supply time", 4)
+	rec.Nozzle = r.str(2)
+	rec.Day = r.num("day", 2)
+	rec.Hour = r.num("hour", 2)
+	rec.Minute = r.num("minute", 2)
+	if r.remaining() >= 2 {
+		rec.Month = r.num("month", 2)
+	}
+	if withRecord && r.remaining() >= 4 {
+		rec.Record = r.num("record", 4)
+	}
+	if r.remaining() >= 10 {
+		rec.FinalTotal = r.num64("final total", 10)
+	}
+	if r.remaining() >= 2 {
+		rec.Status = r.str(2)
+	}
+}
+
+// ParseVisualization decodes a &V response. Each active nozzle is reported
+// as BBTTTTTT (nozzle code followed by the dispensed value).
+func ParseVisualization(resp string) ([]VisualizationEntry, error) {
+	entries := []VisualizationEntry{}
+	if resp == noData {
+		return entries, nil
+	}
+	data, err := frameData(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+8 <= len(data); i += 8 {
+		value, err := atoi("visualization value", data[i+2:i+8])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, VisualizationEntry{
+			Nozzle: data[i : i+2],
+			Value:  value,
+		})
+	}
+	return entries, nil
+}
+
+// ParseTotal decodes a &T totalizer response.
+// Format: (MBBVVVVVVVVKK) where M is the mode and BB the nozzle.
+func ParseTotal(resp string) (*Total, error) {
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("total frame too short %q", resp)
+	}
+
+	value, err := atoi64("total", data[3:])
+	if err != nil {
+		return nil, err
+	}
+	return &Total{
+		Mode:   data[0:1],
+		Nozzle: data[1:3],
+		Value:  value,
+		Raw:    resp,
+	}, nil
+}
+
+// ParsePrice decodes a &T price response. Mode U carries two 4 digit levels
+// and mode u three 6 digit levels.
+func ParsePrice(resp string) (*Price, error) {
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("price frame too short %q", resp)
+	}
+
+	width := 4
+	if data[0] == 'u' {
+		width = 6
+	}
+	values := data[3:]
+	if len(values)%width != 0 {
+		return nil, fmt.Errorf("unexpected price length %q", resp)
+	}
+
+	price := &Price{Mode: data[0:1], Nozzle: data[1:3], Raw: resp}
+	for i := 0; i < len(values); i += width {
+		level, err := atoi("price", values[i:i+width])
+		if err != nil {
+			return nil, err
+		}
+		price.Levels = append(price.Levels, level)
+	}
+	return price, nil
+}
+
+// ParseCalendar decodes a &R response.
+// Format: (DDHHMM) optionally followed by the month.
+func ParseCalendar(resp string) (*ClockReading, error) {
+	data, err := frameData(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 {
+		return nil, fmt.Errorf("calendar frame too short %q", resp)
+	}
+
+	r := &fieldReader{data: data}
+	clock := &ClockReading{}
+	clock.Day = r.num("day", 2)
+	clock.Hour = r.num("hour", 2)
+	clock.Minute = r.num("minute", 2)
+	if r.remaining() >= 2 {
+		clock.Month = r.num("month", 2)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("calendar: %w", r.err)
+	}
+	return clock, nil
+}
+
+// ParseClockExtended decodes a &KR1 response.
+// Format: (YYMMDDWWHHMMSSKK) with WW the weekday (01=Sunday).
+func ParseClockExtended(resp string) (*ClockReading, error) {
+	data, err := frameBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 14 {
+		return nil, fmt.Errorf("clock frame too short %q", resp)
+	}
+
+	r := &fieldReader{data: data[len(data)-14:]}
+	clock := &ClockReading{Extended: true}
+	clock.Year = r.num("year", 2)
+	clock.Month = r.num("month", 2)
+	clock.Day = r.num("day", 2)
+	clock.Weekday = r.num("weekday", 2)
+	clock.Hour = r.num("hour", 2)
+	clock.Minute = r.num("minute", 2)
+	clock.Second = r.num("second", 2)
+	if r.err != nil {
+		return nil, fmt.Errorf("clock: %w", r.err)
+	}
+	return clock, nil
+}
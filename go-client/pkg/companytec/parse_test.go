@@ -0,0 +1,394 @@
+package companytec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/frames.golden")
+
+// parsers are the response parsers, by the name used in testdata/frames.txt.
+var parsers = map[string]func(string) (any, error){
+	"status":            func(s string) (any, error) { return ParseStatus(s) },
+	"visualization":     func(s string) (any, error) { return ParseVisualization(s) },
+	"total":             func(s string) (any, error) { return ParseTotal(s) },
+	"price":             func(s string) (any, error) { return ParsePrice(s) },
+	"pointers":          func(s string) (any, error) { return ParseMemoryPointers(s) },
+	"supply":            func(s string) (any, error) { return ParseSupply(s) },
+	"supply_dual":       func(s string) (any, error) { return ParseSupplyDual(s) },
+	"supply_paf":        func(s string) (any, error) { return ParseSupplyPAF(s) },
+	"calendar":          func(s string) (any, error) { return ParseCalendar(s) },
+	"clock":             func(s string) (any, error) { return ParseClockExtended(s) },
+	"identifier":        func(s string) (any, error) { return ParseIdentifier(s) },
+	"identifier_record": func(s string) (any, error) { return ParseIdentifierRecord(s) },
+}
+
+type corpusFrame struct {
+	Parser string `json:"parser"`
+	Frame  string `json:"frame"`
+}
+
+// readCorpus reads testdata/frames.txt.
+func readCorpus(t testing.TB) []corpusFrame {
+	f, err := os.Open(filepath.Join("testdata", "frames.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var frames []corpusFrame
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parser, frame, ok := strings.Cut(line, "\t")
+		if !ok || parsers[parser] == nil {
+			t.Fatalf("bad corpus line %q", line)
+		}
+		frames = append(frames, corpusFrame{parser, frame})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestGolden(t *testing.T) {
+	type decoded struct {
+		corpusFrame
+		Result any    `json:"result,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+	var got bytes.Buffer
+	enc := json.NewEncoder(&got)
+	enc.SetEscapeHTML(false)
+	for _, cf := range readCorpus(t) {
+		d := decoded{corpusFrame: cf}
+		v, err := parsers[cf.Parser](cf.Frame)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Result = v
+		}
+		if err := enc.Encode(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	golden := filepath.Join("testdata", "frames.golden")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotLines := strings.Split(got.String(), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := range max(len(gotLines), len(wantLines)) {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("line %d:\n got %s\nwant %s", i+1, g, w)
+		}
+	}
+}
+
+// checkParsers runs every parser on s. A parser may reject s but must not
+// panic, and only accepts delimited frames.
+func checkParsers(t *testing.T, s string) {
+	t.Helper()
+	for name, parse := range parsers {
+		_, err := parse(s)
+		if err == nil && (len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')') {
+			t.Errorf("%s accepted %q, which is not a frame", name, s)
+		}
+	}
+}
+
+func FuzzParsers(f *testing.F) {
+	for _, cf := range readCorpus(f) {
+		f.Add(cf.Frame)
+	}
+	f.Fuzz(checkParsers)
+}
+
+func TestParsersProperties(t *testing.T) {
+	// Random bodies of every length up to the longest frame, delimited or not
+	prop := func(body []byte, delimit bool) bool {
+		s := string(body)
+		if delimit {
+			s = "(" + s + ")"
+		}
+		checkParsers(t, s)
+		return !t.Failed()
+	}
+	if err := quick.Check(prop, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+
+	// Every corpus frame cut at every length
+	for _, cf := range readCorpus(t) {
+		for i := range len(cf.Frame) {
+			checkParsers(t, cf.Frame[:i])
+			checkParsers(t, cf.Frame[:i]+")")
+		}
+	}
+}
+
+func TestFrameBody(t *testing.T) {
+	tests := []struct {
+		frame, want string
+		ok          bool
+	}{
+		{checked("L0100012345678"), "L0100012345678", true},
+		{"(L0100012345678e1)", "L0100012345678", true},
+		{"(L0100012345678E2)", "", false},
+		{"(012345002100587903015402021407030042000123456700)", "", false},
+		{"(E1)", "", false},
+		{"(A)", "", false},
+	}
+	for _, tt := range tests {
+		got, err := frameBody(tt.frame)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("frameBody(%q) = %q, %v", tt.frame, got, err)
+		}
+	}
+}
+
+// checked frames body with the checksum a device adds to the answers of
+// commands built with BuildCommand.
+func checked(body string) string {
+	return "(" + body + Checksum("("+body) + ")"
+}
+
+func TestParseStatus(t *testing.T) {
+	nozzles, err := ParseStatus("(SLAFB)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NozzleStatus{
+		{Position: 1, Nozzle: "01", Code: StatusAvailable, Status: "Available"},
+		{Position: 2, Nozzle: "02", Code: StatusRefueling, Status: "Refueling"},
+		{Position: 3, Nozzle: "03", Code: StatusNotPresent, Status: "Not Present"},
+		{Position: 4, Nozzle: "04", Code: StatusBlocked, Status: "Blocked"},
+	}
+	if len(nozzles) != len(want) {
+		t.Fatalf("got %d nozzles, want %d", len(nozzles), len(want))
+	}
+	for i := range want {
+		if nozzles[i] != want[i] {
+			t.Errorf("nozzle %d = %+v, want %+v", i+1, nozzles[i], want[i])
+		}
+	}
+	if nozzles[2].Present() || !nozzles[0].Present() {
+		t.Error("Present does not follow the F status")
+	}
+
+	// Positions beyond the 32 of a concentrator are ignored
+	nozzles, err = ParseStatus("(S" + strings.Repeat("L", 40) + ")")
+	if err != nil || len(nozzles) != maxNozzles {
+		t.Errorf("40 positions: got %d nozzles, %v", len(nozzles), err)
+	}
+	if got := nozzles[maxNozzles-1].Nozzle; got != "20" {
+		t.Errorf("nozzle code of position 32 = %q, want 20", got)
+	}
+
+	for _, resp := range []string{"", "(S)", "(XLL)", "SLL", "(SLL"} {
+		if _, err := ParseStatus(resp); err == nil {
+			t.Errorf("ParseStatus(%q) succeeded", resp)
+		}
+	}
+}
+
+func TestParseSupply(t *testing.T) {
+	rec, err := ParseSupply(checked("012345002100587903015402021407030042000123456700"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SupplyRecord{
+		TotalToPay: 12345, Volume: 2100, Price: 5879, CommaCode: "03", SupplyTime: 154,
+		Nozzle: "02", Day: 2, Hour: 14, Minute: 7, Month: 3, Record: 42,
+		FinalTotal: 1234567, Status: "00",
+	}
+	if *rec != want {
+		t.Errorf("got %+v, want %+v", *rec, want)
+	}
+
+	// Older firmware stops after the time fields
+	rec, err = ParseSupply(checked("012345002100587903015402021407"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Minute != 7 || rec.Month != 0 || rec.Record != 0 || rec.Status != "" {
+		t.Errorf("short frame decoded as %+v", *rec)
+	}
+
+	if rec, err := ParseSupply(noData); rec != nil || err != nil {
+		t.Errorf("ParseSupply(%q) = %v, %v, want no supply", noData, rec, err)
+	}
+	for _, resp := range []string{checked("0123450021"), checked("012345002100587903015402XX1407"), "(0123"} {
+		if _, err := ParseSupply(resp); err == nil {
+			t.Errorf("ParseSupply(%q) succeeded", resp)
+		}
+	}
+}
+
+func TestParseSupplyIdentified(t *testing.T) {
+	const standard = "012345002100587903015402021407030042000123456700"
+	rec, err := ParseSupply(checked(standard + "ABCDEF0123456789" + "0123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Record != 42 || rec.Tag != "ABCDEF0123456789" || rec.Odometer != 123456 {
+		t.Errorf("identified supply %+v", *rec)
+	}
+
+	// No identifier presented
+	rec, err = ParseSupply(checked(standard + "0000000000000000" + "0000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Tag != "" || rec.Odometer != 0 || rec.FinalTotal != 1234567 {
+		t.Errorf("anonymous identified supply %+v", *rec)
+	}
+}
+
+func TestParseSupplyDual(t *testing.T) {
+	// The standard fields without the record counter
+	const standard = "01234500210058790301540202140703" + "0001234567" + "00"
+	rec, err := ParseSupplyDual(checked(standard + "ABCDEF0123456789" + "0000000000000000" + "0045000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SupplyRecord{
+		TotalToPay: 12345, Volume: 2100, Price: 5879, CommaCode: "03", SupplyTime: 154,
+		Nozzle: "02", Day: 2, Hour: 14, Minute: 7, Month: 3,
+		FinalTotal: 1234567, Status: "00",
+		AttendantTag: "ABCDEF0123456789", Odometer: 45000,
+	}
+	if *rec != want {
+		t.Errorf("got %+v, want %+v", *rec, want)
+	}
+
+	if rec, err := ParseSupplyDual(noData); rec != nil || err != nil {
+		t.Errorf("ParseSupplyDual(%q) = %v, %v, want no supply", noData, rec, err)
+	}
+	for _, resp := range []string{
+		checked(standard + "ABCDEF0123456789"),
+		checked(standard + "ABCDEF0123456789" + "0000000000000000" + "00450X0"),
+	} {
+		if _, err := ParseSupplyDual(resp); err == nil {
+			t.Errorf("ParseSupplyDual(%q) succeeded", resp)
+		}
+	}
+}
+
+func TestParseVisualization(t *testing.T) {
+	entries, err := ParseVisualization("(0200125004000310)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VisualizationEntry{{Nozzle: "02", Value: 1250}, {Nozzle: "04", Value: 310}}
+	if len(entries) != len(want) || entries[0] != want[0] || entries[1] != want[1] {
+		t.Errorf("got %+v, want %+v", entries, want)
+	}
+
+	entries, err = ParseVisualization(noData)
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("ParseVisualization(%q) = %v, %v, want an empty list", noData, entries, err)
+	}
+	if _, err := ParseVisualization("(0200XX50)"); err == nil {
+		t.Error("non-numeric value accepted")
+	}
+}
+
+func TestParseTotalAndPrice(t *testing.T) {
+	total, err := ParseTotal(checked("L0100012345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *total != (Total{Mode: "L", Nozzle: "01", Value: 12345678, Raw: checked("L0100012345678")}) {
+		t.Errorf("total = %+v", *total)
+	}
+
+	price, err := ParsePrice(checked("U0158796099"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price.Mode != "U" || price.Nozzle != "01" || price.Raw != checked("U0158796099") || len(price.Levels) != 2 || price.Levels[0] != 5879 || price.Levels[1] != 6099 {
+		t.Errorf("price U = %+v", *price)
+	}
+	price, err = ParsePrice(checked("u01005879006099006199"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(price.Levels) != 3 || price.Levels[2] != 6199 {
+		t.Errorf("price u = %+v", *price)
+	}
+
+	if _, err := ParseTotal(checked("L01")); err == nil {
+		t.Error("total without value accepted")
+	}
+	if _, err := ParsePrice(checked("U015879609")); err == nil {
+		t.Error("price with a partial level accepted")
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	cal, err := ParseCalendar("(02140703)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cal != (ClockReading{Day: 2, Hour: 14, Minute: 7, Month: 3}) {
+		t.Errorf("calendar = %+v", *cal)
+	}
+	ref := time.Date(2026, time.October, 16, 9, 0, 0, 0, time.UTC)
+	if got, want := cal.Time(ref), time.Date(2026, time.March, 2, 14, 7, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("calendar time = %v, want %v", got, want)
+	}
+	cal, err = ParseCalendar("(021407)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cal.Time(ref); got.Month() != time.October || got.Year() != 2026 {
+		t.Errorf("calendar without month = %v, want month and year of the reference", got)
+	}
+
+	clock, err := ParseClockExtended(checked("26030202140731"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ClockReading{Year: 26, Month: 3, Day: 2, Weekday: 2, Hour: 14, Minute: 7, Second: 31, Extended: true}
+	if *clock != want {
+		t.Errorf("clock = %+v, want %+v", *clock, want)
+	}
+	if got := clock.Time(ref); !got.Equal(time.Date(2026, time.March, 2, 14, 7, 31, 0, time.UTC)) {
+		t.Errorf("clock time = %v", got)
+	}
+
+	if _, err := ParseCalendar("(0214)"); err == nil {
+		t.Error("short calendar accepted")
+	}
+	if _, err := ParseClockExtended(checked("2603")); err == nil {
+		t.Error("short clock accepted")
+	}
+}
@@ -0,0 +1,201 @@
+package companytec
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// ConnState is the state of the link to the device.
+type ConnState int
+
+const (
+	// StateDown means there is no connection and none is being attempted.
+	StateDown ConnState = iota
+	// StateConnecting means a dial is in progress.
+	StateConnecting
+	// StateConnected means the connection is up.
+	StateConnected
+	// StateDegraded means the connection failed and the client is reconnecting.
+	StateDegraded
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDown:
+		return "down"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	default:
+		return "unknown"
+	}
+}
+
+// StateChange describes a connection state transition.
+type StateChange struct {
+	From ConnState
+	To   ConnState
+	Err  error // Error that caused the transition, if any
+	At   time.Time
+}
+
+// ReconnectPolicy controls how the client redials a lost connection.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64 // Random fraction (0-1) added to or removed from each backoff
+	MaxAttempts    int     // Dial attempts per reconnect; 0 disables automatic reconnect
+	DialTimeout    time.Duration
+}
+
+// DefaultReconnectPolicy returns the policy used by NewClient.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxAttempts:    5,
+		DialTimeout:    5 * time.Second,
+	}
+}
+
+// backoff returns the delay before the given attempt (starting at 1).
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	d := float64(p.InitialBackoff)
+	for i := 2; i < attempt; i++ {
+		d *= p.Multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// idempotentPrefixes lists the commands that only read device state and can
+// safely be resent after a reconnect. Everything else (&I, &L R, &M, &U, &P,
+// ?F, ...) changes the device and is never retried.
+var idempotentPrefixes = []string{
+	"(&S", "(&V", "(&T", "(&R", "(&KR", "(&A", "(&@", "(&LC", "(?A", "(?V", "(?LF",
+}
+
+func isIdempotent(command string) bool {
+	for _, p := range idempotentPrefixes {
+		if strings.HasPrefix(command, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReadOnly reports whether command only reads device state, so that it can
+// be sent again without side effects, e.g. to compare a device against a
+// recording.
+func IsReadOnly(command string) bool {
+	return isIdempotent(command)
+}
+
+// State returns the current connection state.
+func (c *Client) State() ConnState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// OnStateChange registers fn to be called on every connection state
+// transition and returns a function that unregisters it. fn is called
+// synchronously while the connection is locked, so it must not block or call
+// back into the Client.
+func (c *Client) OnStateChange(fn func(StateChange)) (cancel func()) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.listeners == nil {
+		c.listeners = make(map[int]func(StateChange))
+	}
+	id := c.nextListener
+	c.nextListener++
+	c.listeners[id] = fn
+
+	return func() {
+		c.stateMu.Lock()
+		defer c.stateMu.Unlock()
+		delete(c.listeners, id)
+	}
+}
+
+func (c *Client) setState(state ConnState, err error) {
+	c.stateMu.Lock()
+	if c.state == state {
+		c.stateMu.Unlock()
+		return
+	}
+	change := StateChange{From: c.state, To: state, Err: err, At: time.Now()}
+	c.state = state
+	listeners := make([]func(StateChange), 0, len(c.listeners))
+	for _, fn := range c.listeners {
+		listeners = append(listeners, fn)
+	}
+	c.stateMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// reconnectLocked redials the device following the reconnect policy.
+// c.mu must be held.
+func (c *Client) reconnectLocked(ctx context.Context, cause error) error {
+	if c.policy.MaxAttempts <= 0 {
+		c.setState(StateDown, cause)
+		return fmt.Errorf("not connected to device")
+	}
+
+	c.setState(StateDegraded, cause)
+	var err error
+	for attempt := 1; attempt <= c.policy.MaxAttempts; attempt++ {
+		if err = sleepCtx(ctx, c.policy.backoff(attempt)); err != nil {
+			break
+		}
+		c.logger.Info("reconnecting", "addr", c.addr, "attempt", attempt)
+		if err = c.dialLocked(ctx); err == nil {
+			return nil
+		}
+		c.setState(StateDegraded, err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// Leave the state degraded, the next command will try again
+		return fmt.Errorf("reconnect interrupted: %w", ctxErr)
+	}
+	c.setState(StateDown, err)
+	return fmt.Errorf("reconnect failed after %d attempts: %v", c.policy.MaxAttempts, err)
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,154 @@
+package companytec
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("attempt %d: backoff %s, want %s", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.2
+	for range 100 {
+		if got := p.backoff(3); got < 160*time.Millisecond || got > 240*time.Millisecond {
+			t.Fatalf("backoff with 20%% jitter = %s, want 200ms ± 40ms", got)
+		}
+	}
+}
+
+// stateRecorder records the connection state changes of a client.
+type stateRecorder struct {
+	mu      sync.Mutex
+	changes []ConnState
+}
+
+func (r *stateRecorder) record(change StateChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change.To)
+}
+
+func (r *stateRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := make([]string, len(r.changes))
+	for i, state := range r.changes {
+		s[i] = state.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// dropFirst answers like answer but drops the connection instead of
+// answering the first command with the given header.
+func dropFirst(d *fakeDevice, header string, answer func(string) string) func(string) string {
+	var dropped atomic.Bool
+	return func(command string) string {
+		if CommandHeader(command) == header && !dropped.Swap(true) {
+			d.Drop()
+			return ""
+		}
+		return answer(command)
+	}
+}
+
+func TestReconnectResendsReads(t *testing.T) {
+	d := &fakeDevice{}
+	d.answer = dropFirst(d, "&S", func(string) string { return "(SLL)" })
+	c := newTestClient(t, d)
+	var states stateRecorder
+	c.OnStateChange(states.record)
+
+	resp, err := c.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "(SLL)" {
+		t.Errorf("response %q", resp)
+	}
+	if n := d.Dials(); n != 2 {
+		t.Errorf("%d dials, want 2", n)
+	}
+	if got := len(d.Commands()); got != 2 {
+		t.Errorf("%d commands sent, want the status twice", got)
+	}
+	if got, want := states.String(), "degraded connecting connected"; got != want {
+		t.Errorf("state changes %q, want %q", got, want)
+	}
+}
+
+func TestReconnectDoesNotResendWrites(t *testing.T) {
+	d := &fakeDevice{}
+	d.answer = dropFirst(d, "&P", func(command string) string { return command })
+	c := newTestClient(t, d)
+
+	if _, err := c.SetPreset("01", "1000"); err == nil {
+		t.Fatal("preset succeeded on a dropped connection")
+	}
+	if got := len(d.Commands()); got != 1 {
+		t.Errorf("preset sent %d times, want once", got)
+	}
+	if c.IsConnected() || c.State() != StateDegraded {
+		t.Errorf("state %s after a failed write", c.State())
+	}
+
+	// The next command reconnects
+	if _, err := c.SetPreset("01", "1000"); err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != StateConnected {
+		t.Errorf("state %s after reconnecting", c.State())
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	d := &fakeDevice{answer: func(string) string { return "(SLL)" }}
+	c := newTestClient(t, d)
+	d.Refuse(true)
+	d.Drop()
+
+	if _, err := c.GetStatus(); err == nil {
+		t.Fatal("status succeeded with the device unreachable")
+	}
+	attempts := DefaultReconnectPolicy().MaxAttempts
+	if n := d.Dials(); n != 1+attempts {
+		t.Errorf("%d dials, want the initial one and %d attempts", n, attempts)
+	}
+	if c.State() != StateDown {
+		t.Errorf("state %s, want down", c.State())
+	}
+
+	d.Refuse(false)
+	if _, err := c.GetStatus(); err != nil {
+		t.Errorf("status after the device came back: %v", err)
+	}
+}
+
+func TestNoReconnectPolicy(t *testing.T) {
+	d := &fakeDevice{answer: func(string) string { return "(SLL)" }}
+	c := newTestClient(t, d, WithReconnectPolicy(ReconnectPolicy{}))
+	d.Drop()
+
+	if _, err := c.GetStatus(); err == nil {
+		t.Fatal("status succeeded on a dropped connection")
+	}
+	if _, err := c.GetStatus(); err == nil || !strings.Contains(err.Error(), "not connected") {
+		t.Errorf("got %v, want not connected", err)
+	}
+	if n := d.Dials(); n != 1 {
+		t.Errorf("%d dials with reconnects disabled", n)
+	}
+
+	c.Disconnect()
+	if _, err := c.GetStatus(); err == nil {
+		t.Error("status succeeded after Disconnect")
+	}
+}
@@ -0,0 +1,286 @@
+package companytec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority is the scheduling class of a command. While commands queue for the
+// connection, higher classes are sent first and commands of the same class in
+// arrival order.
+type Priority int
+
+const (
+	// PriorityPoll is for status and visualization polling (&S, &V, ?V).
+	PriorityPoll Priority = iota
+	// PriorityRead is for the other reads and their acknowledgements (&A,
+	// &T, &L, &I, ...).
+	PriorityRead
+	// PriorityPrice is for price changes (&U).
+	PriorityPrice
+	// PriorityControl is for commands acting on the forecourt: operating
+	// modes and blocks (&M), presets (&P, ?F) and clock settings (&H, &KW).
+	PriorityControl
+
+	numPriorities = int(PriorityControl) + 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityPoll:
+		return "poll"
+	case PriorityRead:
+		return "read"
+	case PriorityPrice:
+		return "price"
+	case PriorityControl:
+		return "control"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultQueueLimit is the number of commands that can wait for the
+// connection before SendCommand fails with ErrQueueFull.
+const DefaultQueueLimit = 64
+
+// ErrQueueFull is returned when a command is refused because the queue of
+// commands waiting for the connection is full. The device is busy; retry
+// later or shed load.
+var ErrQueueFull = errors.New("command queue full")
+
+var (
+	pollPrefixes    = []string{"(&S", "(&V", "(?V"}
+	controlPrefixes = []string{"(&M", "(&P", "(?F", "(&H", "(&KW"}
+)
+
+// CommandPriority returns the class a command is scheduled in, unless the
+// context overrides it with WithPriority.
+func CommandPriority(command string) Priority {
+	switch {
+	case hasAnyPrefix(command, controlPrefixes):
+		return PriorityControl
+	case strings.HasPrefix(command, "(&U"):
+		return PriorityPrice
+	case hasAnyPrefix(command, pollPrefixes):
+		return PriorityPoll
+	default:
+		return PriorityRead
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context scheduling the commands sent with it in class
+// p instead of the class of their header.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context, command string) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && int(p) < numPriorities {
+		return p
+	}
+	return CommandPriority(command)
+}
+
+// ClassStats are the queue metrics of a priority class.
+type ClassStats struct {
+	Priority Priority
+	Depth    int           // Commands waiting now
+	Sent     uint64        // Commands that got the connection
+	Wait     time.Duration // Total time spent waiting by the sent commands
+	MaxWait  time.Duration
+}
+
+// QueueStats are the metrics of the command queue.
+type QueueStats struct {
+	Depth     int // Commands waiting now, all classes
+	Limit     int
+	Rejected  uint64 // Commands refused with ErrQueueFull
+	Coalesced uint64 // Reads answered by an identical read already in flight
+	// Classes holds the metrics of every class, highest priority first.
+	Classes []ClassStats
+}
+
+// QueueStats returns the metrics of the command queue.
+func (c *Client) QueueStats() QueueStats {
+	return c.sched.stats()
+}
+
+// scheduler hands the connection to one command at a time, the first waiting
+// one of the highest class.
+type scheduler struct {
+	mu        sync.Mutex
+	busy      bool
+	limit     int
+	queues    [numPriorities][]*waiter
+	depth     int
+	rejected  uint64
+	coalesced uint64
+	classes   [numPriorities]ClassStats
+
+	// inflight holds the reads being sent or waiting, by command, for
+	// identical reads to share their response.
+	inflight map[string]*call
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+type call struct {
+	done     chan struct{}
+	response string
+	err      error
+}
+
+func newScheduler(limit int) *scheduler {
+	return &scheduler{limit: limit, inflight: make(map[string]*call)}
+}
+
+// acquire waits for the connection. On success the caller must call release.
+func (s *scheduler) acquire(ctx context.Context, p Priority) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := time.Now()
+	s.mu.Lock()
+	if !s.busy && s.depth == 0 {
+		s.busy = true
+		s.sentLocked(p, 0)
+		s.mu.Unlock()
+		return nil
+	}
+	if s.limit > 0 && s.depth >= s.limit {
+		s.rejected++
+		s.mu.Unlock()
+		return ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	s.queues[p] = append(s.queues[p], w)
+	s.depth++
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		s.mu.Lock()
+		s.sentLocked(p, time.Since(start))
+		s.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.granted {
+			// Handed the connection while giving up: pass it on
+			s.mu.Unlock()
+			s.release()
+			return ctx.Err()
+		}
+		q := s.queues[p]
+		for i, x := range q {
+			if x == w {
+				s.queues[p] = append(q[:i], q[i+1:]...)
+				break
+			}
+		}
+		s.depth--
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *scheduler) sentLocked(p Priority, wait time.Duration) {
+	cs := &s.classes[p]
+	cs.Sent++
+	cs.Wait += wait
+	cs.MaxWait = max(cs.MaxWait, wait)
+}
+
+// release hands the connection to the next waiting command.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := numPriorities - 1; p >= 0; p-- {
+		if q := s.queues[p]; len(q) > 0 {
+			w := q[0]
+			q[0] = nil
+			s.queues[p] = q[1:]
+			s.depth--
+			w.granted = true
+			close(w.ready)
+			return
+		}
+	}
+	s.busy = false
+}
+
+// coalesce sends command with send unless an identical command is already in
+// flight, in which case it waits for and shares its response. A shared
+// response that failed because the sender gave up is not shared: command is
+// sent again.
+func (s *scheduler) coalesce(ctx context.Context, command string, send func() (string, error)) (string, error) {
+	for {
+		s.mu.Lock()
+		cl, ok := s.inflight[command]
+		if !ok {
+			cl = &call{done: make(chan struct{})}
+			s.inflight[command] = cl
+			s.mu.Unlock()
+
+			cl.response, cl.err = send()
+			s.mu.Lock()
+			delete(s.inflight, command)
+			s.mu.Unlock()
+			close(cl.done)
+			return cl.response, cl.err
+		}
+		s.coalesced++
+		s.mu.Unlock()
+
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if isContextError(cl.err) && ctx.Err() == nil {
+			continue
+		}
+		return cl.response, cl.err
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (s *scheduler) stats() QueueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := QueueStats{
+		Depth:     s.depth,
+		Limit:     s.limit,
+		Rejected:  s.rejected,
+		Coalesced: s.coalesced,
+		Classes:   make([]ClassStats, 0, numPriorities),
+	}
+	for p := numPriorities - 1; p >= 0; p-- {
+		cs := s.classes[p]
+		cs.Priority = Priority(p)
+		cs.Depth = len(s.queues[p])
+		st.Classes = append(st.Classes, cs)
+	}
+	return st
+}
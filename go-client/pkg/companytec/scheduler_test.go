@@ -0,0 +1,219 @@
+package companytec
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCommandPriority(t *testing.T) {
+	tests := map[string]Priority{
+		"(&S)":             PriorityPoll,
+		"(&V)":             PriorityPoll,
+		"(?V)":             PriorityPoll,
+		"(&A)":             PriorityRead,
+		"(&T01L)":          PriorityRead,
+		"(&I)":             PriorityRead,
+		"(&U01006199XX)":   PriorityPrice,
+		"(&M01BXX)":        PriorityControl,
+		"(&P01001000XX)":   PriorityControl,
+		"(?F01PXX)":        PriorityControl,
+		"(&H10300315)":     PriorityControl,
+		"(&KW1240315XX)":   PriorityControl,
+		"(&KR1XX)":         PriorityRead,
+		"(something else)": PriorityRead,
+	}
+	for command, want := range tests {
+		if got := CommandPriority(command); got != want {
+			t.Errorf("%s: %s, want %s", command, got, want)
+		}
+	}
+
+	ctx := WithPriority(context.Background(), PriorityControl)
+	if got := priorityOf(ctx, "(&S)"); got != PriorityControl {
+		t.Errorf("overridden priority %s", got)
+	}
+	if got := priorityOf(WithPriority(context.Background(), 9), "(&S)"); got != PriorityPoll {
+		t.Errorf("invalid override gave %s", got)
+	}
+}
+
+// queued waits until the scheduler has n commands waiting.
+func queued(t *testing.T, s *scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.stats().Depth != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("depth %d, want %d", s.stats().Depth, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := newScheduler(0)
+	ctx := context.Background()
+	if err := s.acquire(ctx, PriorityPoll); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, w := range []struct {
+		name string
+		p    Priority
+	}{
+		{"poll", PriorityPoll}, {"control 1", PriorityControl}, {"read", PriorityRead},
+		{"control 2", PriorityControl}, {"price", PriorityPrice},
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.acquire(ctx, w.p); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, w.name)
+			mu.Unlock()
+			s.release()
+		}()
+		// Queue in arrival order
+		queued(t, s, i+1)
+	}
+	s.release()
+	wg.Wait()
+
+	want := []string{"control 1", "control 2", "price", "read", "poll"}
+	if !slices.Equal(order, want) {
+		t.Errorf("order %v, want %v", order, want)
+	}
+	st := s.stats()
+	if st.Depth != 0 || st.Classes[0].Priority != PriorityControl || st.Classes[0].Sent != 2 || st.Classes[3].Sent != 2 {
+		t.Errorf("stats %+v", st)
+	}
+	if st.Classes[0].MaxWait == 0 || st.Classes[0].Wait < st.Classes[0].MaxWait {
+		t.Errorf("control wait %+v", st.Classes[0])
+	}
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	s := newScheduler(1)
+	ctx := context.Background()
+	s.acquire(ctx, PriorityRead)
+	go func() {
+		if s.acquire(ctx, PriorityRead) == nil {
+			s.release()
+		}
+	}()
+	queued(t, s, 1)
+	if err := s.acquire(ctx, PriorityControl); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("third command: %v, want ErrQueueFull", err)
+	}
+	if st := s.stats(); st.Rejected != 1 || st.Limit != 1 {
+		t.Errorf("stats %+v", st)
+	}
+	s.release()
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(0)
+	s.acquire(context.Background(), PriorityRead)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.acquire(ctx, PriorityControl) }()
+	queued(t, s, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled waiter: %v", err)
+	}
+	if st := s.stats(); st.Depth != 0 || st.Classes[0].Depth != 0 {
+		t.Errorf("canceled waiter still queued: %+v", st)
+	}
+
+	// The connection is free again once released
+	s.release()
+	if err := s.acquire(context.Background(), PriorityPoll); err != nil {
+		t.Fatal(err)
+	}
+	s.release()
+	if err := s.acquire(ctx, PriorityPoll); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire with a done context: %v", err)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	s := newScheduler(0)
+	ctx := context.Background()
+	release := make(chan struct{})
+	var sent atomic.Int32
+	send := func() (string, error) {
+		sent.Add(1)
+		<-release
+		return "(response)", nil
+	}
+
+	results := make(chan string, 3)
+	for range 3 {
+		go func() {
+			resp, _ := s.coalesce(ctx, "(&S)", send)
+			results <- resp
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.stats().Coalesced != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for range 3 {
+		if resp := <-results; resp != "(response)" {
+			t.Errorf("response %q", resp)
+		}
+	}
+	if n := sent.Load(); n != 1 {
+		t.Errorf("sent %d times, want once", n)
+	}
+}
+
+func TestCoalesceSenderGivesUp(t *testing.T) {
+	s := newScheduler(0)
+	started := make(chan struct{})
+	giveUp := make(chan struct{})
+	go s.coalesce(context.Background(), "(&S)", func() (string, error) {
+		close(started)
+		<-giveUp
+		return "", context.Canceled
+	})
+	<-started
+
+	resp := make(chan string)
+	go func() {
+		r, _ := s.coalesce(context.Background(), "(&S)", func() (string, error) { return "(mine)", nil })
+		resp <- r
+	}()
+	deadline := time.Now().Add(time.Second)
+	for s.stats().Coalesced != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(giveUp)
+	// The canceled response is not shared: the command is sent again
+	if r := <-resp; r != "(mine)" {
+		t.Errorf("response %q", r)
+	}
+}
+
+func TestQueueLimitOption(t *testing.T) {
+	d := &fakeDevice{answer: func(command string) string { return command }}
+	if c := newTestClient(t, d); c.QueueStats().Limit != DefaultQueueLimit {
+		t.Errorf("default limit %d", c.QueueStats().Limit)
+	}
+	if c := newTestClient(t, d, WithQueueLimit(-1)); c.QueueStats().Limit != 0 {
+		t.Errorf("unlimited queue limit %d", c.QueueStats().Limit)
+	}
+}
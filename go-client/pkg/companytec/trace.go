@@ -0,0 +1,99 @@
+package companytec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the client spans.
+const tracerName = "companytec-client/pkg/companytec"
+
+// WithTracerProvider creates the command spans with tp. By default they go to
+// the global OpenTelemetry tracer provider, which drops them until one is
+// installed with otel.SetTracerProvider.
+//
+// Every SendCommand is a client span named after the command header, with
+// child spans for the dial of a new connection and for each round trip.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Span attributes of the commands.
+const (
+	attrHeader        = attribute.Key("companytec.header")
+	attrPriority      = attribute.Key("companytec.priority")
+	attrNozzle        = attribute.Key("companytec.nozzle")
+	attrBytesSent     = attribute.Key("companytec.bytes_sent")
+	attrBytesReceived = attribute.Key("companytec.bytes_received")
+	attrChecksum      = attribute.Key("companytec.checksum")
+	attrQueueWait     = attribute.Key("companytec.queue_wait_ms")
+	attrLockWait      = attribute.Key("companytec.lock_wait_ms")
+	attrServerAddress = attribute.Key("server.address")
+)
+
+// nozzleHeaders lists the commands whose parameters start with a nozzle.
+var nozzleHeaders = map[string]bool{"&T": true, "&U": true, "&P": true, "&M": true}
+
+// commandNozzle returns the nozzle a command is for, or "" when it is not
+// for one. &T99 (pointers) and &M99 (blacklist) are not.
+func commandNozzle(command string) string {
+	if !nozzleHeaders[CommandHeader(command)] {
+		return ""
+	}
+	params := commandParams(command)
+	if len(params) < 2 || params[:2] == "99" {
+		return ""
+	}
+	return params[:2]
+}
+
+// checksumResult tells whether the checksum of a response was checked: "ok",
+// "bad", or "none" for frames without one (raw commands, (0) answers, I/O
+// errors).
+func checksumResult(command, response string, err error) string {
+	var perr *ProtocolError
+	if errors.As(err, &perr) {
+		switch perr.Kind {
+		case ErrBadChecksum:
+			return "bad"
+		case ErrTruncated, ErrNAK:
+			return "none"
+		}
+	}
+	if !HasChecksum(command) || response == "" || response == noData {
+		return "none"
+	}
+	return "ok"
+}
+
+// endSpan records err, if any, and ends span. The error is recorded with its
+// identifier tags masked (see RedactError), as span.RecordError would export
+// the frames of a protocol error as they are.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		msg := RedactError(err)
+		span.AddEvent("exception", trace.WithAttributes(
+			attribute.String("exception.type", fmt.Sprintf("%T", err)),
+			attribute.String("exception.message", msg),
+		))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// msec returns d in milliseconds, with a fractional part.
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
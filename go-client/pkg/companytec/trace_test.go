@@ -0,0 +1,140 @@
+package companytec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanAttr returns the value of an attribute of span.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestCommandSpans(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	d := &fakeDevice{answer: func(command string) string {
+		switch commandParams(command) {
+		case "01L":
+			return checked("L0100012345678")
+		case "02L":
+			return "(L0200012345678FF)"
+		}
+		return noData
+	}}
+	c := newTestClient(t, d, WithTracerProvider(tp))
+
+	if _, err := c.Total("01", "L"); err != nil {
+		t.Fatal(err)
+	}
+	c.ReadTotal("02", "L")
+
+	spans := rec.Ended()
+	var dial, commands, trips []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		switch s.Name() {
+		case "companytec dial":
+			dial = append(dial, s)
+		case "companytec &T":
+			commands = append(commands, s)
+		case "companytec round trip":
+			trips = append(trips, s)
+		default:
+			t.Errorf("unexpected span %q", s.Name())
+		}
+	}
+	if len(dial) != 1 || spanAttr(dial[0], attrServerAddress).AsString() != "device:2001" {
+		t.Errorf("dial spans %v", dial)
+	}
+	if len(commands) != 2 || len(trips) != 2 {
+		t.Fatalf("%d command and %d round trip spans, want 2 of each", len(commands), len(trips))
+	}
+
+	ok := commands[0]
+	if ok.SpanKind() != trace.SpanKindClient || ok.Status().Code == codes.Error {
+		t.Errorf("command span kind %s, status %+v", ok.SpanKind(), ok.Status())
+	}
+	if spanAttr(ok, attrHeader).AsString() != "&T" || spanAttr(ok, attrNozzle).AsString() != "01" ||
+		spanAttr(ok, attrChecksum).AsString() != "ok" || spanAttr(ok, attrBytesReceived).AsInt64() != 18 {
+		t.Errorf("command span attributes %v", ok.Attributes())
+	}
+	if trips[0].Parent().SpanID() != ok.SpanContext().SpanID() {
+		t.Error("the round trip is not a child of its command")
+	}
+
+	bad := commands[1]
+	if bad.Status().Code != codes.Error || spanAttr(bad, attrChecksum).AsString() != "bad" || len(bad.Events()) != 1 {
+		t.Errorf("bad checksum span: status %+v, attributes %v", bad.Status(), bad.Attributes())
+	}
+}
+
+func TestCommandSpansRedacted(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	d := &fakeDevice{answer: func(command string) string { return noData }}
+	c := newTestClient(t, d, WithTracerProvider(tp))
+
+	// Rejected: the error holds the command frame
+	if _, err := c.BlacklistIdentifier("ABCDEF0123456789"); err == nil {
+		t.Fatal("blacklist command accepted")
+	}
+	for _, s := range rec.Ended() {
+		exported := fmt.Sprint(s.Name(), s.Attributes(), s.Events(), s.Status())
+		if strings.Contains(exported, "ABCDEF012345") {
+			t.Errorf("tag exported in %s", exported)
+		}
+		if s.Name() == "companytec &M" && (s.Status().Code != codes.Error || len(s.Events()) != 1) {
+			t.Errorf("command span: status %+v, events %v", s.Status(), s.Events())
+		}
+	}
+}
+
+func TestCommandNozzle(t *testing.T) {
+	tests := map[string]string{
+		"(&T01L)":        "01",
+		"(&T99)":         "",
+		"(&M99AB)":       "",
+		"(&U0106199)":    "01",
+		"(&S)":           "",
+		"(&P03001000)":   "03",
+		"(&T":            "",
+		checked("&M02B"): "02",
+	}
+	for command, want := range tests {
+		if got := commandNozzle(command); got != want {
+			t.Errorf("commandNozzle(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestChecksumResult(t *testing.T) {
+	tests := []struct {
+		command, response string
+		err               error
+		want              string
+	}{
+		{checked("&T01L"), checked("L0100012345678"), nil, "ok"},
+		{checked("&T01L"), "(L01FF)", &ProtocolError{Kind: ErrBadChecksum}, "bad"},
+		{checked("&T01L"), "(L01", &ProtocolError{Kind: ErrTruncated}, "none"},
+		{checked("&T01L"), noData, nil, "none"},
+		{"(&S)", "(S0102)", nil, "none"},
+		{checked("&T01L"), "", nil, "none"},
+	}
+	for _, tt := range tests {
+		if got := checksumResult(tt.command, tt.response, tt.err); got != tt.want {
+			t.Errorf("checksumResult(%q, %q, %v) = %s, want %s", tt.command, tt.response, tt.err, got, tt.want)
+		}
+	}
+}
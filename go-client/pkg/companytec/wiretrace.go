@@ -0,0 +1,304 @@
+package companytec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// WithWireTrace logs every frame written to and read from the device at debug
+// level: "tx" and "rx" records with the frame, its decoded meaning and, for
+// responses, the latency. Identifier tags are masked (see RedactCommand), so
+// traces can be shared. Frames are logged on the logger set by WithLogger,
+// which must accept debug records.
+func WithWireTrace() Option {
+	return func(c *Client) {
+		c.wireTrace = true
+	}
+}
+
+// keptTagChars is the number of trailing characters of a masked tag left
+// readable, so that traces can still tell tags apart.
+const keptTagChars = 4
+
+// maskTag masks frame[start:end], keeping the last characters.
+func maskTag(frame string, start, end int) string {
+	if start < 0 || end > len(frame) || start >= end {
+		return frame
+	}
+	keep := max(end-keptTagChars, start)
+	return frame[:start] + strings.Repeat("*", keep-start) + frame[keep:]
+}
+
+// RedactCommand masks the identifier tags of a command frame: the tag of ?F
+// (record, delete or identified preset) and of &M99 (blacklist).
+func RedactCommand(command string) string {
+	switch {
+	case strings.HasPrefix(command, "(?F"):
+		// CC P ID, or nozzle P ID for identified presets
+		return maskTag(command, 6, 22)
+	case strings.HasPrefix(command, "(&M99") && len(command) >= 22:
+		// b or l, then ID
+		return maskTag(command, 6, 22)
+	}
+	return command
+}
+
+// RedactResponse masks the identifier tags of a response to command: pending
+// (?A) and recorded (?LF) identifiers, the tags of identified, dual
+// identification and PAF supplies, and those of ?F and &M99 commands echoed
+// by the device. Identified visualization (?V) frames have every run of 16
+// hex digits masked.
+func RedactResponse(command, response string) string {
+	n := len(response)
+	switch CommandHeader(command) {
+	case "?A":
+		return maskTag(response, 1, 17)
+	case "?LF":
+		return maskTag(response, 4, 20)
+	case "&A", "&L":
+		switch n {
+		case supplyIdentifiedLength:
+			// Tag (16), odometer (7), checksum
+			return maskTag(response, n-26, n-10)
+		case supplyPAF1Length, supplyPAF2Length:
+			// Tag (16), fiscal number (5), checksum
+			return maskTag(response, n-24, n-8)
+		}
+	case "&@":
+		if n == supplyDualLength {
+			// Attendant and customer tags (32), odometer (7), checksum
+			return maskTag(maskTag(response, n-42, n-26), n-26, n-10)
+		}
+	case "?F", "&M":
+		return RedactCommand(response)
+	case "?V":
+		return maskHexRuns(response)
+	}
+	return response
+}
+
+// RedactError returns the message of err with its identifier tags masked:
+// the frames of a protocol error it wraps as by RedactCommand and
+// RedactResponse, and every other run of 16 hex digits, such as an
+// identifier quoted by an ErrInvalidParameter error.
+func RedactError(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		return maskHexRuns(msg)
+	}
+	before, after, found := strings.Cut(msg, perr.Error())
+	if !found {
+		return maskHexRuns(msg)
+	}
+	redacted := perr.format(RedactCommand(perr.Command), RedactResponse(perr.Command, string(perr.Raw)))
+	return maskHexRuns(before) + redacted + maskHexRuns(after)
+}
+
+// maskHexRuns masks every run of at least 16 hex digits.
+func maskHexRuns(s string) string {
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && isHex(s[i:i+1]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= 16 {
+			s = maskTag(s, start, i)
+		}
+		start = -1
+	}
+	return s
+}
+
+// traceTX logs a frame written to the device.
+func (c *Client) traceTX(ctx context.Context, seq uint64, command string) {
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "tx",
+		slog.String("addr", c.addr),
+		slog.Uint64("seq", seq),
+		slog.String("frame", RedactCommand(command)),
+		slog.String("meaning", describeCommand(command)),
+	)
+}
+
+// traceRX logs a frame read from the device, or the failed read.
+func (c *Client) traceRX(ctx context.Context, seq uint64, command, response string, latency time.Duration, err error) {
+	attrs := []slog.Attr{
+		slog.String("addr", c.addr),
+		slog.Uint64("seq", seq),
+		slog.String("frame", RedactResponse(command, response)),
+		slog.Duration("latency", latency),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", RedactError(err)))
+	} else if meaning := describeResponse(command, response); meaning != "" {
+		attrs = append(attrs, slog.String("meaning", meaning))
+	}
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "rx", attrs...)
+}
+
+// describeCommand tells what a command frame asks for.
+func describeCommand(command string) string {
+	params := commandParams(command)
+	nozzle := commandNozzle(command)
+	switch CommandHeader(command) {
+	case "&S":
+		return "read status"
+	case "&V":
+		return "read visualization"
+	case "&T":
+		if strings.HasPrefix(params, "99") {
+			return "read memory pointers"
+		}
+		switch strings.TrimPrefix(params, nozzle) {
+		case "L":
+			return "read volume total of nozzle " + nozzle
+		case "$":
+			return "read value total of nozzle " + nozzle
+		case "U", "u":
+			return "read prices of nozzle " + nozzle
+		}
+		return "read totalizer"
+	case "&R":
+		return "read calendar"
+	case "&KR":
+		return "read clock"
+	case "&KW":
+		return "set clock"
+	case "&H":
+		return "set calendar"
+	case "&A":
+		if strings.HasPrefix(command, "(&A2") || strings.HasPrefix(command, "(&A3") {
+			return "read fiscal supply"
+		}
+		return "read supply"
+	case "&@":
+		return "read supply with dual identification"
+	case "&L":
+		if strings.HasPrefix(params, PointerReposition) {
+			return "move read pointer"
+		}
+		return "read supply at position"
+	case "&I":
+		return "acknowledge supply"
+	case "&M":
+		if rest, ok := strings.CutPrefix(params, "99"); ok {
+			switch {
+			case strings.HasPrefix(rest, "b"):
+				return "blacklist identifier"
+			case strings.HasPrefix(rest, "l"):
+				return "unblacklist identifier"
+			case strings.HasPrefix(rest, "c"):
+				return "clear blacklist"
+			}
+			return "blacklist"
+		}
+		return fmt.Sprintf("set mode %s on nozzle %s", strings.TrimPrefix(params, nozzle), nozzle)
+	case "&U":
+		return "change price of nozzle " + nozzle
+	case "&P":
+		return "preset nozzle " + nozzle
+	case "?A":
+		return "read pending identifier"
+	case "?I":
+		return "skip pending identifier"
+	case "?V":
+		return "read identified visualization"
+	case "?LF":
+		return "read identifier record"
+	case "?F":
+		if len(params) > 2 {
+			switch params[2] {
+			case 'P':
+				return "identified preset on nozzle " + params[:2]
+			case 'A':
+				return "delete identifier"
+			case 'L':
+				return "clear identifier memory"
+			}
+		}
+		return "record identifier"
+	}
+	return "unknown command"
+}
+
+// describeResponse decodes a response into a short summary, or "" when it
+// has nothing to add to the frame.
+func describeResponse(command, response string) string {
+	header := CommandHeader(command)
+	if response == noData {
+		if emptyResponseHeaders[header] {
+			return "no data"
+		}
+		return "rejected"
+	}
+	switch CommandHeader(command) {
+	case "&S":
+		statuses, err := ParseStatus(response)
+		if err != nil {
+			return ""
+		}
+		var parts []string
+		for _, n := range statuses {
+			if n.Present() {
+				parts = append(parts, n.Nozzle+" "+strings.ToLower(n.Status))
+			}
+		}
+		return strings.Join(parts, ", ")
+	case "&V":
+		entries, err := ParseVisualization(response)
+		if err != nil {
+			return ""
+		}
+		var parts []string
+		for _, e := range entries {
+			parts = append(parts, fmt.Sprintf("%s at %d", e.Nozzle, e.Value))
+		}
+		return strings.Join(parts, ", ")
+	case "&T":
+		if strings.HasPrefix(commandParams(command), "99") {
+			if p, err := ParseMemoryPointers(response); err == nil {
+				return fmt.Sprintf("write %d, read %d", p.Write, p.Read)
+			}
+			return ""
+		}
+		if p, err := ParsePrice(response); err == nil && (p.Mode == "U" || p.Mode == "u") {
+			return fmt.Sprintf("nozzle %s prices %v", p.Nozzle, p.Levels)
+		}
+		if t, err := ParseTotal(response); err == nil {
+			return fmt.Sprintf("nozzle %s total %d", t.Nozzle, t.Value)
+		}
+	case "&A", "&L":
+		if len(response) == supplyPAF1Length || len(response) == supplyPAF2Length {
+			if r, err := ParseSupplyPAF(response); err == nil {
+				return fmt.Sprintf("nozzle %s, volume %d, totalizer %d to %d", r.Nozzle, r.Volume, r.StartTotal, r.EndTotal)
+			}
+			return ""
+		}
+		if r, err := ParseSupply(response); err == nil && r != nil {
+			return fmt.Sprintf("nozzle %s, volume %d, to pay %d, record %d", r.Nozzle, r.Volume, r.TotalToPay, r.Record)
+		}
+	case "&@":
+		if r, err := ParseSupplyDual(response); err == nil && r != nil {
+			return fmt.Sprintf("nozzle %s, volume %d, to pay %d", r.Nozzle, r.Volume, r.TotalToPay)
+		}
+	case "&R":
+		if r, err := ParseCalendar(response); err == nil {
+			return fmt.Sprintf("day %d %02d:%02d", r.Day, r.Hour, r.Minute)
+		}
+	case "&KR":
+		if r, err := ParseClockExtended(response); err == nil {
+			return fmt.Sprintf("20%02d-%02d-%02d %02d:%02d:%02d", r.Year%100, r.Month, r.Day, r.Hour, r.Minute, r.Second)
+		}
+	}
+	return ""
+}
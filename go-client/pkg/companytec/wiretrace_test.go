@@ -0,0 +1,157 @@
+package companytec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const testTag = "ABCDEF0123456789"
+
+func TestRedactCommand(t *testing.T) {
+	tests := map[string]string{
+		checked("?F00A" + testTag + "0000000100000000"):   "(?F00A************6789",
+		checked("?F01P" + testTag + "1A0000200300$00000"): "(?F01P************6789",
+		checked("&M99b" + testTag):                        "(&M99b************6789",
+		checked("&M99c"):                                  checked("&M99c"),
+		checked("&T01L"):                                  checked("&T01L"),
+		"(&S)":                                            "(&S)",
+	}
+	for command, want := range tests {
+		if got := RedactCommand(command); !strings.HasPrefix(got, want) || strings.Contains(got, testTag) {
+			t.Errorf("RedactCommand(%q) = %q, want %q...", command, got, want)
+		}
+		if got := RedactCommand(command); len(got) != len(command) {
+			t.Errorf("RedactCommand(%q) changed the frame length", command)
+		}
+	}
+}
+
+func TestRedactResponse(t *testing.T) {
+	identified := "(" + strings.Repeat("0", 48) + testTag + "0098765" + "00)"
+	dual := "(" + strings.Repeat("0", 44) + testTag + "FEDCBA9876543210" + "0098765" + "00)"
+	tests := []struct {
+		command, response, want string
+	}{
+		{"(?A)", "(" + testTag + ")", "(************6789)"},
+		{"(?LF0001)", checked("00A" + testTag + "0000000000000000"), "(00A************6789"},
+		{checked("&A"), identified, "(" + strings.Repeat("0", 48) + "************6789" + "0098765" + "00)"},
+		{checked("&@"), dual, "(" + strings.Repeat("0", 44) + "************6789" + "************3210" + "0098765" + "00)"},
+		// Every long hex run of ?V frames is masked, tag or not
+		{"(?V)", "(01" + testTag + "0000123400)", "(************************3400)"},
+		{checked("&T01L"), checked("L0100012345678"), checked("L0100012345678")},
+	}
+	for _, tt := range tests {
+		if got := RedactResponse(tt.command, tt.response); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("RedactResponse(%q, %q) = %q, want %q...", tt.command, tt.response, got, tt.want)
+		}
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	command := checked("?F00A" + testTag + "0000000100000000")
+	perr := newProtocolError(ErrNAK, command, noData, "command rejected by device")
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{perr, perr.format(RedactCommand(command), noData)},
+		{fmt.Errorf("delete %s: %w", testTag, perr), "delete ************6789: protocol error (nak) for \"(?F00A************6789"},
+		{invalidf("identifier must be 16 hex chars, got %q", testTag+"AB"), `invalid parameter: identifier must be 16 hex chars, got "**************89AB"`},
+		{io.EOF, "EOF"},
+	}
+	for _, tt := range tests {
+		got := RedactError(tt.err)
+		if !strings.HasPrefix(got, tt.want) || strings.Contains(got, testTag) {
+			t.Errorf("RedactError(%v) = %q, want %q...", tt.err, got, tt.want)
+		}
+	}
+	// The error itself is left untouched
+	if !errors.Is(fmt.Errorf("wrapped: %w", perr), perr) || !strings.Contains(perr.Error(), testTag) {
+		t.Error("redaction modified the error")
+	}
+}
+
+func TestWireTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	d := &fakeDevice{answer: func(command string) string {
+		if strings.HasPrefix(command, "(&M99") {
+			return command
+		}
+		return noData
+	}}
+	c := newTestClient(t, d, WithLogger(logger), WithWireTrace())
+
+	if _, err := c.BlacklistIdentifier(testTag); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeleteIdentifier("00", testTag, 1); err == nil {
+		t.Fatal("delete accepted a (0) answer")
+	}
+	if strings.Contains(buf.String(), testTag) {
+		t.Fatalf("identifier tag logged:\n%s", buf.String())
+	}
+
+	var records []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["msg"] == "tx" || rec["msg"] == "rx" {
+			records = append(records, rec)
+		}
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d frames traced, want 4:\n%s", len(records), buf.String())
+	}
+	tx, rx := records[0], records[1]
+	if tx["msg"] != "tx" || tx["meaning"] != "blacklist identifier" || !strings.HasPrefix(tx["frame"].(string), "(&M99b************6789") {
+		t.Errorf("tx record %v", tx)
+	}
+	if rx["msg"] != "rx" || rx["seq"] != tx["seq"] || rx["latency"] == nil || rx["frame"] != tx["frame"] {
+		t.Errorf("rx record %v", rx)
+	}
+	if rx := records[3]; rx["meaning"] != "rejected" || rx["frame"] != noData {
+		t.Errorf("rejected delete %v", rx)
+	}
+	if !strings.Contains(buf.String(), `"msg":"command failed","addr":"device:2001","header":"?F","error":"protocol error (nak) for \"(?F00A************6789`) {
+		t.Errorf("failed delete not logged masked:\n%s", buf.String())
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	commands := map[string]string{
+		"(&S)":                              "read status",
+		checked("&T99"):                     "read memory pointers",
+		checked("&T02$"):                    "read value total of nozzle 02",
+		checked("&M03B"):                    "set mode B on nozzle 03",
+		checked("&M99l" + testTag):          "unblacklist identifier",
+		checked("?F01P" + testTag + "1A00"): "identified preset on nozzle 01",
+		"(&Z)":                              "unknown command",
+	}
+	for command, want := range commands {
+		if got := describeCommand(command); got != want {
+			t.Errorf("describeCommand(%q) = %q, want %q", command, got, want)
+		}
+	}
+
+	responses := []struct{ command, response, want string }{
+		{"(&S)", "(S" + "LE" + ")", "01 available, 02 waiting"},
+		{checked("&T01L"), checked("L0100012345678"), "nozzle 01 total 12345678"},
+		{checked("&U01006199"), noData, "rejected"},
+		{"(&A)", noData, "no data"},
+	}
+	for _, tt := range responses {
+		if got := describeResponse(tt.command, tt.response); got != tt.want {
+			t.Errorf("describeResponse(%q, %q) = %q, want %q", tt.command, tt.response, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package fiscal checks PAF supply records for fiscal audits: every supply
+// must start at the totalizer (encerrante) the previous supply of the same
+// nozzle ended at, and the totalizer difference must match the volume.
+package fiscal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/journal"
+)
+
+// IssueKind classifies an Issue.
+type IssueKind string
+
+const (
+	// IssueGap means the totalizer moved between two supplies: fuel was
+	// dispensed without being recorded.
+	IssueGap IssueKind = "gap"
+	// IssueOverlap means a supply starts before the previous one ended.
+	IssueOverlap IssueKind = "overlap"
+	// IssueVolumeMismatch means the totalizer difference of a supply does not
+	// match its volume.
+	IssueVolumeMismatch IssueKind = "volume_mismatch"
+)
+
+// Issue is a totalizer inconsistency found in a record.
+type Issue struct {
+	Kind     IssueKind `json:"kind"`
+	Nozzle   string    `json:"nozzle"`
+	Record   int       `json:"record"`
+	Expected int64     `json:"expected"`
+	Got      int64     `json:"got"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("nozzle %s record %d: %s (expected %d, got %d)", i.Nozzle, i.Record, i.Kind, i.Expected, i.Got)
+}
+
+// Checker validates records one at a time, remembering the last record of
+// each nozzle. The zero value is ready to use; it is not safe for concurrent
+// use.
+type Checker struct {
+	last map[string]companytec.PAFSupplyRecord
+}
+
+// Check validates rec against the previous record of its nozzle and returns
+// the issues found. Reading the same record again (same nozzle, record number
+// and totalizers) is not an issue; isNew is false in that case.
+func (c *Checker) Check(rec companytec.PAFSupplyRecord) (issues []Issue, isNew bool) {
+	if c.last == nil {
+		c.last = make(map[string]companytec.PAFSupplyRecord)
+	}
+	prev, seen := c.last[rec.Nozzle]
+	if seen && prev.Record == rec.Record && prev.StartTotal == rec.StartTotal && prev.EndTotal == rec.EndTotal {
+		return nil, false
+	}
+
+	if got := rec.Dispensed(); got != int64(rec.Volume) {
+		issues = append(issues, Issue{
+			Kind: IssueVolumeMismatch, Nozzle: rec.Nozzle, Record: rec.Record,
+			Expected: int64(rec.Volume), Got: got,
+		})
+	}
+	if seen && rec.StartTotal != prev.EndTotal {
+		kind := IssueGap
+		m := rec.TotalizerModulus()
+		// Distance forward from the previous end; more than half the range
+		// means the totalizer went back
+		if ((rec.StartTotal-prev.EndTotal)%m+m)%m > m/2 {
+			kind = IssueOverlap
+		}
+		issues = append(issues, Issue{
+			Kind: kind, Nozzle: rec.Nozzle, Record: rec.Record,
+			Expected: prev.EndTotal, Got: rec.StartTotal,
+		})
+	}
+	c.last[rec.Nozzle] = rec
+	return issues, true
+}
+
+// Validate checks a sequence of records, in supply order.
+func Validate(records []companytec.PAFSupplyRecord) []Issue {
+	issues := []Issue{}
+	var c Checker
+	for _, rec := range records {
+		found, _ := c.Check(rec)
+		issues = append(issues, found...)
+	}
+	return issues
+}
+
+// FromSupply converts a standard supply record for the checks. Standard
+// records only carry the final totalizer, so the start totalizer is derived
+// from the volume: the record never shows a volume mismatch, but gaps and
+// overlaps between supplies are found as with PAF records.
+func FromSupply(rec companytec.SupplyRecord) companytec.PAFSupplyRecord {
+	paf := companytec.PAFSupplyRecord{
+		Format:     1,
+		TotalToPay: rec.TotalToPay,
+		Volume:     rec.Volume,
+		Price:      rec.Price,
+		CommaCode:  rec.CommaCode,
+		SupplyTime: rec.SupplyTime,
+		Nozzle:     rec.Nozzle,
+		End:        companytec.ClockReading{Month: rec.Month, Day: rec.Day, Hour: rec.Hour, Minute: rec.Minute},
+		Record:     rec.Record,
+		EndTotal:   rec.FinalTotal,
+		Status:     rec.Status,
+		Tag:        rec.Tag,
+	}
+	m := paf.TotalizerModulus()
+	paf.StartTotal = ((rec.FinalTotal-int64(rec.Volume))%m + m) % m
+	return paf
+}
+
+// Entry is a record kept by an Auditor with the issues found when it was
+// added.
+type Entry struct {
+	companytec.PAFSupplyRecord
+	Issues []Issue `json:"issues,omitempty"`
+}
+
+// Auditor keeps the last records read from the device for audits. Its
+// methods are safe for concurrent use.
+type Auditor struct {
+	size int
+
+	mu      sync.Mutex
+	checker Checker
+	entries []Entry
+}
+
+// DefaultAuditSize is the number of records kept by NewAuditor(0).
+const DefaultAuditSize = 1000
+
+// NewAuditor creates an Auditor keeping at most size records, DefaultAuditSize
+// when size is not positive.
+func NewAuditor(size int) *Auditor {
+	if size <= 0 {
+		size = DefaultAuditSize
+	}
+	return &Auditor{size: size}
+}
+
+// Add checks rec and keeps it. It returns the entry and whether the record was
+// new; re-reading the current record does not add it twice.
+func (a *Auditor) Add(rec companytec.PAFSupplyRecord) (Entry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	issues, isNew := a.checker.Check(rec)
+	if !isNew {
+		for i := len(a.entries) - 1; i >= 0; i-- {
+			if e := a.entries[i]; e.Nozzle == rec.Nozzle && e.Record == rec.Record {
+				return e, false
+			}
+		}
+		return Entry{PAFSupplyRecord: rec}, false
+	}
+	entry := Entry{PAFSupplyRecord: rec, Issues: issues}
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > a.size {
+		a.entries = a.entries[len(a.entries)-a.size:]
+	}
+	return entry, true
+}
+
+// Entries returns the kept records, oldest first.
+func (a *Auditor) Entries() []Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Entry(nil), a.entries...)
+}
+
+// AddSupply checks and keeps a standard supply record, see FromSupply.
+func (a *Auditor) AddSupply(rec companytec.SupplyRecord) (Entry, bool) {
+	return a.Add(FromSupply(rec))
+}
+
+// LoadJournal adds the supplies recorded in j, in journal order, so an
+// auditor fed by a collector resumes where the previous run stopped. Load
+// before the collector starts storing into the journal.
+func (a *Auditor) LoadJournal(j *journal.Journal) error {
+	q := journal.Query{Kind: journal.KindSupply, Limit: 1000}
+	for {
+		entries, next, err := j.Query(q)
+		if err != nil {
+			return fmt.Errorf("audit journal: %w", err)
+		}
+		for _, e := range entries {
+			if e.Supply != nil {
+				a.AddSupply(*e.Supply)
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		q.After = next
+	}
+}
+
+// Sink is a companytec.SupplySink that audits every supply stored through it,
+// so a SupplyCollector feeds the Auditor with each supply it captures.
+type Sink struct {
+	companytec.SupplySink
+	auditor *Auditor
+}
+
+// NewSink returns a sink storing into sink and auditing into a.
+func NewSink(sink companytec.SupplySink, a *Auditor) *Sink {
+	return &Sink{SupplySink: sink, auditor: a}
+}
+
+// Store stores supply and audits it when the sink did not hold it yet.
+func (s *Sink) Store(ctx context.Context, supply companytec.CollectedSupply) (bool, error) {
+	stored, err := s.SupplySink.Store(ctx, supply)
+	if stored {
+		s.auditor.AddSupply(supply.SupplyRecord)
+	}
+	return stored, err
+}
@@ -0,0 +1,158 @@
+package fiscal
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/journal"
+)
+
+func paf(nozzle string, record int, start, end int64, volume int) companytec.PAFSupplyRecord {
+	return companytec.PAFSupplyRecord{
+		Format: 1, Nozzle: nozzle, Record: record,
+		StartTotal: start, EndTotal: end, Volume: volume,
+	}
+}
+
+func kinds(issues []Issue) []IssueKind {
+	var k []IssueKind
+	for _, i := range issues {
+		k = append(k, i.Kind)
+	}
+	return k
+}
+
+func TestCheck(t *testing.T) {
+	var c Checker
+	tests := []struct {
+		name string
+		rec  companytec.PAFSupplyRecord
+		want []IssueKind
+	}{
+		{"first", paf("01", 1, 1000, 1500, 500), nil},
+		{"other nozzle", paf("02", 1, 0, 100, 100), nil},
+		{"continuous", paf("01", 2, 1500, 1700, 200), nil},
+		{"gap", paf("01", 3, 1800, 1900, 100), []IssueKind{IssueGap}},
+		{"overlap", paf("01", 4, 1850, 1950, 100), []IssueKind{IssueOverlap}},
+		{"mismatch", paf("01", 5, 1950, 2000, 40), []IssueKind{IssueVolumeMismatch}},
+		{"wrap", paf("01", 6, 2000, 2000, 0), nil},
+		{"wrapped totalizer", paf("02", 2, 100, 10_000_000_000-50, 9_999_999_850), nil},
+		{"across zero", paf("02", 3, 10_000_000_000-50, 30, 80), nil},
+	}
+	for _, tt := range tests {
+		issues, isNew := c.Check(tt.rec)
+		if !isNew {
+			t.Errorf("%s: not new", tt.name)
+		}
+		if got := kinds(issues); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: issues %v, want %v", tt.name, issues, tt.want)
+		}
+	}
+
+	// Re-reading the last record of a nozzle is not a new supply
+	if issues, isNew := c.Check(paf("02", 3, 10_000_000_000-50, 30, 80)); isNew || issues != nil {
+		t.Errorf("re-read: %v, %v", issues, isNew)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	issues := Validate([]companytec.PAFSupplyRecord{
+		paf("01", 1, 0, 100, 100),
+		paf("01", 2, 150, 200, 50),
+	})
+	if len(issues) != 1 || issues[0] != (Issue{Kind: IssueGap, Nozzle: "01", Record: 2, Expected: 100, Got: 150}) {
+		t.Errorf("issues %+v", issues)
+	}
+	if issues := Validate(nil); issues == nil || len(issues) != 0 {
+		t.Errorf("no records: %#v", issues)
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	a := NewAuditor(2)
+	a.Add(paf("01", 1, 0, 100, 100))
+	a.Add(paf("01", 2, 100, 200, 100))
+	entry, isNew := a.Add(paf("01", 3, 250, 300, 50))
+	if !isNew || len(entry.Issues) != 1 {
+		t.Errorf("gap entry %+v, %v", entry, isNew)
+	}
+	// A re-read returns the kept entry with its issues
+	again, isNew := a.Add(paf("01", 3, 250, 300, 50))
+	if isNew || len(again.Issues) != 1 {
+		t.Errorf("re-read entry %+v, %v", again, isNew)
+	}
+
+	entries := a.Entries()
+	if len(entries) != 2 || entries[0].Record != 2 || entries[1].Record != 3 {
+		t.Errorf("entries %+v, want records 2 and 3", entries)
+	}
+	if NewAuditor(0).size != DefaultAuditSize {
+		t.Error("NewAuditor(0) does not use the default size")
+	}
+}
+
+func TestFromSupply(t *testing.T) {
+	rec := companytec.SupplyRecord{Nozzle: "03", Record: 7, Volume: 250, FinalTotal: 100, Day: 2, Hour: 14, Minute: 5}
+	got := FromSupply(rec)
+	if got.StartTotal != 10_000_000_000-150 || got.EndTotal != 100 || got.Dispensed() != 250 {
+		t.Errorf("totalizers %d-%d", got.StartTotal, got.EndTotal)
+	}
+	if got.Nozzle != "03" || got.Record != 7 || got.End.Day != 2 || got.End.Minute != 5 {
+		t.Errorf("record %+v", got)
+	}
+
+	a := NewAuditor(0)
+	a.AddSupply(companytec.SupplyRecord{Nozzle: "03", Record: 1, Volume: 100, FinalTotal: 1000})
+	entry, _ := a.AddSupply(companytec.SupplyRecord{Nozzle: "03", Record: 2, Volume: 100, FinalTotal: 1200})
+	if kinds(entry.Issues)[0] != IssueGap {
+		t.Errorf("supply after an unrecorded 100: %+v", entry)
+	}
+}
+
+func collected(rec companytec.SupplyRecord) companytec.CollectedSupply {
+	return companytec.CollectedSupply{Key: companytec.SupplyKey(rec), CollectedAt: time.Now(), SupplyRecord: rec}
+}
+
+func TestSinkAndJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+	j, err := journal.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuditor(0)
+	sink := NewSink(j, a)
+	ctx := context.Background()
+	supplies := []companytec.SupplyRecord{
+		{Nozzle: "01", Record: 1, Volume: 100, FinalTotal: 100},
+		{Nozzle: "01", Record: 2, Volume: 100, FinalTotal: 250},
+	}
+	for _, rec := range supplies {
+		if stored, err := sink.Store(ctx, collected(rec)); err != nil || !stored {
+			t.Fatalf("store: %v, %v", stored, err)
+		}
+	}
+	// A supply stored again is not audited twice
+	if stored, err := sink.Store(ctx, collected(supplies[0])); err != nil || stored {
+		t.Fatalf("store again: %v, %v", stored, err)
+	}
+	entries := a.Entries()
+	if len(entries) != 2 || len(entries[1].Issues) != 1 {
+		t.Fatalf("audited %+v", entries)
+	}
+	if cp, err := sink.Checkpoint(ctx); err != nil || cp.Count != 2 {
+		t.Errorf("checkpoint %+v, %v", cp, err)
+	}
+
+	resumed := NewAuditor(0)
+	if err := resumed.LoadJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	if got := resumed.Entries(); len(got) != 2 || len(got[1].Issues) != 1 {
+		t.Errorf("resumed %+v", got)
+	}
+	j.Close()
+}
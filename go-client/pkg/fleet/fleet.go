@@ -0,0 +1,240 @@
+// Package fleet keeps a registry of devices, each with its own client,
+// connection lifecycle and health, for gateways serving several
+// concentrators.
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// DeviceConfig describes a device of the registry.
+type DeviceConfig struct {
+	ID     string            `json:"id"`
+	Addr   string            `json:"addr"` // host:port
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Health is the connection health of a device.
+type Health struct {
+	State       string     `json:"state"`
+	Since       time.Time  `json:"since"` // Time of the last state change
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	// Failures counts the state changes caused by an error.
+	Failures int `json:"failures"`
+}
+
+// Healthy reports whether the device is connected.
+func (h Health) Healthy() bool {
+	return h.State == companytec.StateConnected.String()
+}
+
+// Device is a registered device. Its client is owned by the registry.
+type Device struct {
+	ID     string
+	Addr   string
+	Labels map[string]string
+	Client *companytec.Client
+
+	mu     sync.Mutex
+	health Health
+	cancel func()
+}
+
+// Health returns the connection health of the device.
+func (d *Device) Health() Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.health
+}
+
+// Match reports whether the device has every label of selector.
+func (d *Device) Match(selector map[string]string) bool {
+	for k, v := range selector {
+		if d.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Device) onStateChange(change companytec.StateChange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.health.State = change.To.String()
+	d.health.Since = change.At
+	if change.Err != nil {
+		at := change.At
+		d.health.LastError = change.Err.Error()
+		d.health.LastErrorAt = &at
+		d.health.Failures++
+	}
+}
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Registry holds the devices. Its methods are safe for concurrent use.
+type Registry struct {
+	opts []companytec.Option
+
+	mu      sync.RWMutex
+	devices map[string]*Device
+
+	listenersMu  sync.Mutex
+	listeners    map[int]func(*Device)
+	nextListener int
+}
+
+// NewRegistry creates an empty registry. opts are applied to the client of
+// every device.
+func NewRegistry(opts ...companytec.Option) *Registry {
+	return &Registry{opts: opts, devices: make(map[string]*Device)}
+}
+
+// Add registers a device and creates its client. It does not connect.
+func (r *Registry) Add(cfg DeviceConfig) (*Device, error) {
+	if !validID.MatchString(cfg.ID) {
+		return nil, fmt.Errorf("%w: device ID must be letters, digits, '.', '_' or '-', got %q", companytec.ErrInvalidParameter, cfg.ID)
+	}
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("%w: device %s has no address", companytec.ErrInvalidParameter, cfg.ID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[cfg.ID]; ok {
+		return nil, fmt.Errorf("device %s already registered", cfg.ID)
+	}
+	client := companytec.New(cfg.Addr, r.opts...)
+	d := &Device{
+		ID:     cfg.ID,
+		Addr:   cfg.Addr,
+		Labels: cfg.Labels,
+		Client: client,
+		health: Health{State: client.State().String(), Since: time.Now()},
+	}
+	d.cancel = client.OnStateChange(d.onStateChange)
+	r.devices[cfg.ID] = d
+	return d, nil
+}
+
+// Remove unregisters a device, closes its connection and calls the OnRemove
+// listeners.
+func (r *Registry) Remove(id string) bool {
+	r.mu.Lock()
+	d, ok := r.devices[id]
+	delete(r.devices, id)
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	d.cancel()
+	d.Client.Disconnect()
+
+	r.listenersMu.Lock()
+	listeners := make([]func(*Device), 0, len(r.listeners))
+	for _, fn := range r.listeners {
+		listeners = append(listeners, fn)
+	}
+	r.listenersMu.Unlock()
+	for _, fn := range listeners {
+		fn(d)
+	}
+	return true
+}
+
+// OnRemove registers fn to be called with every device removed from the
+// registry, so the services built around its client can be released. It
+// returns a function that unregisters fn.
+func (r *Registry) OnRemove(fn func(*Device)) (cancel func()) {
+	r.listenersMu.Lock()
+	defer r.listenersMu.Unlock()
+	if r.listeners == nil {
+		r.listeners = make(map[int]func(*Device))
+	}
+	id := r.nextListener
+	r.nextListener++
+	r.listeners[id] = fn
+
+	return func() {
+		r.listenersMu.Lock()
+		defer r.listenersMu.Unlock()
+		delete(r.listeners, id)
+	}
+}
+
+// Get returns a device by ID.
+func (r *Registry) Get(id string) (*Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.devices[id]
+	return d, ok
+}
+
+// List returns the devices having every label of selector, sorted by ID.
+func (r *Registry) List(selector map[string]string) []*Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		if d.Match(selector) {
+			list = append(list, d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// ConnectAll dials every device concurrently and returns the errors by
+// device ID.
+func (r *Registry) ConnectAll(ctx context.Context) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	for _, d := range r.List(nil) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Client.ConnectCtx(ctx); err != nil {
+				mu.Lock()
+				errs[d.ID] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// Close closes the connection of every device.
+func (r *Registry) Close() {
+	for _, d := range r.List(nil) {
+		d.Client.Disconnect()
+	}
+}
+
+// ReadFile reads device configurations from a JSON file holding a list of
+// DeviceConfig.
+func ReadFile(path string) ([]DeviceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var devices []DeviceConfig
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("devices file %s: %w", path, err)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("devices file %s lists no device", path)
+	}
+	return devices, nil
+}
@@ -0,0 +1,152 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+// newRegistry returns a registry whose clients reach a simulator at any
+// address but "down:2001", which refuses connections.
+func newRegistry(t *testing.T) *Registry {
+	t.Helper()
+	sim := simulator.New(simulator.Config{})
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, addr string) (net.Conn, error) {
+		if addr == "down:2001" {
+			return nil, errors.New("connection refused")
+		}
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	r := NewRegistry(companytec.WithDialer(dialer), companytec.WithTimeout(time.Second),
+		companytec.WithReconnectPolicy(companytec.ReconnectPolicy{}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestAdd(t *testing.T) {
+	r := newRegistry(t)
+	if _, err := r.Add(DeviceConfig{ID: "site-a", Addr: "a:2001", Labels: map[string]string{"region": "south"}}); err != nil {
+		t.Fatal(err)
+	}
+	for name, cfg := range map[string]DeviceConfig{
+		"bad id":    {ID: "-a", Addr: "a:2001"},
+		"slash":     {ID: "a/b", Addr: "a:2001"},
+		"no addr":   {ID: "site-b"},
+		"duplicate": {ID: "site-a", Addr: "b:2001"},
+	} {
+		if _, err := r.Add(cfg); err == nil {
+			t.Errorf("%s: added", name)
+		}
+	}
+	if _, err := r.Add(DeviceConfig{ID: "a/b", Addr: "a:2001"}); !errors.Is(err, companytec.ErrInvalidParameter) {
+		t.Errorf("invalid ID: %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	r := newRegistry(t)
+	r.Add(DeviceConfig{ID: "site-b", Addr: "b:2001", Labels: map[string]string{"region": "north"}})
+	r.Add(DeviceConfig{ID: "site-a", Addr: "a:2001", Labels: map[string]string{"region": "south", "tier": "1"}})
+	r.Add(DeviceConfig{ID: "site-c", Addr: "c:2001", Labels: map[string]string{"region": "south"}})
+
+	ids := func(devices []*Device) []string {
+		var s []string
+		for _, d := range devices {
+			s = append(s, d.ID)
+		}
+		return s
+	}
+	if got := ids(r.List(nil)); len(got) != 3 || got[0] != "site-a" || got[2] != "site-c" {
+		t.Errorf("all devices %v", got)
+	}
+	if got := ids(r.List(map[string]string{"region": "south"})); len(got) != 2 || got[1] != "site-c" {
+		t.Errorf("south %v", got)
+	}
+	if got := ids(r.List(map[string]string{"region": "south", "tier": "1"})); len(got) != 1 {
+		t.Errorf("south tier 1 %v", got)
+	}
+	if d, ok := r.Get("site-b"); !ok || d.Addr != "b:2001" {
+		t.Errorf("get site-b: %+v", d)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	r := newRegistry(t)
+	up, _ := r.Add(DeviceConfig{ID: "up", Addr: "up:2001"})
+	down, _ := r.Add(DeviceConfig{ID: "down", Addr: "down:2001"})
+
+	errs := r.ConnectAll(context.Background())
+	if len(errs) != 1 || errs["down"] == nil {
+		t.Errorf("connect errors %v", errs)
+	}
+	if h := up.Health(); !h.Healthy() || h.Failures != 0 {
+		t.Errorf("connected device health %+v", h)
+	}
+	if h := down.Health(); h.Healthy() || h.LastError == "" || h.LastErrorAt == nil || h.Failures == 0 {
+		t.Errorf("unreachable device health %+v", h)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	r := newRegistry(t)
+	d, _ := r.Add(DeviceConfig{ID: "site-a", Addr: "a:2001"})
+	if err := d.Client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	var removed []*Device
+	cancel := r.OnRemove(func(d *Device) { removed = append(removed, d) })
+
+	if !r.Remove("site-a") || r.Remove("site-a") {
+		t.Error("Remove did not report the device once")
+	}
+	if _, ok := r.Get("site-a"); ok {
+		t.Error("removed device still registered")
+	}
+	if d.Client.IsConnected() {
+		t.Error("removed device still connected")
+	}
+	if len(removed) != 1 || removed[0] != d {
+		t.Errorf("OnRemove called with %v", removed)
+	}
+
+	cancel()
+	r.Add(DeviceConfig{ID: "site-a", Addr: "a:2001"})
+	r.Remove("site-a")
+	if len(removed) != 1 {
+		t.Error("listener called after cancel")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	devices, err := ReadFile(write("devices.json", `[{"id":"site-a","addr":"10.0.1.10:2001","labels":{"region":"south"}}]`))
+	if err != nil || len(devices) != 1 || devices[0].Labels["region"] != "south" {
+		t.Errorf("devices %+v, %v", devices, err)
+	}
+	if _, err := ReadFile(write("empty.json", `[]`)); err == nil {
+		t.Error("empty list accepted")
+	}
+	if _, err := ReadFile(write("bad.json", `{`)); err == nil {
+		t.Error("bad JSON accepted")
+	}
+	if _, err := ReadFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,430 @@
+// Package journal records captured supplies and the commands that change the
+// forecourt (price changes, presets and mode changes) in an embedded,
+// append-only bbolt file.
+//
+// A Journal is a companytec.SupplySink, so a SupplyCollector can store
+// supplies in it directly, and Observe records the changes made through a
+// client, whoever sends them.
+package journal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"companytec-client/pkg/companytec"
+)
+
+// Kind is the type of a journal entry.
+type Kind string
+
+const (
+	KindSupply      Kind = "supply"
+	KindPriceChange Kind = "price_change"
+	KindPreset      Kind = "preset"
+	KindModeChange  Kind = "mode_change"
+)
+
+// Entry is a journal record. Supply is set for supplies; Value holds the new
+// price or the preset value (prefixed by the preset type, $ or V, for
+// identified presets) and Mode the new operating mode.
+type Entry struct {
+	Seq        uint64                   `json:"seq"`
+	Kind       Kind                     `json:"kind"`
+	Time       time.Time                `json:"time"`
+	Nozzle     string                   `json:"nozzle,omitempty"`
+	Identifier string                   `json:"identifier,omitempty"`
+	Key        string                   `json:"key,omitempty"`
+	Supply     *companytec.SupplyRecord `json:"supply,omitempty"`
+	Level      string                   `json:"level,omitempty"`
+	Value      string                   `json:"value,omitempty"`
+	Mode       string                   `json:"mode,omitempty"`
+}
+
+// identifies reports whether id is the entry identifier or one of the tags of
+// its supply.
+func (e *Entry) identifies(id string) bool {
+	if strings.EqualFold(e.Identifier, id) {
+		return true
+	}
+	if e.Supply == nil {
+		return false
+	}
+	return strings.EqualFold(e.Supply.Tag, id) ||
+		strings.EqualFold(e.Supply.AttendantTag, id) ||
+		strings.EqualFold(e.Supply.CustomerTag, id)
+}
+
+// Query selects entries. Zero fields do not filter.
+type Query struct {
+	Kind       Kind
+	Nozzle     string
+	Identifier string
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
+	// After is the pagination cursor: only entries with a greater Seq are
+	// returned.
+	After uint64
+	// Limit caps the number of entries returned. Defaults to 100.
+	Limit int
+}
+
+const defaultLimit = 100
+
+var (
+	bucketEntries = []byte("entries")
+	// bucketTime indexes entries by time: unix nanoseconds and seq, both big
+	// endian, so a date range starts with a cursor seek.
+	bucketTime = []byte("time")
+	// bucketSupplies maps supply keys to their seq, to store a supply once.
+	bucketSupplies = []byte("supplies")
+	bucketMeta     = []byte("meta")
+	keyCheckpoint  = []byte("checkpoint")
+)
+
+// Journal is an append-only journal file. Its methods are safe for concurrent
+// use.
+type Journal struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// Open opens or creates the journal file at path. The file is locked while
+// the journal is open.
+func Open(path string) (*Journal, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("journal %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketEntries, bucketTime, bucketSupplies, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("journal %s: %w", path, err)
+	}
+	return &Journal{db: db, now: time.Now}, nil
+}
+
+// Close closes the file.
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+// Append records e and returns it with its sequence number and, when it had
+// none, the current time. Date range queries assume entries are appended in
+// time order.
+func (j *Journal) Append(e Entry) (Entry, error) {
+	if e.Time.IsZero() {
+		e.Time = j.now()
+	}
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		return appendTx(tx, &e)
+	})
+	return e, err
+}
+
+// appendBatch records entries in a single transaction, timestamped now.
+func (j *Journal) appendBatch(entries []Entry) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		now := j.now()
+		for i := range entries {
+			entries[i].Time = now
+			if err := appendTx(tx, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func appendTx(tx *bolt.Tx, e *Entry) error {
+	entries := tx.Bucket(bucketEntries)
+	seq, err := entries.NextSequence()
+	if err != nil {
+		return err
+	}
+	e.Seq = seq
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := entries.Put(u64(seq), data); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketTime).Put(timeKey(e.Time, seq), nil)
+}
+
+// Store records a collected supply unless its key is already in the journal.
+// It implements companytec.SupplySink.
+func (j *Journal) Store(ctx context.Context, s companytec.CollectedSupply) (bool, error) {
+	stored := false
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		supplies := tx.Bucket(bucketSupplies)
+		if supplies.Get([]byte(s.Key)) != nil {
+			return nil
+		}
+		rec := s.SupplyRecord
+		e := Entry{
+			Kind:       KindSupply,
+			Time:       s.CollectedAt,
+			Nozzle:     rec.Nozzle,
+			Identifier: rec.Tag,
+			Key:        s.Key,
+			Supply:     &rec,
+		}
+		if e.Time.IsZero() {
+			e.Time = j.now()
+		}
+		if err := appendTx(tx, &e); err != nil {
+			return err
+		}
+		if err := supplies.Put([]byte(s.Key), u64(e.Seq)); err != nil {
+			return err
+		}
+
+		meta := tx.Bucket(bucketMeta)
+		var cp companytec.Checkpoint
+		if data := meta.Get(keyCheckpoint); data != nil {
+			if err := json.Unmarshal(data, &cp); err != nil {
+				return err
+			}
+		}
+		cp = companytec.Checkpoint{Key: s.Key, Count: cp.Count + 1, At: e.Time}
+		data, err := json.Marshal(cp)
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(keyCheckpoint, data); err != nil {
+			return err
+		}
+		stored = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return stored, nil
+}
+
+// Checkpoint returns the last supply stored. It implements
+// companytec.SupplySink.
+func (j *Journal) Checkpoint(ctx context.Context) (companytec.Checkpoint, error) {
+	var cp companytec.Checkpoint
+	err := j.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketMeta).Get(keyCheckpoint)
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &cp)
+	})
+	return cp, err
+}
+
+// Query returns the entries matching q in sequence order, and the cursor of
+// the next page, 0 when there is none.
+func (j *Journal) Query(q Query) ([]Entry, uint64, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	if !q.To.IsZero() && !q.From.IsZero() && !q.From.Before(q.To) {
+		return nil, 0, errors.New("journal: from must be before to")
+	}
+
+	entries := []Entry{}
+	var next uint64
+	err := j.db.View(func(tx *bolt.Tx) error {
+		start := q.After + 1
+		if !q.From.IsZero() {
+			k, _ := tx.Bucket(bucketTime).Cursor().Seek(timeKey(q.From, 0))
+			if k == nil {
+				return nil
+			}
+			start = max(start, binary.BigEndian.Uint64(k[8:]))
+		}
+
+		c := tx.Bucket(bucketEntries).Cursor()
+		for k, v := c.Seek(u64(start)); k != nil; k, v = c.Next() {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("journal entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if !q.To.IsZero() && !e.Time.Before(q.To) {
+				// Entries are appended in time order
+				break
+			}
+			if !q.matches(&e) {
+				continue
+			}
+			if len(entries) == q.Limit {
+				next = entries[len(entries)-1].Seq
+				break
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, next, err
+}
+
+func (q *Query) matches(e *Entry) bool {
+	if q.Kind != "" && e.Kind != q.Kind {
+		return false
+	}
+	if q.Nozzle != "" && e.Nozzle != q.Nozzle {
+		return false
+	}
+	if !q.From.IsZero() && e.Time.Before(q.From) {
+		return false
+	}
+	return q.Identifier == "" || e.identifies(q.Identifier)
+}
+
+// Observe records the price changes (&U), presets (&P and identified ?F
+// presets) and mode changes (&M) the device accepted from c, whatever sent
+// them: the API, the interactive menu or a fleet operation. Blacklist
+// commands (&M99) are not recorded. Append failures are logged on logger,
+// which may be nil.
+//
+// The client must not wait on the disk, so entries are queued and written in
+// batches by a goroutine. It returns a function that stops observing and
+// returns once the entries observed so far are written.
+func (j *Journal) Observe(c *companytec.Client, logger *slog.Logger) (cancel func()) {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	o := &observer{
+		j:      j,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go o.run()
+	unregister := c.OnCommand(func(ctx context.Context, res companytec.CommandResult) {
+		if res.Err != nil {
+			return
+		}
+		if e, ok := commandEntry(res.Header, res.Command); ok {
+			o.queue(e)
+		}
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unregister()
+			close(o.stop)
+			<-o.done
+		})
+	}
+}
+
+// observer writes the entries Observe queues.
+type observer struct {
+	j      *Journal
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending []Entry
+
+	wake chan struct{} // Signals pending entries, never blocks
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (o *observer) queue(e Entry) {
+	o.mu.Lock()
+	o.pending = append(o.pending, e)
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *observer) run() {
+	defer close(o.done)
+	for {
+		select {
+		case <-o.wake:
+			o.flush()
+		case <-o.stop:
+			o.flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending entries, timestamped when written so that entries
+// stay in time order.
+func (o *observer) flush() {
+	o.mu.Lock()
+	batch := o.pending
+	o.pending = nil
+	o.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if err := o.j.appendBatch(batch); err != nil {
+		for _, e := range batch {
+			o.logger.Error("journal append failed", "kind", e.Kind, "nozzle", e.Nozzle, "error", err)
+		}
+	}
+}
+
+// commandEntry decodes the entry of a write command frame, reporting false
+// for the commands that are not journaled.
+func commandEntry(header, command string) (Entry, bool) {
+	params := strings.TrimSuffix(strings.TrimPrefix(command, "("+header), ")")
+	if companytec.HasChecksum(command) {
+		params = params[:len(params)-2]
+	}
+	switch {
+	case header == "&U" && (len(params) == 8 || len(params) == 10):
+		// Nozzle, level, 0 and the price, in 4 or, extended, 6 digits
+		return Entry{Kind: KindPriceChange, Nozzle: params[:2], Level: params[2:3], Value: number(params[4:])}, true
+	case header == "&P" && len(params) == 8:
+		return Entry{Kind: KindPreset, Nozzle: params[:2], Value: number(params[2:])}, true
+	case header == "&M" && len(params) >= 3 && !strings.HasPrefix(params, "99"):
+		return Entry{Kind: KindModeChange, Nozzle: params[:2], Mode: params[2:]}, true
+	case header == "?F" && len(params) == 35 && params[2] == 'P':
+		// Nozzle, P, identifier, type, authorize, value, timeout and preset
+		// type
+		return Entry{
+			Kind: KindPreset, Nozzle: params[:2], Identifier: params[3:19],
+			Value: params[29:30] + number(params[21:27]),
+		}, true
+	}
+	return Entry{}, false
+}
+
+// number drops the zero padding of a numeric field.
+func number(field string) string {
+	n, err := strconv.Atoi(field)
+	if err != nil {
+		return field
+	}
+	return strconv.Itoa(n)
+}
+
+func u64(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func timeKey(t time.Time, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(u64(uint64(t.UnixNano())), seq)
+}
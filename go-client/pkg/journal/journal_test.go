@@ -0,0 +1,218 @@
+package journal
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func open(t *testing.T) *Journal {
+	t.Helper()
+	j, err := Open(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func seqs(entries []Entry) []uint64 {
+	var s []uint64
+	for _, e := range entries {
+		s = append(s, e.Seq)
+	}
+	return s
+}
+
+func TestQuery(t *testing.T) {
+	j := open(t)
+	start := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	for i, e := range []Entry{
+		{Kind: KindPriceChange, Nozzle: "01", Level: "0", Value: "6199"},
+		{Kind: KindPreset, Nozzle: "02", Value: "1000"},
+		{Kind: KindPreset, Nozzle: "01", Identifier: "ABCDEF0123456789", Value: "$2000"},
+		{Kind: KindModeChange, Nozzle: "02", Mode: "B"},
+	} {
+		e.Time = start.Add(time.Duration(i) * time.Hour)
+		if _, err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []uint64
+	}{
+		{"all", Query{}, []uint64{1, 2, 3, 4}},
+		{"kind", Query{Kind: KindPreset}, []uint64{2, 3}},
+		{"nozzle", Query{Nozzle: "02"}, []uint64{2, 4}},
+		{"identifier", Query{Identifier: "abcdef0123456789"}, []uint64{3}},
+		{"from", Query{From: start.Add(90 * time.Minute)}, []uint64{3, 4}},
+		{"range", Query{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, []uint64{2, 3}},
+		{"after", Query{After: 2}, []uint64{3, 4}},
+	}
+	for _, tt := range tests {
+		entries, _, err := j.Query(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := seqs(entries); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: seqs %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	entries, next, err := j.Query(Query{Limit: 3})
+	if err != nil || len(entries) != 3 || next != 3 {
+		t.Fatalf("first page: %v, next %d, %v", seqs(entries), next, err)
+	}
+	entries, next, _ = j.Query(Query{Limit: 3, After: next})
+	if len(entries) != 1 || next != 0 {
+		t.Errorf("last page: %v, next %d", seqs(entries), next)
+	}
+	if _, _, err := j.Query(Query{From: start, To: start}); err == nil {
+		t.Error("empty range accepted")
+	}
+}
+
+func TestStore(t *testing.T) {
+	j := open(t)
+	ctx := context.Background()
+	rec := companytec.SupplyRecord{Nozzle: "01", Record: 1, Volume: 100, FinalTotal: 100, Tag: "ABCDEF0123456789"}
+	s := companytec.CollectedSupply{Key: companytec.SupplyKey(rec), CollectedAt: time.Now(), SupplyRecord: rec}
+
+	if stored, err := j.Store(ctx, s); err != nil || !stored {
+		t.Fatalf("store: %v, %v", stored, err)
+	}
+	if stored, err := j.Store(ctx, s); err != nil || stored {
+		t.Errorf("store again: %v, %v", stored, err)
+	}
+	cp, err := j.Checkpoint(ctx)
+	if err != nil || cp.Key != s.Key || cp.Count != 1 {
+		t.Errorf("checkpoint %+v, %v", cp, err)
+	}
+	entries, _, _ := j.Query(Query{Identifier: "ABCDEF0123456789"})
+	if len(entries) != 1 || entries[0].Kind != KindSupply || entries[0].Supply.Volume != 100 {
+		t.Errorf("supplies %+v", entries)
+	}
+}
+
+func connect(t *testing.T) *companytec.Client {
+	t.Helper()
+	sim := simulator.New(simulator.Config{})
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	c := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+func TestObserve(t *testing.T) {
+	j := open(t)
+	c := connect(t)
+	cancel := j.Observe(c, nil)
+
+	c.ChangePrice("01", "0", "6199")
+	c.ChangePrice("02", "1", "012999")
+	c.SetPreset("01", "1000")
+	c.SetOperatingMode("02", "B")
+	c.BlacklistIdentifier("1111222233334444")
+	c.SetPresetIdentified(companytec.IdentifiedPreset{
+		Nozzle: "03", ID: "abcdef0123456789", Type: companytec.IdentifierCustomer,
+		Authorize: true, Value: 2000, PresetType: "$",
+	})
+	c.Status()
+	// Refused by the device: the nozzle is blocked
+	if _, err := c.SetPreset("02", "500"); err == nil {
+		t.Fatal("preset accepted on a blocked nozzle")
+	}
+	cancel()
+	c.SetOperatingMode("02", "L")
+
+	entries, _, err := j.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Kind: KindPriceChange, Nozzle: "01", Level: "0", Value: "6199"},
+		{Kind: KindPriceChange, Nozzle: "02", Level: "1", Value: "12999"},
+		{Kind: KindPreset, Nozzle: "01", Value: "1000"},
+		{Kind: KindModeChange, Nozzle: "02", Mode: "B"},
+		{Kind: KindPreset, Nozzle: "03", Identifier: "ABCDEF0123456789", Value: "$2000"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries %+v", entries)
+	}
+	for i, e := range entries {
+		e.Seq, e.Time = 0, time.Time{}
+		if e != want[i] {
+			t.Errorf("entry %d: %+v, want %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestObserveDoesNotBlock(t *testing.T) {
+	j := open(t)
+	c := connect(t)
+	cancel := j.Observe(c, nil)
+	defer cancel()
+
+	// Hold the write lock, as a slow fsync would
+	tx, err := j.db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.SetPreset("01", "1000")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("command waited on the journal")
+	}
+	tx.Rollback()
+
+	cancel()
+	entries, _, err := j.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != KindPreset {
+		t.Errorf("entries %+v", entries)
+	}
+}
+
+func TestCommandEntry(t *testing.T) {
+	c := companytec.New("")
+	tests := []struct {
+		params string
+		want   Entry
+	}{
+		{"01006199", Entry{Kind: KindPriceChange, Nozzle: "01", Level: "0", Value: "6199"}},
+		{"021001299", Entry{}},
+		{"0210012999", Entry{Kind: KindPriceChange, Nozzle: "02", Level: "1", Value: "12999"}},
+	}
+	for _, tt := range tests {
+		e, ok := commandEntry("&U", c.BuildCommand("&U", tt.params))
+		if ok != (tt.want.Kind != "") || e != tt.want {
+			t.Errorf("&U%s: %+v, %v, want %+v", tt.params, e, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Package metrics exports Prometheus metrics of the devices a gateway talks to
+// and of its HTTP API.
+//
+// Device metrics are taken at the Client.SendCommand choke point: every
+// command reports its latency, queue wait and outcome, and the status and
+// totalizer responses passing through update the per-nozzle gauges, so no
+// extra command is sent to the device.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"companytec-client/pkg/companytec"
+)
+
+const namespace = "companytec"
+
+// statusCodes are the nozzle states exported by the nozzle status gauge.
+var statusCodes = []companytec.StatusCode{
+	companytec.StatusAvailable,
+	companytec.StatusBlocked,
+	companytec.StatusFinished,
+	companytec.StatusRefueling,
+	companytec.StatusWaiting,
+	companytec.StatusReady,
+}
+
+// Metrics holds the metrics and the registry they are exported from. Its
+// methods are safe for concurrent use.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	commandDuration *prometheus.HistogramVec
+	queueWait       *prometheus.HistogramVec
+	commandErrors   *prometheus.CounterVec
+	reconnects      *prometheus.CounterVec
+	up              *prometheus.GaugeVec
+	nozzleStatus    *prometheus.GaugeVec
+	nozzleTotal     *prometheus.GaugeVec
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+
+	mu      sync.Mutex
+	clients map[string]*companytec.Client // Instrumented clients, by device
+}
+
+// New creates the metrics in a new registry, along with the Go runtime and
+// process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "command_duration_seconds",
+			Help:      "Time a command held the device connection, reconnect and resend included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"device", "header"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_wait_seconds",
+			Help:      "Time a command waited for the device connection.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"device", "priority"}),
+		commandErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "command_errors_total",
+			Help:      "Failed commands, by protocol error kind or io, timeout, canceled and queue_full.",
+		}, []string{"device", "header", "kind"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Connections re-established after the first one.",
+		}, []string{"device"}),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "connection_up",
+			Help:      "1 while the device connection is up.",
+		}, []string{"device"}),
+		nozzleStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "nozzle_status",
+			Help:      "1 for the last status read of a nozzle, 0 for the other statuses.",
+		}, []string{"device", "nozzle", "status"}),
+		nozzleTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "nozzle_total",
+			Help:      "Last totalizer read of a nozzle, in device units, by mode (volume or value).",
+		}, []string{"device", "nozzle", "mode"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "API requests, by route.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "API request latency, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		clients: make(map[string]*companytec.Client),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.commandDuration, m.queueWait, m.commandErrors, m.reconnects, m.up,
+		m.nozzleStatus, m.nozzleTotal, m.httpRequests, m.httpDuration,
+		queueCollector{m},
+	)
+	return m
+}
+
+// Registry returns the registry the metrics are exported from, to register
+// more collectors.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument records the metrics of client under the device label and
+// returns a function that stops recording and removes the device series.
+// Device names must be unique.
+func (m *Metrics) Instrument(device string, client *companytec.Client) (cancel func()) {
+	m.mu.Lock()
+	m.clients[device] = client
+	m.mu.Unlock()
+
+	up := m.up.WithLabelValues(device)
+	reconnects := m.reconnects.WithLabelValues(device)
+	connected := client.State() == companytec.StateConnected
+	if connected {
+		up.Set(1)
+	} else {
+		up.Set(0)
+	}
+	// State listeners are called with the connection locked, one at a time
+	cancelState := client.OnStateChange(func(change companytec.StateChange) {
+		if change.To != companytec.StateConnected {
+			up.Set(0)
+			return
+		}
+		up.Set(1)
+		if connected {
+			reconnects.Inc()
+		}
+		connected = true
+	})
+	cancelCommand := client.OnCommand(func(_ context.Context, res companytec.CommandResult) {
+		m.observe(device, res)
+	})
+
+	return func() {
+		cancelState()
+		cancelCommand()
+		m.mu.Lock()
+		if m.clients[device] == client {
+			delete(m.clients, device)
+		}
+		m.mu.Unlock()
+		labels := prometheus.Labels{"device": device}
+		for _, vec := range []interface{ DeletePartialMatch(prometheus.Labels) int }{
+			m.commandDuration, m.queueWait, m.commandErrors, m.reconnects, m.up,
+			m.nozzleStatus, m.nozzleTotal,
+		} {
+			vec.DeletePartialMatch(labels)
+		}
+	}
+}
+
+func (m *Metrics) observe(device string, res companytec.CommandResult) {
+	m.queueWait.WithLabelValues(device, res.Priority.String()).Observe(res.Wait.Seconds())
+	header := headerLabel(res.Header)
+	if res.Duration > 0 {
+		m.commandDuration.WithLabelValues(device, header).Observe(res.Duration.Seconds())
+	}
+	if res.Err != nil {
+		m.commandErrors.WithLabelValues(device, header, errorKind(res.Err)).Inc()
+		return
+	}
+
+	switch res.Header {
+	case "&S":
+		statuses, err := companytec.ParseStatus(res.Response)
+		if err != nil {
+			return
+		}
+		for _, n := range statuses {
+			if !n.Present() {
+				continue
+			}
+			for _, code := range statusCodes {
+				v := 0.0
+				if n.Code == code {
+					v = 1
+				}
+				m.nozzleStatus.WithLabelValues(device, n.Nozzle, statusLabel(code)).Set(v)
+			}
+		}
+	case "&T":
+		total, err := companytec.ParseTotal(res.Response)
+		if err != nil {
+			return
+		}
+		// &T also reads prices and memory pointers, in other modes
+		switch total.Mode {
+		case "L":
+			m.nozzleTotal.WithLabelValues(device, total.Nozzle, "volume").Set(float64(total.Value))
+		case "$":
+			m.nozzleTotal.WithLabelValues(device, total.Nozzle, "value").Set(float64(total.Value))
+		}
+	}
+}
+
+// headerLabel labels a command header, with "other" for unknown commands so
+// that the label set stays small.
+func headerLabel(header string) string {
+	if !companytec.KnownHeader(header) {
+		return "other"
+	}
+	return header
+}
+
+// errorKind labels a command error.
+func errorKind(err error) string {
+	var perr *companytec.ProtocolError
+	switch {
+	case errors.As(err, &perr):
+		return perr.Kind.String()
+	case errors.Is(err, companytec.ErrQueueFull):
+		return "queue_full"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "io"
+	}
+}
+
+// statusLabel turns "Not Present" into "not_present".
+func statusLabel(code companytec.StatusCode) string {
+	return strings.ReplaceAll(strings.ToLower(code.Description()), " ", "_")
+}
+
+// Middleware records the requests of the gin router it is used by, labelled
+// by route pattern rather than path to keep the number of series bounded.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		m.httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// queueCollector exports the command queue metrics of the instrumented
+// clients when scraped.
+type queueCollector struct {
+	m *Metrics
+}
+
+var (
+	queueDepthDesc = prometheus.NewDesc(namespace+"_queue_depth",
+		"Commands waiting for the device connection.", []string{"device", "priority"}, nil)
+	queueLimitDesc = prometheus.NewDesc(namespace+"_queue_limit",
+		"Commands that can wait before being rejected, 0 when unbounded.", []string{"device"}, nil)
+	coalescedDesc = prometheus.NewDesc(namespace+"_commands_coalesced_total",
+		"Reads answered by an identical read in flight.", []string{"device"}, nil)
+	rejectedDesc = prometheus.NewDesc(namespace+"_commands_rejected_total",
+		"Commands rejected because the queue was full.", []string{"device"}, nil)
+)
+
+func (q queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueLimitDesc
+	ch <- coalescedDesc
+	ch <- rejectedDesc
+}
+
+func (q queueCollector) Collect(ch chan<- prometheus.Metric) {
+	q.m.mu.Lock()
+	clients := make(map[string]*companytec.Client, len(q.m.clients))
+	for device, client := range q.m.clients {
+		clients[device] = client
+	}
+	q.m.mu.Unlock()
+
+	for device, client := range clients {
+		st := client.QueueStats()
+		for _, cs := range st.Classes {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(cs.Depth), device, cs.Priority.String())
+		}
+		ch <- prometheus.MustNewConstMetric(queueLimitDesc, prometheus.GaugeValue, float64(st.Limit), device)
+		ch <- prometheus.MustNewConstMetric(coalescedDesc, prometheus.CounterValue, float64(st.Coalesced), device)
+		ch <- prometheus.MustNewConstMetric(rejectedDesc, prometheus.CounterValue, float64(st.Rejected), device)
+	}
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// connect returns a client talking to sim over a net.Pipe.
+func connect(t *testing.T, sim *simulator.Simulator, opts ...companytec.Option) *companytec.Client {
+	t.Helper()
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	opts = append([]companytec.Option{companytec.WithDialer(dialer), companytec.WithTimeout(time.Second)}, opts...)
+	c := companytec.New("simulator:2001", opts...)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+func TestInstrument(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true})
+	c := connect(t, sim)
+	m := New()
+	cancel := m.Instrument("pump1", c)
+
+	if v := testutil.ToFloat64(m.up.WithLabelValues("pump1")); v != 1 {
+		t.Errorf("connection_up %v", v)
+	}
+	sim.Lift("02")
+	if _, err := c.Status(); err != nil {
+		t.Fatal(err)
+	}
+	status := func(nozzle string, code companytec.StatusCode) float64 {
+		return testutil.ToFloat64(m.nozzleStatus.WithLabelValues("pump1", nozzle, statusLabel(code)))
+	}
+	if status("01", companytec.StatusAvailable) != 1 || status("01", companytec.StatusRefueling) != 0 ||
+		status("02", companytec.StatusRefueling) != 1 {
+		t.Error("nozzle_status does not match the status read")
+	}
+	sim.Dispense("02", 700)
+	sim.Hang("02")
+	if _, err := c.Total("02", "L"); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(m.nozzleTotal.WithLabelValues("pump1", "02", "volume")); v != 700 {
+		t.Errorf("nozzle_total %v, want 700", v)
+	}
+	if n := testutil.CollectAndCount(m.commandDuration, "companytec_command_duration_seconds"); n != 2 {
+		t.Errorf("%d command duration series, want one per header", n)
+	}
+
+	if _, err := c.ChangePrice("0A", "0", "6199"); err == nil {
+		t.Fatal("price change accepted for a missing nozzle")
+	}
+	if v := testutil.ToFloat64(m.commandErrors.WithLabelValues("pump1", "&U", "nak")); v != 1 {
+		t.Errorf("command_errors_total %v", v)
+	}
+	if _, err := c.SendCommand("(&Z1)"); err == nil {
+		t.Fatal("unknown command accepted")
+	}
+	if v := testutil.ToFloat64(m.commandErrors.WithLabelValues("pump1", "other", "nak")); v != 1 {
+		t.Errorf("command_errors_total of unknown commands %v", v)
+	}
+
+	c.Disconnect()
+	if v := testutil.ToFloat64(m.up.WithLabelValues("pump1")); v != 0 {
+		t.Errorf("connection_up %v after disconnect", v)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(m.reconnects.WithLabelValues("pump1")); v != 1 {
+		t.Errorf("reconnects_total %v", v)
+	}
+
+	// Cancelling removes the series of the device
+	cancel()
+	c.Status()
+	if n := testutil.CollectAndCount(m.nozzleStatus) + testutil.CollectAndCount(m.up) +
+		testutil.CollectAndCount(queueCollector{m}); n != 0 {
+		t.Errorf("%d series left after cancel", n)
+	}
+}
+
+func TestQueueCollector(t *testing.T) {
+	m := New()
+	m.Instrument("pump1", connect(t, simulator.New(simulator.Config{}), companytec.WithQueueLimit(8)))
+	expected := `
+# HELP companytec_queue_limit Commands that can wait before being rejected, 0 when unbounded.
+# TYPE companytec_queue_limit gauge
+companytec_queue_limit{device="pump1"} 8
+# HELP companytec_commands_rejected_total Commands rejected because the queue was full.
+# TYPE companytec_commands_rejected_total counter
+companytec_commands_rejected_total{device="pump1"} 0
+`
+	if err := testutil.GatherAndCompare(m.Registry(), strings.NewReader(expected),
+		"companytec_queue_limit", "companytec_commands_rejected_total"); err != nil {
+		t.Error(err)
+	}
+	if problems, err := testutil.GatherAndLint(m.Registry()); err != nil || len(problems) != 0 {
+		t.Errorf("lint: %+v, %v", problems, err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("status: %w", &companytec.ProtocolError{Kind: companytec.ErrBadChecksum}), "bad_checksum"},
+		{companytec.ErrQueueFull, "queue_full"},
+		{context.DeadlineExceeded, "timeout"},
+		{context.Canceled, "canceled"},
+		{io.EOF, "io"},
+		{errors.New("connection reset"), "io"},
+	}
+	for _, tt := range tests {
+		if got := errorKind(tt.err); got != tt.want {
+			t.Errorf("errorKind(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/total/:nozzle", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for _, path := range []string{"/total/01", "/total/02", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// Requests are labelled by route, not path
+	if v := testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/total/:nozzle", "200")); v != 2 {
+		t.Errorf("requests to /total/:nozzle %v", v)
+	}
+	if v := testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "unmatched", "404")); v != 1 {
+		t.Errorf("unmatched requests %v", v)
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `companytec_http_request_duration_seconds_count{method="GET",route="/total/:nozzle"} 2`) {
+		t.Errorf("exposition misses the request latency:\n%s", w.Body.String())
+	}
+}
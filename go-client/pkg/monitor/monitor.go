@@ -0,0 +1,467 @@
+// Package monitor turns the polled nozzle status into fueling lifecycle
+// events.
+//
+// A Monitor polls &S at a fixed interval and, while a nozzle is dispensing,
+// &V at a shorter one. It diffs the state of every nozzle and emits an Event
+// for each change to the subscribed channels and handlers.
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	// NozzleAppeared is emitted when a position starts reporting a nozzle,
+	// including for every nozzle present at the first poll.
+	NozzleAppeared EventType = "nozzle_appeared"
+	// NozzleDisappeared is emitted when a position reports no nozzle (F).
+	NozzleDisappeared EventType = "nozzle_disappeared"
+	// NozzleLifted is emitted when a nozzle is lifted, waiting for
+	// authorization (E) or going straight to dispensing.
+	NozzleLifted EventType = "nozzle_lifted"
+	// FuelingStarted is emitted when a nozzle starts dispensing (A).
+	FuelingStarted EventType = "fueling_started"
+	// FuelingProgress is emitted when the value dispensed, read with &V,
+	// changes.
+	FuelingProgress EventType = "fueling_progress"
+	// FuelingFinished is emitted when a nozzle stops dispensing. Value is the
+	// last value read with &V.
+	FuelingFinished EventType = "fueling_finished"
+	// SupplyCompleted follows FuelingFinished with the supply the device
+	// stored, when Config.Supplies is set.
+	SupplyCompleted EventType = "supply_completed"
+	// NozzleBlocked is emitted when a nozzle is blocked (B).
+	NozzleBlocked EventType = "nozzle_blocked"
+	// NozzleUnblocked is emitted when a blocked nozzle is released.
+	NozzleUnblocked EventType = "nozzle_unblocked"
+)
+
+// Event is a change of a nozzle.
+type Event struct {
+	Type     EventType                `json:"type"`
+	Nozzle   string                   `json:"nozzle"`
+	Position int                      `json:"position"`
+	Status   companytec.StatusCode    `json:"status"`
+	Previous companytec.StatusCode    `json:"previous,omitempty"`
+	Value    int                      `json:"value,omitempty"`
+	Supply   *companytec.SupplyRecord `json:"supply,omitempty"`
+	Time     time.Time                `json:"time"`
+}
+
+// NozzleState is the last known state of a nozzle.
+type NozzleState struct {
+	Position int                   `json:"position"`
+	Nozzle   string                `json:"nozzle"`
+	Status   companytec.StatusCode `json:"status"`
+	Value    int                   `json:"value,omitempty"` // Value dispensed, while fueling
+	Since    time.Time             `json:"since"`           // Time of the last status change
+}
+
+// Config configures a Monitor. Zero values get sensible defaults.
+type Config struct {
+	// StatusInterval between two &S polls. Defaults to 1 second.
+	StatusInterval time.Duration
+	// VisualizationInterval between two &V polls while a nozzle is
+	// dispensing. Defaults to 500 milliseconds; negative disables
+	// FuelingProgress events.
+	VisualizationInterval time.Duration
+	// Supplies reads the supply stored by each finished fueling with &T99P
+	// and &L C, which do not move the read pointer, and emits
+	// SupplyCompleted. Supplies stored just before the write pointer wraps
+	// around are not looked up.
+	Supplies bool
+	// Now returns the event time. Defaults to time.Now.
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.StatusInterval <= 0 {
+		cfg.StatusInterval = time.Second
+	}
+	if cfg.VisualizationInterval == 0 {
+		cfg.VisualizationInterval = 500 * time.Millisecond
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+}
+
+// Subscription receives events on C. A slow subscriber does not hold up the
+// monitor: events that do not fit in the buffer are dropped and counted.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	m       *Monitor
+	dropped int
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *Subscription) Dropped() int {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.dropped
+}
+
+// Close unsubscribes and closes C.
+func (s *Subscription) Close() {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if _, ok := s.m.subs[s]; ok {
+		delete(s.m.subs, s)
+		close(s.c)
+	}
+}
+
+// Monitor polls a device and emits nozzle events. Its methods are safe for
+// concurrent use.
+type Monitor struct {
+	client *companytec.Client
+	cfg    Config
+
+	// pollMu serializes polls, so events are emitted in order.
+	pollMu sync.Mutex
+
+	mu       sync.Mutex
+	nozzles  map[string]*NozzleState
+	started  bool
+	subs     map[*Subscription]struct{}
+	handlers map[int]func(Event)
+	nextID   int
+}
+
+// New creates a Monitor for client. Call Run to start polling.
+func New(client *companytec.Client, cfg Config) *Monitor {
+	cfg.setDefaults()
+	return &Monitor{
+		client:   client,
+		cfg:      cfg,
+		nozzles:  make(map[string]*NozzleState),
+		subs:     make(map[*Subscription]struct{}),
+		handlers: make(map[int]func(Event)),
+	}
+}
+
+// Subscribe returns a subscription with a buffer of size events.
+func (m *Monitor) Subscribe(size int) *Subscription {
+	c := make(chan Event, size)
+	s := &Subscription{C: c, c: c, m: m}
+	m.mu.Lock()
+	m.subs[s] = struct{}{}
+	m.mu.Unlock()
+	return s
+}
+
+// Handle calls h for every event, from the polling goroutine, until the
+// returned function is called. h must not block.
+func (m *Monitor) Handle(h func(Event)) (cancel func()) {
+	m.mu.Lock()
+	id := m.nextID
+	m.nextID++
+	m.handlers[id] = h
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		delete(m.handlers, id)
+		m.mu.Unlock()
+	}
+}
+
+// Nozzles returns the last known state of the present nozzles, by position.
+func (m *Monitor) Nozzles() []NozzleState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make([]NozzleState, 0, len(m.nozzles))
+	for _, n := range m.nozzles {
+		states = append(states, *n)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Position < states[j].Position })
+	return states
+}
+
+// Run polls until ctx is done. Failed polls are logged and retried at the
+// next tick; they do not stop Run.
+func (m *Monitor) Run(ctx context.Context) error {
+	status := time.NewTicker(m.cfg.StatusInterval)
+	defer status.Stop()
+	var vis <-chan time.Time
+	if m.cfg.VisualizationInterval > 0 {
+		t := time.NewTicker(m.cfg.VisualizationInterval)
+		defer t.Stop()
+		vis = t.C
+	}
+
+	if err := m.PollStatus(ctx); err != nil && ctx.Err() == nil {
+		m.cfg.Logger.Warn("status poll failed", "err", companytec.RedactError(err))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-status.C:
+			if err := m.PollStatus(ctx); err != nil && ctx.Err() == nil {
+				m.cfg.Logger.Warn("status poll failed", "err", companytec.RedactError(err))
+			}
+		case <-vis:
+			if !m.fueling() {
+				continue
+			}
+			if err := m.PollVisualization(ctx); err != nil && ctx.Err() == nil {
+				m.cfg.Logger.Warn("visualization poll failed", "err", companytec.RedactError(err))
+			}
+		}
+	}
+}
+
+// PollStatus reads &S once and emits the events for the changes since the
+// previous poll.
+func (m *Monitor) PollStatus(ctx context.Context) error {
+	m.pollMu.Lock()
+	defer m.pollMu.Unlock()
+	statuses, err := m.client.StatusCtx(ctx)
+	if err != nil {
+		return err
+	}
+	now := m.cfg.Now()
+
+	var events []Event
+	m.mu.Lock()
+	first := !m.started
+	m.started = true
+	for _, st := range statuses {
+		n, known := m.nozzles[st.Nozzle]
+		prev := companytec.StatusNotPresent
+		if known {
+			prev = n.Status
+		}
+		if prev == st.Code {
+			continue
+		}
+		ev := Event{Nozzle: st.Nozzle, Position: st.Position, Status: st.Code, Previous: prev, Time: now}
+		if first {
+			ev.Previous = ""
+		}
+		value := 0
+		if known {
+			value = n.Value
+		}
+		for _, t := range transition(prev, st.Code) {
+			ev.Type = t
+			ev.Value = 0
+			if t == FuelingFinished {
+				ev.Value = value
+			}
+			events = append(events, ev)
+		}
+
+		if st.Code == companytec.StatusNotPresent {
+			delete(m.nozzles, st.Nozzle)
+			continue
+		}
+		if !known {
+			n = &NozzleState{Position: st.Position, Nozzle: st.Nozzle}
+			m.nozzles[st.Nozzle] = n
+		}
+		n.Status = st.Code
+		n.Since = now
+		if st.Code != companytec.StatusRefueling {
+			n.Value = 0
+		}
+	}
+	m.mu.Unlock()
+
+	m.emit(events)
+	if m.cfg.Supplies {
+		m.emitSupplies(ctx, events)
+	}
+	return nil
+}
+
+// emitSupplies emits SupplyCompleted for the fuelings that finished in
+// events, looking their supplies up backwards from the write pointer.
+func (m *Monitor) emitSupplies(ctx context.Context, events []Event) {
+	var finished []Event
+	for _, ev := range events {
+		if ev.Type == FuelingFinished {
+			finished = append(finished, ev)
+		}
+	}
+	if len(finished) == 0 {
+		return
+	}
+	p, err := m.client.PointersCtx(ctx)
+	if err != nil {
+		m.cfg.Logger.Warn("supply lookup failed", "err", companytec.RedactError(err))
+		return
+	}
+
+	found := make([]*companytec.SupplyRecord, len(finished))
+	missing := len(finished)
+	// Other supplies may have been stored in between; look a little further
+	// back than the number of fuelings
+	for pos := p.Write - 1; pos >= 0 && pos >= p.Write-2*len(finished) && missing > 0; pos-- {
+		rec, err := m.client.SupplyAtCtx(ctx, pos)
+		if err != nil {
+			m.cfg.Logger.Warn("supply lookup failed", "position", pos, "err", companytec.RedactError(err))
+			break
+		}
+		if rec == nil {
+			continue
+		}
+		for i, ev := range finished {
+			if found[i] == nil && ev.Nozzle == rec.Nozzle {
+				found[i] = rec
+				missing--
+				break
+			}
+		}
+	}
+
+	var out []Event
+	now := m.cfg.Now()
+	for i, ev := range finished {
+		if found[i] == nil {
+			continue
+		}
+		ev.Type = SupplyCompleted
+		ev.Supply = found[i]
+		ev.Time = now
+		out = append(out, ev)
+	}
+	m.emit(out)
+}
+
+// PollVisualization reads &V once and emits FuelingProgress for the nozzles
+// whose dispensed value changed.
+func (m *Monitor) PollVisualization(ctx context.Context) error {
+	m.pollMu.Lock()
+	defer m.pollMu.Unlock()
+	entries, err := m.client.VisualizationCtx(ctx)
+	if err != nil {
+		return err
+	}
+	now := m.cfg.Now()
+
+	var events []Event
+	m.mu.Lock()
+	for _, e := range entries {
+		n, ok := m.nozzles[e.Nozzle]
+		if !ok || n.Status != companytec.StatusRefueling || n.Value == e.Value {
+			continue
+		}
+		n.Value = e.Value
+		events = append(events, Event{
+			Type: FuelingProgress, Nozzle: n.Nozzle, Position: n.Position,
+			Status: n.Status, Value: e.Value, Time: now,
+		})
+	}
+	m.mu.Unlock()
+
+	m.emit(events)
+	return nil
+}
+
+// fueling reports whether a nozzle is dispensing.
+func (m *Monitor) fueling() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, n := range m.nozzles {
+		if n.Status == companytec.StatusRefueling {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) emit(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	m.mu.Lock()
+	handlers := make([]func(Event), 0, len(m.handlers))
+	for _, h := range m.handlers {
+		handlers = append(handlers, h)
+	}
+	for _, ev := range events {
+		for s := range m.subs {
+			select {
+			case s.c <- ev:
+			default:
+				s.dropped++
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ev := range events {
+		for _, h := range handlers {
+			h(ev)
+		}
+	}
+}
+
+// transition returns the events for a status change, in lifecycle order.
+// Polls can miss short-lived states, so the events of the skipped steps are
+// emitted as well: L to C yields lifted, started and finished.
+func transition(prev, cur companytec.StatusCode) []EventType {
+	const (
+		absent   = companytec.StatusNotPresent
+		blocked  = companytec.StatusBlocked
+		waiting  = companytec.StatusWaiting
+		fueling  = companytec.StatusRefueling
+		finished = companytec.StatusFinished
+	)
+	if cur == absent {
+		return []EventType{NozzleDisappeared}
+	}
+	if prev == absent {
+		return []EventType{NozzleAppeared}
+	}
+
+	var events []EventType
+	if prev == blocked {
+		events = append(events, NozzleUnblocked)
+	}
+	switch cur {
+	case blocked:
+		if prev == fueling {
+			events = append(events, FuelingFinished)
+		}
+		events = append(events, NozzleBlocked)
+	case waiting:
+		if prev != fueling {
+			events = append(events, NozzleLifted)
+		}
+	case fueling:
+		if prev != waiting {
+			events = append(events, NozzleLifted)
+		}
+		events = append(events, FuelingStarted)
+	case finished:
+		if prev != fueling {
+			if prev != waiting {
+				events = append(events, NozzleLifted)
+			}
+			events = append(events, FuelingStarted)
+		}
+		events = append(events, FuelingFinished)
+	default:
+		if prev == fueling {
+			events = append(events, FuelingFinished)
+		}
+	}
+	return events
+}
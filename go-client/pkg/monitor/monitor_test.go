@@ -0,0 +1,207 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func newMonitor(t *testing.T, sim *simulator.Simulator, cfg Config) *Monitor {
+	t.Helper()
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	c := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Disconnect)
+	return New(c, cfg)
+}
+
+// drain returns the events buffered in s.
+func drain(s *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case ev := <-s.C:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func types(events []Event) []EventType {
+	var t []EventType
+	for _, ev := range events {
+		t = append(t, ev.Type)
+	}
+	return t
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		prev, cur companytec.StatusCode
+		want      []EventType
+	}{
+		{companytec.StatusNotPresent, companytec.StatusAvailable, []EventType{NozzleAppeared}},
+		{companytec.StatusAvailable, companytec.StatusNotPresent, []EventType{NozzleDisappeared}},
+		{companytec.StatusAvailable, companytec.StatusWaiting, []EventType{NozzleLifted}},
+		{companytec.StatusWaiting, companytec.StatusRefueling, []EventType{FuelingStarted}},
+		{companytec.StatusAvailable, companytec.StatusRefueling, []EventType{NozzleLifted, FuelingStarted}},
+		{companytec.StatusRefueling, companytec.StatusFinished, []EventType{FuelingFinished}},
+		{companytec.StatusAvailable, companytec.StatusFinished, []EventType{NozzleLifted, FuelingStarted, FuelingFinished}},
+		{companytec.StatusRefueling, companytec.StatusAvailable, []EventType{FuelingFinished}},
+		{companytec.StatusRefueling, companytec.StatusBlocked, []EventType{FuelingFinished, NozzleBlocked}},
+		{companytec.StatusBlocked, companytec.StatusAvailable, []EventType{NozzleUnblocked}},
+		{companytec.StatusBlocked, companytec.StatusWaiting, []EventType{NozzleUnblocked, NozzleLifted}},
+		{companytec.StatusFinished, companytec.StatusAvailable, nil},
+	}
+	for _, tt := range tests {
+		if got := transition(tt.prev, tt.cur); !slices.Equal(got, tt.want) {
+			t.Errorf("%s to %s: %v, want %v", tt.prev, tt.cur, got, tt.want)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	m := newMonitor(t, sim, Config{})
+	sub := m.Subscribe(64)
+	defer sub.Close()
+	ctx := context.Background()
+
+	if err := m.PollStatus(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first := drain(sub)
+	if len(first) != 4 || first[0].Type != NozzleAppeared || first[0].Previous != "" {
+		t.Fatalf("first poll %+v", first)
+	}
+	if n := m.Nozzles(); len(n) != 4 || n[0].Nozzle != "01" || n[0].Status != companytec.StatusAvailable {
+		t.Errorf("nozzles %+v", n)
+	}
+
+	sim.Lift("01")
+	sim.Authorize("01")
+	m.PollStatus(ctx)
+	sim.Dispense("01", 1000)
+	m.PollVisualization(ctx)
+	m.PollVisualization(ctx) // Unchanged: no event
+	sim.Hang("01")
+	m.PollStatus(ctx)
+	m.PollStatus(ctx) // C was reported once, then L
+
+	events := drain(sub)
+	want := []EventType{NozzleLifted, FuelingStarted, FuelingProgress, FuelingFinished}
+	if got := types(events); !slices.Equal(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	progress, finished := events[2], events[3]
+	if progress.Value == 0 || finished.Value != progress.Value || finished.Previous != companytec.StatusRefueling {
+		t.Errorf("progress %+v, finished %+v", progress, finished)
+	}
+}
+
+func TestSubscribers(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	m := newMonitor(t, sim, Config{})
+	small := m.Subscribe(1)
+	var handled []EventType
+	cancel := m.Handle(func(ev Event) { handled = append(handled, ev.Type) })
+
+	m.PollStatus(context.Background())
+	if small.Dropped() != 3 || len(handled) != 4 {
+		t.Errorf("dropped %d, handled %v", small.Dropped(), handled)
+	}
+
+	cancel()
+	small.Close()
+	small.Close()
+	if _, ok := <-small.C; !ok {
+		t.Error("buffered event lost on close")
+	}
+	if _, ok := <-small.C; ok {
+		t.Error("subscription open after Close")
+	}
+	sim.Lift("02")
+	m.PollStatus(context.Background())
+	if len(handled) != 4 {
+		t.Errorf("handler called after cancel: %v", handled)
+	}
+}
+
+func TestRun(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	m := newMonitor(t, sim, Config{StatusInterval: time.Millisecond, VisualizationInterval: -1})
+	lifted := make(chan Event, 1)
+	m.Handle(func(ev Event) {
+		if ev.Type == NozzleLifted {
+			lifted <- ev
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	// A nozzle lifted before the first poll only appears
+	for len(m.Nozzles()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	sim.Lift("03")
+	select {
+	case ev := <-lifted:
+		if ev.Nozzle != "03" {
+			t.Errorf("lifted %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("no lift event")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+}
+
+func TestSupplyCompleted(t *testing.T) {
+	sim := simulator.New(simulator.Config{AutoAuthorize: true})
+	m := newMonitor(t, sim, Config{Supplies: true})
+	sub := m.Subscribe(64)
+	defer sub.Close()
+	ctx := context.Background()
+
+	// An older supply of nozzle 02 is in the memory
+	sim.Lift("02")
+	sim.Dispense("02", 100)
+	sim.Hang("02")
+	m.PollStatus(ctx)
+
+	// Two fuelings finish between two polls
+	sim.Lift("01")
+	sim.Lift("02")
+	sim.Dispense("01", 200)
+	sim.Dispense("02", 300)
+	m.PollStatus(ctx)
+	drain(sub)
+	sim.Hang("01")
+	sim.Hang("02")
+	m.PollStatus(ctx)
+
+	supplies := map[string]int{}
+	for _, ev := range drain(sub) {
+		if ev.Type == SupplyCompleted {
+			supplies[ev.Nozzle] = ev.Supply.Volume
+		}
+	}
+	if len(supplies) != 2 || supplies["01"] != 200 || supplies["02"] != 300 {
+		t.Errorf("supplies %v", supplies)
+	}
+}
@@ -0,0 +1,63 @@
+package recording
+
+import (
+	"context"
+	"errors"
+
+	"companytec-client/pkg/companytec"
+)
+
+// DiffConfig configures Diff.
+type DiffConfig struct {
+	// Writes also sends the commands that change the device (presets, price
+	// and mode changes, acknowledgements...). By default only the read-only
+	// commands are sent and the others are skipped.
+	Writes bool
+}
+
+// Result compares the response of a live device to a recorded exchange.
+type Result struct {
+	Exchange
+	Live string // Response of the live device
+	// Err is set when the live device did not answer. Protocol errors are
+	// not reported here: the frame they carry is in Live.
+	Err     error
+	Skipped bool // Not sent, see DiffConfig.Writes
+}
+
+// Match reports whether the live device answered as recorded.
+func (r Result) Match() bool {
+	return !r.Skipped && r.Err == nil && r.Live == r.Response
+}
+
+// Diff sends the commands of exchanges to client, in order, and compares the
+// responses to the recorded ones. Responses that depend on time, such as the
+// clock or a status during a supply, naturally differ. It stops when ctx is
+// done, returning the results so far and the context error.
+func Diff(ctx context.Context, client *companytec.Client, exchanges []Exchange, cfg DiffConfig) ([]Result, error) {
+	results := make([]Result, 0, len(exchanges))
+	for _, e := range exchanges {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		r := Result{Exchange: e}
+		if !cfg.Writes && !companytec.IsReadOnly(e.Command) {
+			r.Skipped = true
+			results = append(results, r)
+			continue
+		}
+
+		live, err := client.SendCommandCtx(ctx, e.Command)
+		var perr *companytec.ProtocolError
+		switch {
+		case err == nil:
+			r.Live = live
+		case errors.As(err, &perr):
+			r.Live = string(perr.Raw)
+		default:
+			r.Err = err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,43 @@
+package recording
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func TestDiffSkipsWrites(t *testing.T) {
+	sim := simulator.New(simulator.Config{})
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	client := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	defer client.Disconnect()
+
+	exchanges := []Exchange{
+		{Command: "(&S)", Response: "(S" + "LLLL" + "FFFFFFFFFFFFFFFFFFFFFFFFFFFF" + ")"},
+		{Command: client.BuildCommand("&L", "C0000"), Response: "(0)"},
+		{Command: client.BuildCommand("&L", "R0000"), Response: "(0)"},
+		{Command: "(&I)", Response: "(0)"},
+		{Command: client.BuildCommand("&P", "01001000"), Response: "(0)"},
+	}
+	results, err := Diff(context.Background(), client, exchanges, DiffConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := []bool{false, false, true, true, true}
+	for i, r := range results {
+		if r.Skipped != skipped[i] {
+			t.Errorf("%s: skipped %v", r.Command, r.Skipped)
+		}
+		if !r.Skipped && !r.Match() {
+			t.Errorf("%s: live %q, recorded %q (%v)", r.Command, r.Live, r.Response, r.Err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package recording
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// PlayerConfig configures a Player.
+type PlayerConfig struct {
+	// Latency waits the recorded latency before every response, to replay
+	// the timing of the device as well.
+	Latency bool
+	// Logger logs the commands missing from the recording. Defaults to
+	// discarding.
+	Logger *slog.Logger
+}
+
+func (cfg *PlayerConfig) setDefaults() {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.DiscardHandler)
+	}
+}
+
+// PlayerStats counts the commands a Player answered.
+type PlayerStats struct {
+	Served  int // Answered from the recording
+	Unknown int // Missing from the recording, answered (0)
+}
+
+// Player serves a recording as a fake device: every command is answered with
+// the responses it got in the recording, in order. Once they are used up the
+// last one is repeated, so that a client polling past the end of the
+// recording sees the final state. Commands that were not answered in the
+// recording get no response; commands missing from it are answered (0).
+type Player struct {
+	cfg PlayerConfig
+
+	mu        sync.Mutex
+	exchanges map[string][]Exchange // By command
+	next      map[string]int
+	stats     PlayerStats
+
+	lnMu      sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+}
+
+// NewPlayer creates a Player for exchanges (see Exchanges).
+func NewPlayer(exchanges []Exchange, cfg PlayerConfig) *Player {
+	cfg.setDefaults()
+	p := &Player{
+		cfg:       cfg,
+		exchanges: make(map[string][]Exchange),
+		next:      make(map[string]int),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	for _, e := range exchanges {
+		p.exchanges[e.Command] = append(p.exchanges[e.Command], e)
+	}
+	return p
+}
+
+// Start listens on addr (":0" picks a free port) and serves in the
+// background. It returns the address actually listened on.
+func (p *Player) Start(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go p.Serve(l)
+	return l.Addr().String(), nil
+}
+
+// Serve accepts connections on l until Close is called.
+func (p *Player) Serve(l net.Listener) error {
+	p.lnMu.Lock()
+	p.listeners = append(p.listeners, l)
+	p.lnMu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// ServeConn answers commands on a single connection until it is closed.
+func (p *Player) ServeConn(conn net.Conn) {
+	p.lnMu.Lock()
+	p.conns[conn] = struct{}{}
+	p.lnMu.Unlock()
+	defer func() {
+		p.lnMu.Lock()
+		delete(p.conns, conn)
+		p.lnMu.Unlock()
+		conn.Close()
+	}()
+
+	// Line noise between frames is dropped by the decoder
+	dec := companytec.NewFrameDecoder(conn, 0)
+	for {
+		command, err := dec.Next()
+		if err != nil {
+			return
+		}
+		e := p.answer(command)
+		if e.Response == "" {
+			continue
+		}
+		if p.cfg.Latency {
+			time.Sleep(e.Latency)
+		}
+		if _, err := conn.Write([]byte(e.Response)); err != nil {
+			return
+		}
+	}
+}
+
+// Handle answers a single command frame, as ServeConn does but without the
+// recorded latency. It returns "" for commands that got no response.
+func (p *Player) Handle(command string) string {
+	return p.answer(command).Response
+}
+
+func (p *Player) answer(command string) Exchange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	recorded := p.exchanges[command]
+	if len(recorded) == 0 {
+		p.stats.Unknown++
+		p.cfg.Logger.Warn("command not in recording", "command", command)
+		return Exchange{Command: command, Response: "(0)"}
+	}
+	i := p.next[command]
+	if i < len(recorded)-1 {
+		p.next[command] = i + 1
+	}
+	p.stats.Served++
+	return recorded[i]
+}
+
+// Stats returns the commands answered so far.
+func (p *Player) Stats() PlayerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Close stops every listener and drops every connection.
+func (p *Player) Close() error {
+	p.lnMu.Lock()
+	defer p.lnMu.Unlock()
+	for _, l := range p.listeners {
+		l.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.listeners = nil
+	return nil
+}
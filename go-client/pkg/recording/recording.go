@@ -0,0 +1,266 @@
+// Package recording captures protocol sessions with a device and plays them
+// back.
+//
+// A Recorder wraps the connections of a Client (see Recorder.Option) and
+// writes every frame sent and received, with its timing, to a JSON Lines
+// file. A Player serves a recording as a fake device, and Diff sends the
+// commands of a recording to a live device and compares the responses, so
+// sessions captured at a site can be studied in the lab.
+//
+// Frames are recorded as exchanged, identifier tags included, so that they
+// keep their checksums; treat recordings as confidential.
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// Frame directions.
+const (
+	DirTX = "tx" // Sent to the device
+	DirRX = "rx" // Received from the device
+)
+
+// Frame is a line of a recording.
+type Frame struct {
+	Time time.Time `json:"time"`
+	// Conn numbers the connections of a recording, from 1, since a client
+	// redials lost connections.
+	Conn  int    `json:"conn"`
+	Addr  string `json:"addr"` // Remote address of the connection
+	Dir   string `json:"dir"`
+	Frame string `json:"frame"`
+	// LatencyMs is the time from the last frame sent to this one, for
+	// received frames.
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+// Recorder writes the frames of the connections it wraps. Its methods are
+// safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	c     io.Closer // File opened by Create
+	conns int
+	err   error // First write error
+}
+
+// NewRecorder records to w.
+func NewRecorder(w io.Writer) *Recorder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // Keep & readable
+	return &Recorder{enc: enc}
+}
+
+// Create records to a new file at path, truncating an existing one. Close
+// the recorder to close the file.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(f)
+	r.c = f
+	return r, nil
+}
+
+// Close closes the file opened by Create. It returns the first error met
+// while recording, if any.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.err
+	if r.c != nil {
+		if cerr := r.c.Close(); err == nil {
+			err = cerr
+		}
+		r.c = nil
+	}
+	return err
+}
+
+// Option records the connections of a Client.
+func (r *Recorder) Option() companytec.Option {
+	return companytec.WithConnWrapper(r.Wrap)
+}
+
+// Wrap returns conn recording the frames written to and read from it.
+func (r *Recorder) Wrap(conn net.Conn) net.Conn {
+	r.mu.Lock()
+	r.conns++
+	id := r.conns
+	r.mu.Unlock()
+	return &recordedConn{Conn: conn, r: r, id: id, addr: conn.RemoteAddr().String()}
+}
+
+// write appends f to the recording. A failed write does not fail the
+// connection; the error is kept for Close.
+func (r *Recorder) write(f Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(f)
+}
+
+// recordedConn records the frames of a connection. Commands are written whole;
+// responses are split on the closing delimiter, since a read can return part
+// of a frame or several frames.
+type recordedConn struct {
+	net.Conn
+	r    *Recorder
+	id   int
+	addr string
+
+	mu   sync.Mutex
+	sent time.Time // When the last command was written
+	rx   []byte    // Received bytes of an incomplete frame
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		now := time.Now()
+		c.mu.Lock()
+		c.sent = now
+		c.mu.Unlock()
+		c.r.write(Frame{Time: now, Conn: c.id, Addr: c.addr, Dir: DirTX, Frame: string(b[:n])})
+	}
+	return n, err
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		now := time.Now()
+		c.mu.Lock()
+		c.rx = append(c.rx, b[:n]...)
+		var frames []Frame
+		for {
+			i := bytes.IndexByte(c.rx, ')')
+			if i < 0 {
+				break
+			}
+			frames = append(frames, c.rxFrame(now, string(c.rx[:i+1])))
+			c.rx = c.rx[i+1:]
+		}
+		c.mu.Unlock()
+		for _, f := range frames {
+			c.r.write(f)
+		}
+	}
+	return n, err
+}
+
+// Close records the bytes of an incomplete frame, if any, before closing.
+func (c *recordedConn) Close() error {
+	c.mu.Lock()
+	rest := c.rx
+	c.rx = nil
+	var f Frame
+	if len(rest) > 0 {
+		f = c.rxFrame(time.Now(), string(rest))
+	}
+	c.mu.Unlock()
+	if len(rest) > 0 {
+		c.r.write(f)
+	}
+	return c.Conn.Close()
+}
+
+// rxFrame builds a received frame. c.mu must be held.
+func (c *recordedConn) rxFrame(now time.Time, frame string) Frame {
+	f := Frame{Time: now, Conn: c.id, Addr: c.addr, Dir: DirRX, Frame: frame}
+	if !c.sent.IsZero() {
+		f.LatencyMs = float64(now.Sub(c.sent)) / float64(time.Millisecond)
+	}
+	return f
+}
+
+// Read reads a recording.
+func Read(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var f Frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if f.Dir != DirTX && f.Dir != DirRX {
+			return nil, fmt.Errorf("line %d: unknown direction %q", line, f.Dir)
+		}
+		frames = append(frames, f)
+	}
+	return frames, scanner.Err()
+}
+
+// ReadFile reads the recording at path.
+func ReadFile(path string) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Exchange is a command of a recording and the response it got.
+type Exchange struct {
+	Conn     int
+	Addr     string
+	Command  string
+	Response string // Empty when the device did not answer
+	Latency  time.Duration
+}
+
+// Exchanges pairs every command of frames with its response: the first frame
+// received after it on the same connection that answers it, as the client
+// checks with companytec.ValidateResponse. Frames that cannot be the response
+// of the command pending, such as the late answer to an earlier command that
+// timed out, and frames received with no command pending are returned in
+// unmatched instead.
+func Exchanges(frames []Frame) (exchanges []Exchange, unmatched []Frame) {
+	pending := make(map[int]int) // Exchange awaiting a response, by connection
+	for _, f := range frames {
+		switch f.Dir {
+		case DirTX:
+			pending[f.Conn] = len(exchanges)
+			exchanges = append(exchanges, Exchange{Conn: f.Conn, Addr: f.Addr, Command: f.Frame})
+		case DirRX:
+			i, ok := pending[f.Conn]
+			if !ok || !answers(exchanges[i].Command, f.Frame) {
+				unmatched = append(unmatched, f)
+				continue
+			}
+			delete(pending, f.Conn)
+			exchanges[i].Response = f.Frame
+			exchanges[i].Latency = time.Duration(f.LatencyMs * float64(time.Millisecond))
+		}
+	}
+	return exchanges, unmatched
+}
+
+// answers reports whether frame can be the response to command. Frames the
+// device garbled or rejected still answer it; frames with the header of
+// another command do not.
+func answers(command, frame string) bool {
+	var perr *companytec.ProtocolError
+	return !errors.As(companytec.ValidateResponse(command, frame), &perr) || perr.Kind != companytec.ErrUnexpectedHeader
+}
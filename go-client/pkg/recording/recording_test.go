@@ -0,0 +1,185 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+	"companytec-client/pkg/simulator"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.New(simulator.Config{})
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	client := companytec.New("simulator:2001", companytec.WithDialer(dialer),
+		companytec.WithTimeout(time.Second), rec.Option())
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Total("01", "L"); err != nil {
+		t.Fatal(err)
+	}
+	client.Disconnect()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := ""
+	for _, f := range frames {
+		dirs += f.Dir + " "
+		if f.Conn != 1 || f.Addr == "" {
+			t.Errorf("frame %+v", f)
+		}
+	}
+	if dirs != "tx rx tx rx " {
+		t.Fatalf("directions %s", dirs)
+	}
+	if frames[0].Frame != "(&S)" || frames[1].LatencyMs <= 0 || frames[0].LatencyMs != 0 {
+		t.Errorf("status exchange %+v, %+v", frames[0], frames[1])
+	}
+
+	exchanges, unmatched := Exchanges(frames)
+	if len(exchanges) != 2 || len(unmatched) != 0 {
+		t.Fatalf("exchanges %+v, unmatched %+v", exchanges, unmatched)
+	}
+	if exchanges[1].Command != client.BuildCommand("&T", "01L") || !strings.HasPrefix(exchanges[1].Response, "(L01") {
+		t.Errorf("totalizer exchange %+v", exchanges[1])
+	}
+}
+
+func TestRecordedConnSplitsFrames(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	client, device := net.Pipe()
+	conn := rec.Wrap(client)
+	go func() {
+		device.Write([]byte("(S"))
+		device.Write([]byte("LL)(0)(L0"))
+		device.Close()
+	}()
+
+	b := make([]byte, 64)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			break
+		}
+	}
+	conn.Close()
+
+	frames, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range frames {
+		got = append(got, f.Frame)
+	}
+	// The incomplete frame is recorded on close
+	if strings.Join(got, " ") != "(SLL) (0) (L0" {
+		t.Errorf("frames %q", got)
+	}
+}
+
+func TestExchanges(t *testing.T) {
+	total := companytec.New("").BuildCommand("&T", "01L")
+	frames := []Frame{
+		{Conn: 1, Dir: DirRX, Frame: "(SLL)"}, // Before any command
+		{Conn: 1, Dir: DirTX, Frame: total},   // Times out
+		{Conn: 1, Dir: DirTX, Frame: "(&S)"},
+		{Conn: 1, Dir: DirRX, Frame: "(L0100012345678D)"}, // Late answer to &T
+		{Conn: 2, Dir: DirTX, Frame: "(&S)"},
+		{Conn: 1, Dir: DirRX, Frame: "(SLL)", LatencyMs: 40},
+		{Conn: 2, Dir: DirRX, Frame: "(SEL)"},
+		{Conn: 2, Dir: DirTX, Frame: total},
+		{Conn: 2, Dir: DirRX, Frame: "(0)"}, // Rejected, still the answer
+		{Conn: 2, Dir: DirRX, Frame: "(SEL)"},
+	}
+	exchanges, unmatched := Exchanges(frames)
+
+	want := []Exchange{
+		{Conn: 1, Command: total},
+		{Conn: 1, Command: "(&S)", Response: "(SLL)", Latency: 40 * time.Millisecond},
+		{Conn: 2, Command: "(&S)", Response: "(SEL)"},
+		{Conn: 2, Command: total, Response: "(0)"},
+	}
+	if len(exchanges) != len(want) {
+		t.Fatalf("exchanges %+v", exchanges)
+	}
+	for i := range want {
+		if exchanges[i] != want[i] {
+			t.Errorf("exchange %d: %+v, want %+v", i, exchanges[i], want[i])
+		}
+	}
+	if len(unmatched) != 3 || unmatched[0] != frames[0] || unmatched[1] != frames[3] || unmatched[2] != frames[9] {
+		t.Errorf("unmatched %+v", unmatched)
+	}
+}
+
+func TestRead(t *testing.T) {
+	frames, err := Read(strings.NewReader(`{"conn":1,"dir":"tx","frame":"(&S)"}` + "\n\n" + `{"conn":1,"dir":"rx","frame":"(SL)"}` + "\n"))
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("frames %+v, %v", frames, err)
+	}
+	if _, err := Read(strings.NewReader(`{"dir":"tx"}` + "\n" + `{"dir":"up"}`)); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("unknown direction: %v", err)
+	}
+}
+
+func TestPlayer(t *testing.T) {
+	p := NewPlayer([]Exchange{
+		{Command: "(&S)", Response: "(SEL)"},
+		{Command: "(&S)", Response: "(SAL)"},
+		{Command: "(&V)"}, // Not answered
+	}, PlayerConfig{})
+
+	for _, want := range []string{"(SEL)", "(SAL)", "(SAL)"} {
+		if got := p.Handle("(&S)"); got != want {
+			t.Errorf("status %q, want %q", got, want)
+		}
+	}
+	if got := p.Handle("(&V)"); got != "" {
+		t.Errorf("unanswered command got %q", got)
+	}
+	if got := p.Handle("(&R)"); got != "(0)" {
+		t.Errorf("unknown command got %q", got)
+	}
+	if st := p.Stats(); st != (PlayerStats{Served: 4, Unknown: 1}) {
+		t.Errorf("stats %+v", st)
+	}
+
+	// A client reads the recording over a connection
+	addr, err := p.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	client := companytec.New(addr, companytec.WithTimeout(time.Second))
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	if statuses, err := client.Status(); err != nil || statuses[0].Code != companytec.StatusRefueling {
+		t.Errorf("status %+v, %v", statuses, err)
+	}
+}
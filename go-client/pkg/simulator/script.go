@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Action is a scripted event on a nozzle.
+type Action string
+
+const (
+	ActionLift      Action = "lift"
+	ActionAuthorize Action = "authorize"
+	ActionDispense  Action = "dispense"
+	ActionHang      Action = "hang"
+)
+
+// Step is one scripted event, applied after waiting Delay.
+type Step struct {
+	Delay  time.Duration
+	Action Action
+	Nozzle string
+	Volume int // Extra volume for ActionDispense, in hundredths of a liter
+}
+
+// Fueling returns the steps of a complete supply on a nozzle: lift (L→E),
+// authorize (E→A), dispense for the given duration and hang up (A→C).
+func Fueling(nozzle string, duration time.Duration) []Step {
+	return []Step{
+		{Action: ActionLift, Nozzle: nozzle},
+		{Delay: 500 * time.Millisecond, Action: ActionAuthorize, Nozzle: nozzle},
+		{Delay: duration, Action: ActionHang, Nozzle: nozzle},
+	}
+}
+
+// RunScript applies steps in order until they are exhausted or ctx is done.
+func (s *Simulator) RunScript(ctx context.Context, steps []Step) error {
+	for i, step := range steps {
+		if step.Delay > 0 {
+			t := time.NewTimer(step.Delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+		if err := s.apply(step); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) apply(step Step) error {
+	switch step.Action {
+	case ActionLift:
+		return s.Lift(step.Nozzle)
+	case ActionAuthorize:
+		return s.Authorize(step.Nozzle)
+	case ActionDispense:
+		return s.Dispense(step.Nozzle, step.Volume)
+	case ActionHang:
+		return s.Hang(step.Nozzle)
+	}
+	return fmt.Errorf("simulator: unknown action %q", step.Action)
+}
+
+// RunDemo fuels a random nozzle every interval until ctx is done. Nozzles that
+// are busy or blocked are skipped.
+func (s *Simulator) RunDemo(ctx context.Context, interval time.Duration) error {
+	s.mu.Lock()
+	codes := make([]string, 0, len(s.nozzles))
+	for _, n := range s.sortedNozzles() {
+		codes = append(codes, n.code)
+	}
+	s.mu.Unlock()
+	if len(codes) == 0 {
+		return fmt.Errorf("simulator: no nozzles configured")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		code := codes[rand.IntN(len(codes))]
+		duration := time.Duration(5+rand.IntN(20)) * time.Second
+		go s.RunScript(ctx, Fueling(code, duration))
+	}
+}
@@ -0,0 +1,1036 @@
+// Package simulator implements a fake Companytec concentrator that speaks the
+// same framed TCP protocol as the real device. It is meant for tests, demos
+// and development without hardware.
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// NozzleConfig describes a nozzle installed on the simulated device.
+type NozzleConfig struct {
+	Code        string // Two hex characters, e.g. "01"
+	Prices      []int  // Unit price per level (0=cash, 1=credit, 2=debit), 4 digits
+	VolumeTotal int64  // Initial volume totalizer
+	ValueTotal  int64  // Initial value totalizer
+}
+
+// Config configures a Simulator. Zero values get sensible defaults.
+type Config struct {
+	Nozzles []NozzleConfig
+	// FlowRate is the volume dispensed per second, in hundredths of a liter.
+	FlowRate int
+	// AutoAuthorize starts dispensing as soon as a nozzle is lifted instead of
+	// waiting in E for an &M A or &P authorization.
+	AutoAuthorize bool
+	// MemorySize is the number of supplies kept in the ring buffer, at most
+	// 10000 so every position is addressable by &L.
+	MemorySize int
+	// Now returns the host time the device clock is derived from.
+	Now func() time.Time
+	// ClockOffset is the initial drift of the device clock from Now.
+	ClockOffset time.Duration
+	// Identified makes &A and &L answer in the identified format (75 chars),
+	// as a device with identification enabled does.
+	Identified bool
+}
+
+func (cfg *Config) setDefaults() {
+	if len(cfg.Nozzles) == 0 {
+		for i := 1; i <= 4; i++ {
+			cfg.Nozzles = append(cfg.Nozzles, NozzleConfig{
+				Code:   fmt.Sprintf("%02X", i),
+				Prices: []int{5890, 6090},
+			})
+		}
+	}
+	if cfg.FlowRate <= 0 {
+		cfg.FlowRate = 50
+	}
+	if cfg.MemorySize <= 0 {
+		cfg.MemorySize = 1000
+	}
+	cfg.MemorySize = min(cfg.MemorySize, 10000)
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+}
+
+// nozzle is the live state of a simulated nozzle.
+type nozzle struct {
+	code        string
+	position    int
+	status      companytec.StatusCode
+	blocked     bool
+	prices      []int
+	volumeTotal int64
+	valueTotal  int64
+
+	authorized bool // Released for a single supply by &M A or &P
+	preset     int  // Maximum value of the current supply, 0 for none
+	volume     int  // Volume dispensed in the current supply
+	started    time.Time
+	flowed     time.Time // Last time the flow was accounted for
+	finishSeen bool      // C status was reported once by &S
+
+	// Identification of the current supply
+	attendant string
+	customer  string
+	odometer  int
+}
+
+func (n *nozzle) value() int {
+	return n.volume * n.prices[0] / 1000
+}
+
+// Simulator is a fake concentrator. Its methods are safe for concurrent use.
+type Simulator struct {
+	cfg Config
+
+	mu          sync.Mutex
+	nozzles     map[string]*nozzle
+	memory      []companytec.SupplyRecord
+	written     int // Supplies written since start
+	read        int // Supplies acknowledged with &I
+	record      int // Record counter of the last supply
+	clockOffset time.Duration
+	tag         string // Identifier presented to the reader, if any
+	identifiers map[int]companytec.IdentifierRecord
+	blacklist   map[string]bool
+
+	lnMu      sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+}
+
+// New creates a Simulator.
+func New(cfg Config) *Simulator {
+	cfg.setDefaults()
+	s := &Simulator{
+		cfg:         cfg,
+		clockOffset: cfg.ClockOffset,
+		nozzles:     make(map[string]*nozzle),
+		memory:      make([]companytec.SupplyRecord, cfg.MemorySize),
+		identifiers: make(map[int]companytec.IdentifierRecord),
+		blacklist:   make(map[string]bool),
+		conns:       make(map[net.Conn]struct{}),
+	}
+	for _, nc := range cfg.Nozzles {
+		code := strings.ToUpper(nc.Code)
+		pos, err := strconv.ParseInt(code, 16, 32)
+		if err != nil || pos < 1 || pos > 32 {
+			continue
+		}
+		prices := append([]int(nil), nc.Prices...)
+		for len(prices) < 3 {
+			if len(prices) == 0 {
+				prices = append(prices, 0)
+				continue
+			}
+			prices = append(prices, prices[len(prices)-1])
+		}
+		s.nozzles[code] = &nozzle{
+			code:        code,
+			position:    int(pos),
+			status:      companytec.StatusAvailable,
+			prices:      prices,
+			volumeTotal: nc.VolumeTotal,
+			valueTotal:  nc.ValueTotal,
+		}
+	}
+	return s
+}
+
+// ListenAndServe listens on addr and serves connections until Close is called.
+func (s *Simulator) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Start listens on addr (":0" picks a free port) and serves in the
+// background. It returns the address actually listened on.
+func (s *Simulator) Start(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go s.Serve(l)
+	return l.Addr().String(), nil
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Simulator) Serve(l net.Listener) error {
+	s.lnMu.Lock()
+	s.listeners = append(s.listeners, l)
+	s.lnMu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers commands on a single connection until it is closed. It
+// can be used with one end of a net.Pipe.
+func (s *Simulator) ServeConn(conn net.Conn) {
+	s.lnMu.Lock()
+	s.conns[conn] = struct{}{}
+	s.lnMu.Unlock()
+	defer func() {
+		s.lnMu.Lock()
+		delete(s.conns, conn)
+		s.lnMu.Unlock()
+		conn.Close()
+	}()
+
+	// Line noise between frames is dropped by the decoder
+	dec := companytec.NewFrameDecoder(conn, 0)
+	for {
+		command, err := dec.Next()
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(s.Handle(command))); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops every listener and drops every connection.
+func (s *Simulator) Close() error {
+	s.lnMu.Lock()
+	defer s.lnMu.Unlock()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.listeners = nil
+	return nil
+}
+
+// Handle answers a single command frame.
+func (s *Simulator) Handle(command string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(command) < 3 || command[0] != '(' || command[len(command)-1] != ')' {
+		return "(0)"
+	}
+	checked := companytec.HasChecksum(command)
+	body := command[1 : len(command)-1]
+	if checked {
+		body = body[:len(body)-2]
+	}
+
+	s.advanceLocked()
+
+	switch {
+	case body == "&S":
+		return s.statusLocked()
+	case body == "&V":
+		return s.visualizationLocked()
+	case body == "&A":
+		return s.supplyLocked(checked)
+	case strings.HasPrefix(body, "&L"):
+		return s.supplyPointerLocked(body[2:], checked)
+	case body == "&A2":
+		return s.supplyPAFLocked(1, checked)
+	case body == "&A3":
+		return s.supplyPAFLocked(2, checked)
+	case body == "&@":
+		return s.supplyDualLocked(checked)
+	case body == "&I":
+		return s.incrementLocked(command)
+	case body == "&R":
+		return s.calendarLocked()
+	case body == "&KR1":
+		return s.clockExtendedLocked(checked)
+	case strings.HasPrefix(body, "&H"):
+		return s.setCalendarLocked(body[2:], command)
+	case strings.HasPrefix(body, "&KW1"):
+		return s.setClockExtendedLocked(body[4:], command)
+	case strings.HasPrefix(body, "&T"):
+		return s.totalLocked(body[2:], checked)
+	case strings.HasPrefix(body, "&U"):
+		return s.changePriceLocked(body[2:], command)
+	case strings.HasPrefix(body, "&P"):
+		return s.presetLocked(body[2:], command)
+	case strings.HasPrefix(body, "&M99"):
+		return s.blacklistLocked(body[4:], command)
+	case strings.HasPrefix(body, "&M"):
+		return s.modeLocked(body[2:], command)
+	case body == "?A":
+		return s.identifierLocked(checked)
+	case body == "?I":
+		return s.incrementIdentifierLocked(command)
+	case strings.HasPrefix(body, "?LF"):
+		return s.identifierMemoryLocked(body[3:], checked)
+	case strings.HasPrefix(body, "?F"):
+		return s.identifierCommandLocked(body[2:], command)
+	}
+	return "(0)"
+}
+
+// frame wraps a response body in delimiters, adding a checksum when the
+// command carried one.
+func frame(body string, checked bool) string {
+	if checked {
+		body = "(" + body
+		return body + companytec.Checksum(body) + ")"
+	}
+	return "(" + body + ")"
+}
+
+// now returns the device clock.
+func (s *Simulator) now() time.Time {
+	return s.cfg.Now().Add(s.clockOffset)
+}
+
+// advanceLocked accounts for the flow of every dispensing nozzle and finishes
+// supplies that reached their preset.
+func (s *Simulator) advanceLocked() {
+	now := s.cfg.Now()
+	for _, n := range s.nozzles {
+		if n.status != companytec.StatusRefueling {
+			continue
+		}
+		elapsed := now.Sub(n.flowed)
+		flow := int(elapsed.Seconds() * float64(s.cfg.FlowRate))
+		if flow <= 0 {
+			continue
+		}
+		n.volume += flow
+		n.flowed = now
+		if n.preset > 0 && n.value() >= n.preset {
+			// Trim the volume so the value matches the preset
+			if n.prices[0] > 0 {
+				n.volume = n.preset * 1000 / n.prices[0]
+			}
+			s.finishLocked(n)
+		}
+	}
+}
+
+func (s *Simulator) sortedNozzles() []*nozzle {
+	list := make([]*nozzle, 0, len(s.nozzles))
+	for _, n := range s.nozzles {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].position < list[j].position })
+	return list
+}
+
+func (s *Simulator) statusLocked() string {
+	codes := []byte(strings.Repeat(string(companytec.StatusNotPresent), 32))
+	for _, n := range s.nozzles {
+		codes[n.position-1] = n.status[0]
+		// A finished supply is reported once, then the nozzle is free again
+		if n.status == companytec.StatusFinished {
+			if n.finishSeen {
+				s.resetLocked(n)
+				codes[n.position-1] = n.status[0]
+			} else {
+				n.finishSeen = true
+			}
+		}
+	}
+	return "(S" + string(codes) + ")"
+}
+
+func (s *Simulator) visualizationLocked() string {
+	var b strings.Builder
+	for _, n := range s.sortedNozzles() {
+		if n.status == companytec.StatusRefueling {
+			fmt.Fprintf(&b, "%s%06d", n.code, n.value()%1000000)
+		}
+	}
+	if b.Len() == 0 {
+		return "(0)"
+	}
+	return "(" + b.String() + ")"
+}
+
+// pendingLocked returns the number of supplies not yet acknowledged.
+func (s *Simulator) pendingLocked() int {
+	return s.written - s.read
+}
+
+func (s *Simulator) supplyLocked(checked bool) string {
+	if s.pendingLocked() == 0 {
+		return "(0)"
+	}
+	rec := s.memory[s.read%len(s.memory)]
+	return frame(s.formatSupply(rec), checked)
+}
+
+func (s *Simulator) incrementLocked(command string) string {
+	if s.pendingLocked() == 0 {
+		return "(0)"
+	}
+	s.read++
+	return command
+}
+
+// supplyPointerLocked handles &L<mode><position>: C reads a stored supply,
+// R also moves the read pointer to it.
+func (s *Simulator) supplyPointerLocked(params string, checked bool) string {
+	if len(params) != 5 {
+		return "(0)"
+	}
+	pos, err := strconv.Atoi(params[1:])
+	size := len(s.memory)
+	if err != nil || pos < 0 || pos >= size {
+		return "(0)"
+	}
+	// Counter of the most recent supply stored at pos, if still in memory
+	last := s.written - 1 - ((s.written-1-pos)%size+size)%size
+	stored := last >= 0 && last >= s.written-size
+
+	switch params[0] {
+	case 'C':
+	case 'R':
+		switch {
+		case stored:
+			s.read = last
+		case pos == s.written%size:
+			s.read = s.written
+		default:
+			return "(0)"
+		}
+	default:
+		return "(0)"
+	}
+	if !stored {
+		return "(0)"
+	}
+	return frame(s.formatSupply(s.memory[pos]), checked)
+}
+
+// formatSupply is the inverse of companytec.ParseSupply, in the identified
+// format when configured.
+func (s *Simulator) formatSupply(rec companytec.SupplyRecord) string {
+	body := fmt.Sprintf("%06d%06d%04d%2s%04d%2s%02d%02d%02d%02d%04d%010d%2s",
+		rec.TotalToPay%1000000, rec.Volume%1000000, rec.Price%10000, rec.CommaCode,
+		rec.SupplyTime%10000, rec.Nozzle, rec.Day, rec.Hour, rec.Minute, rec.Month,
+		rec.Record%10000, rec.FinalTotal%10000000000, rec.Status)
+	if s.cfg.Identified {
+		body += formatTag(presentedTag(rec)) + fmt.Sprintf("%07d", rec.Odometer%10000000)
+	}
+	return body
+}
+
+// presentedTag returns the tag reported by the single identification
+// formats: whichever identifier was presented.
+func presentedTag(rec companytec.SupplyRecord) string {
+	switch {
+	case rec.Tag != "":
+		return rec.Tag
+	case rec.AttendantTag != "":
+		return rec.AttendantTag
+	}
+	return rec.CustomerTag
+}
+
+// formatSupplyDual is the inverse of companytec.ParseSupplyDual.
+func formatSupplyDual(rec companytec.SupplyRecord) string {
+	return fmt.Sprintf("%06d%06d%04d%2s%04d%2s%02d%02d%02d%02d%010d%2s%s%s%07d",
+		rec.TotalToPay%1000000, rec.Volume%1000000, rec.Price%10000, rec.CommaCode,
+		rec.SupplyTime%10000, rec.Nozzle, rec.Day, rec.Hour, rec.Minute, rec.Month,
+		rec.FinalTotal%10000000000, rec.Status,
+		formatTag(rec.AttendantTag), formatTag(rec.CustomerTag), rec.Odometer%10000000)
+}
+
+// supplyPAFLocked answers &A2 (PAF1) and &A3 (PAF2).
+func (s *Simulator) supplyPAFLocked(format int, checked bool) string {
+	if s.pendingLocked() == 0 {
+		return "(0)"
+	}
+	rec := s.memory[s.read%len(s.memory)]
+	return frame(s.formatSupplyPAF(rec, format), checked)
+}
+
+// formatSupplyPAF is the inverse of companytec.ParseSupplyPAF. The fiscal
+// fields are derived from the stored supply: the start totalizer from the
+// final one and the volume, the start time from the supply time.
+func (s *Simulator) formatSupplyPAF(rec companytec.SupplyRecord, format int) string {
+	now := s.now()
+	end := time.Date(now.Year(), time.Month(rec.Month), rec.Day, rec.Hour, rec.Minute, 0, 0, now.Location())
+	if end.After(now) {
+		end = end.AddDate(-1, 0, 0)
+	}
+	start := end.Add(-time.Duration(rec.SupplyTime) * time.Second)
+	stamp := func(t time.Time) string {
+		return fmt.Sprintf("%02d%02d%02d%02d%02d%02d", t.Year()%100, int(t.Month()), t.Day(), t.Hour(), t.Minute(), t.Second())
+	}
+
+	modulus, digits := int64(10000000000), 10
+	if format == 2 {
+		modulus, digits = 1000000000000, 12
+	}
+	endTotal := rec.FinalTotal % modulus
+	startTotal := ((endTotal-int64(rec.Volume))%modulus + modulus) % modulus
+
+	pos, _ := strconv.ParseInt(rec.Nozzle, 16, 32)
+	pump := (int(pos) + 1) / 2
+	return fmt.Sprintf("%06d%06d%04d%2s%04d%2s%s%s%04d%0*d%0*d%2s%02d%02d%-20s%s%05d",
+		rec.TotalToPay%1000000, rec.Volume%1000000, rec.Price%10000, rec.CommaCode,
+		rec.SupplyTime%10000, rec.Nozzle, stamp(start), stamp(end), rec.Record%10000,
+		digits, startTotal, digits, endTotal, rec.Status,
+		int(pos)%100, pump%100, fmt.Sprintf("SIM%017d", pump), formatTag(presentedTag(rec)), rec.Record%100000)
+}
+
+// formatTag pads a missing identifier with zeros.
+func formatTag(tag string) string {
+	if tag == "" {
+		return strings.Repeat("0", 16)
+	}
+	return tag
+}
+
+func (s *Simulator) supplyDualLocked(checked bool) string {
+	if s.pendingLocked() == 0 {
+		return "(0)"
+	}
+	rec := s.memory[s.read%len(s.memory)]
+	return frame(formatSupplyDual(rec), checked)
+}
+
+func (s *Simulator) calendarLocked() string {
+	t := s.now()
+	return fmt.Sprintf("(%02d%02d%02d%02d)", t.Day(), t.Hour(), t.Minute(), int(t.Month()))
+}
+
+func (s *Simulator) clockExtendedLocked(checked bool) string {
+	t := s.now()
+	body := fmt.Sprintf("%02d%02d%02d%02d%02d%02d%02d", t.Year()%100, int(t.Month()), t.Day(),
+		int(t.Weekday())+1, t.Hour(), t.Minute(), t.Second())
+	return frame(body, checked)
+}
+
+// setCalendarLocked handles &H<DDHHMM>, keeping the current month and year.
+func (s *Simulator) setCalendarLocked(params, command string) string {
+	f, ok := digits(params, 3)
+	if !ok {
+		return "(0)"
+	}
+	now := s.now()
+	t := time.Date(now.Year(), now.Month(), f[0], f[1], f[2], 0, 0, now.Location())
+	if t.Day() != f[0] || f[1] > 23 || f[2] > 59 {
+		return "(0)"
+	}
+	s.clockOffset = t.Sub(s.cfg.Now())
+	return command
+}
+
+// setClockExtendedLocked handles &KW1<YYMMDDWWHHMMSS>. The weekday is
+// ignored, as the date determines it.
+func (s *Simulator) setClockExtendedLocked(params, command string) string {
+	f, ok := digits(params, 7)
+	if !ok {
+		return "(0)"
+	}
+	loc := s.now().Location()
+	t := time.Date(2000+f[0], time.Month(f[1]), f[2], f[4], f[5], f[6], 0, loc)
+	if int(t.Month()) != f[1] || t.Day() != f[2] || f[4] > 23 || f[5] > 59 || f[6] > 59 {
+		return "(0)"
+	}
+	s.clockOffset = t.Sub(s.cfg.Now())
+	return command
+}
+
+// digits splits s into n two digit numbers.
+func digits(s string, n int) ([]int, bool) {
+	if len(s) != 2*n {
+		return nil, false
+	}
+	f := make([]int, n)
+	for i := range f {
+		v, err := strconv.Atoi(s[2*i : 2*i+2])
+		if err != nil || v < 0 {
+			return nil, false
+		}
+		f[i] = v
+	}
+	return f, true
+}
+
+func (s *Simulator) totalLocked(params string, checked bool) string {
+	if len(params) != 3 {
+		return "(0)"
+	}
+	code, mode := strings.ToUpper(params[:2]), params[2:]
+	if code == "99" && mode == "P" {
+		// Memory pointers: write and read positions in the ring buffer
+		size := len(s.memory)
+		return frame(fmt.Sprintf("P99%04d%04d", s.written%size, s.read%size), checked)
+	}
+
+	n, ok := s.nozzles[code]
+	if !ok {
+		return "(0)"
+	}
+	switch mode {
+	case "L":
+		return frame(fmt.Sprintf("L%s%010d", n.code, n.volumeTotal%10000000000), checked)
+	case "$":
+		return frame(fmt.Sprintf("$%s%010d", n.code, n.valueTotal%10000000000), checked)
+	case "U":
+		return frame(fmt.Sprintf("U%s%04d%04d", n.code, n.prices[0]%10000, n.prices[1]%10000), checked)
+	case "u":
+		return frame(fmt.Sprintf("u%s%06d%06d%06d", n.code, n.prices[0], n.prices[1], n.prices[2]), checked)
+	}
+	return "(0)"
+}
+
+// changePriceLocked handles &U<nozzle><level>0<price>.
+func (s *Simulator) changePriceLocked(params, command string) string {
+	if len(params) != 8 && len(params) != 10 {
+		return "(0)"
+	}
+	n, ok := s.nozzles[strings.ToUpper(params[:2])]
+	if !ok {
+		return "(0)"
+	}
+	level, err := strconv.Atoi(params[2:3])
+	if err != nil || level > 2 {
+		return "(0)"
+	}
+	price, err := strconv.Atoi(params[4:])
+	if err != nil {
+		return "(0)"
+	}
+	n.prices[level] = price
+	return command
+}
+
+// presetLocked handles &P<nozzle><value>.
+func (s *Simulator) presetLocked(params, command string) string {
+	if len(params) != 8 {
+		return "(0)"
+	}
+	n, ok := s.nozzles[strings.ToUpper(params[:2])]
+	if !ok || n.blocked {
+		return "(0)"
+	}
+	value, err := strconv.Atoi(params[2:])
+	if err != nil {
+		return "(0)"
+	}
+	if n.status != companytec.StatusAvailable && n.status != companytec.StatusWaiting {
+		return "(0)"
+	}
+	n.preset = value
+	s.authorizeLocked(n)
+	return command
+}
+
+// modeLocked handles &M<nozzle><mode>.
+func (s *Simulator) modeLocked(params, command string) string {
+	if len(params) != 3 {
+		return "(0)"
+	}
+	n, ok := s.nozzles[strings.ToUpper(params[:2])]
+	if !ok {
+		return "(0)"
+	}
+	switch params[2] {
+	case 'L':
+		n.blocked = false
+		if n.status == companytec.StatusBlocked {
+			n.status = companytec.StatusAvailable
+		}
+	case 'B':
+		n.blocked = true
+		if n.status == companytec.StatusAvailable || n.status == companytec.StatusReady {
+			n.status = companytec.StatusBlocked
+			n.authorized = false
+		}
+	case 'A':
+		if n.blocked {
+			return "(0)"
+		}
+		s.authorizeLocked(n)
+	case 'S':
+		if n.status == companytec.StatusRefueling {
+			s.finishLocked(n)
+		}
+	case 'P', 'H', 'I':
+		// Pause and sensor control are accepted but not simulated
+	default:
+		return "(0)"
+	}
+	return command
+}
+
+func (s *Simulator) identifierLocked(checked bool) string {
+	if s.tag == "" {
+		return "(0)"
+	}
+	return frame(s.tag, checked)
+}
+
+func (s *Simulator) incrementIdentifierLocked(command string) string {
+	if s.tag == "" {
+		return "(0)"
+	}
+	s.tag = ""
+	return command
+}
+
+// identifierMemoryLocked handles ?LF<position>.
+func (s *Simulator) identifierMemoryLocked(params string, checked bool) string {
+	pos, err := strconv.Atoi(params)
+	if err != nil {
+		return "(0)"
+	}
+	rec, ok := s.identifiers[pos]
+	if !ok {
+		return "(0)"
+	}
+	return frame(formatIdentifier(rec), checked)
+}
+
+func formatIdentifier(rec companytec.IdentifierRecord) string {
+	return rec.Control + rec.Parameter + rec.ID +
+		rec.ShiftA.Start + rec.ShiftA.End + rec.ShiftB.Start + rec.ShiftB.End
+}
+
+// identifierCommandLocked handles the ?F family. The third character selects
+// the operation: L clears the memory, A deletes, P is an identified preset and
+// anything else records an identifier.
+func (s *Simulator) identifierCommandLocked(params, command string) string {
+	if len(params) < 3 {
+		return "(0)"
+	}
+	switch params[2] {
+	case 'L':
+		clear(s.identifiers)
+		return command
+	case 'A':
+		// <control>A<id>00<position>00000000
+		if len(params) != 35 {
+			return "(0)"
+		}
+		id := params[3:19]
+		pos, err := strconv.Atoi(params[21:27])
+		if err != nil {
+			return "(0)"
+		}
+		deleted := false
+		for p, rec := range s.identifiers {
+			if rec.ID == id && (pos == 0 || p == pos) {
+				delete(s.identifiers, p)
+				deleted = true
+			}
+		}
+		if !deleted {
+			return "(0)"
+		}
+		return command
+	case 'P':
+		return s.presetIdentifiedLocked(params, command)
+	}
+
+	// <control><parameter><id><shift A start/end><shift B start/end>
+	if len(params) != 35 {
+		return "(0)"
+	}
+	rec := companytec.IdentifierRecord{
+		Control:   params[0:2],
+		Parameter: params[2:3],
+		ID:        params[3:19],
+		ShiftA:    companytec.Shift{Start: params[19:23], End: params[23:27]},
+		ShiftB:    companytec.Shift{Start: params[27:31], End: params[31:35]},
+	}
+	// Re-recording an identifier updates it in place, new ones take the first
+	// free position
+	pos := 0
+	for p, existing := range s.identifiers {
+		if existing.ID == rec.ID {
+			pos = p
+			break
+		}
+	}
+	if pos == 0 {
+		for pos = 1; ; pos++ {
+			if _, taken := s.identifiers[pos]; !taken {
+				break
+			}
+		}
+	}
+	s.identifiers[pos] = rec
+	return command
+}
+
+// presetIdentifiedLocked handles
+// ?F<nozzle>P<id><type><authorization><value><timeout><preset type>00000.
+func (s *Simulator) presetIdentifiedLocked(params, command string) string {
+	if len(params) != 35 {
+		return "(0)"
+	}
+	n, ok := s.nozzles[strings.ToUpper(params[:2])]
+	if !ok || n.blocked || params[20] != 'S' || s.blacklist[strings.ToUpper(params[3:19])] {
+		return "(0)"
+	}
+	value, err := strconv.Atoi(params[21:27])
+	if err != nil {
+		return "(0)"
+	}
+	if params[29] == 'V' {
+		// Volume preset, convert to value at the cash price
+		value = value * n.prices[0] / 1000
+	}
+	if n.status != companytec.StatusAvailable && n.status != companytec.StatusWaiting {
+		return "(0)"
+	}
+	n.preset = value
+	switch params[19] {
+	case '0':
+		n.attendant = strings.ToUpper(params[3:19])
+	case '1':
+		n.customer = strings.ToUpper(params[3:19])
+	}
+	s.authorizeLocked(n)
+	return command
+}
+
+// blacklistLocked handles &M99 c (clear), b<id> (add) and l<id> (remove).
+func (s *Simulator) blacklistLocked(params, command string) string {
+	switch {
+	case params == "c":
+		clear(s.blacklist)
+	case len(params) == 17 && params[0] == 'b':
+		s.blacklist[strings.ToUpper(params[1:])] = true
+	case len(params) == 17 && params[0] == 'l':
+		delete(s.blacklist, strings.ToUpper(params[1:]))
+	default:
+		return "(0)"
+	}
+	return command
+}
+
+// authorizeLocked releases a nozzle for one supply: a lifted nozzle starts
+// dispensing, an idle one becomes ready.
+func (s *Simulator) authorizeLocked(n *nozzle) {
+	switch n.status {
+	case companytec.StatusWaiting:
+		s.startLocked(n)
+	case companytec.StatusAvailable:
+		n.authorized = true
+		n.status = companytec.StatusReady
+	}
+}
+
+func (s *Simulator) startLocked(n *nozzle) {
+	now := s.cfg.Now()
+	n.status = companytec.StatusRefueling
+	n.authorized = false
+	n.volume = 0
+	n.started = now
+	n.flowed = now
+}
+
+// finishLocked ends the current supply and stores it in memory.
+func (s *Simulator) finishLocked(n *nozzle) {
+	value := n.value()
+	n.volumeTotal += int64(n.volume)
+	n.valueTotal += int64(value)
+	n.status = companytec.StatusFinished
+	n.finishSeen = false
+	n.preset = 0
+
+	s.record = s.record%9999 + 1
+	t := s.now()
+	rec := companytec.SupplyRecord{
+		TotalToPay: value,
+		Volume:     n.volume,
+		Price:      n.prices[0],
+		CommaCode:  "00",
+		SupplyTime: int(s.cfg.Now().Sub(n.started).Seconds()),
+		Nozzle:     n.code,
+		Day:        t.Day(),
+		Hour:       t.Hour(),
+		Minute:     t.Minute(),
+		Month:      int(t.Month()),
+		Record:     s.record,
+		FinalTotal: n.volumeTotal,
+		Status:     "00",
+
+		AttendantTag: n.attendant,
+		CustomerTag:  n.customer,
+		Odometer:     n.odometer,
+	}
+	n.attendant, n.customer, n.odometer = "", "", 0
+	s.memory[s.written%len(s.memory)] = rec
+	s.written++
+	// The ring buffer overwrites the oldest unread supply when full
+	if s.pendingLocked() > len(s.memory) {
+		s.read = s.written - len(s.memory)
+	}
+}
+
+// resetLocked returns a finished nozzle to its idle state.
+func (s *Simulator) resetLocked(n *nozzle) {
+	n.volume = 0
+	n.finishSeen = false
+	if n.blocked {
+		n.status = companytec.StatusBlocked
+	} else {
+		n.status = companytec.StatusAvailable
+	}
+}
+
+func (s *Simulator) lookup(code string) (*nozzle, error) {
+	n, ok := s.nozzles[strings.ToUpper(code)]
+	if !ok {
+		return nil, fmt.Errorf("simulator: unknown nozzle %q", code)
+	}
+	return n, nil
+}
+
+// Identify attaches attendant and customer identifiers and an odometer
+// reading to the next supply of a nozzle. Empty tags are left unset.
+func (s *Simulator) Identify(code, attendant, customer string, odometer int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.lookup(code)
+	if err != nil {
+		return err
+	}
+	if attendant != "" {
+		n.attendant = strings.ToUpper(attendant)
+	}
+	if customer != "" {
+		n.customer = strings.ToUpper(customer)
+	}
+	n.odometer = odometer
+	return nil
+}
+
+// Lift simulates the attendant lifting a nozzle: L becomes E (or A when the
+// nozzle was pre-authorized or AutoAuthorize is set).
+func (s *Simulator) Lift(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.lookup(code)
+	if err != nil {
+		return err
+	}
+	if n.status == companytec.StatusFinished {
+		s.resetLocked(n)
+	}
+	switch {
+	case n.status == companytec.StatusReady || (n.status == companytec.StatusAvailable && s.cfg.AutoAuthorize):
+		s.startLocked(n)
+	case n.status == companytec.StatusAvailable:
+		n.status = companytec.StatusWaiting
+	default:
+		return fmt.Errorf("simulator: nozzle %s cannot be lifted in status %s", n.code, n.status)
+	}
+	return nil
+}
+
+// Authorize releases a nozzle for one supply, like &M A.
+func (s *Simulator) Authorize(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.lookup(code)
+	if err != nil {
+		return err
+	}
+	if n.blocked {
+		return fmt.Errorf("simulator: nozzle %s is blocked", n.code)
+	}
+	s.authorizeLocked(n)
+	return nil
+}
+
+// Dispense adds volume (hundredths of a liter) to a dispensing nozzle on top
+// of the configured flow.
+func (s *Simulator) Dispense(code string, volume int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.lookup(code)
+	if err != nil {
+		return err
+	}
+	if n.status != companytec.StatusRefueling {
+		return fmt.Errorf("simulator: nozzle %s is not dispensing", n.code)
+	}
+	s.advanceLocked()
+	n.volume += volume
+	return nil
+}
+
+// Hang simulates the nozzle being hung up: a dispensing nozzle finishes (C)
+// and its supply is stored; a waiting one goes back to L.
+func (s *Simulator) Hang(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.lookup(code)
+	if err != nil {
+		return err
+	}
+	switch n.status {
+	case companytec.StatusRefueling:
+		s.advanceLocked()
+		if n.status == companytec.StatusRefueling {
+			s.finishLocked(n)
+		}
+	case companytec.StatusWaiting:
+		s.resetLocked(n)
+	default:
+		return fmt.Errorf("simulator: nozzle %s is not lifted", n.code)
+	}
+	return nil
+}
+
+// PresentTag simulates an identifier being read; ?A returns it until ?I.
+func (s *Simulator) PresentTag(tag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tag = strings.ToUpper(tag)
+}
+
+// Blacklist returns the blacklisted identifiers, sorted.
+func (s *Simulator) Blacklist() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.blacklist))
+	for id := range s.blacklist {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Supplies returns the supplies not yet acknowledged with &I, oldest first.
+func (s *Simulator) Supplies() []companytec.SupplyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]companytec.SupplyRecord, 0, s.pendingLocked())
+	for i := s.read; i < s.written; i++ {
+		out = append(out, s.memory[i%len(s.memory)])
+	}
+	return out
+}
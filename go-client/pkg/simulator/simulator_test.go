@@ -0,0 +1,342 @@
+package simulator
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"companytec-client/pkg/companytec"
+)
+
+// clock is a manual clock for Config.Now.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newClock() *clock {
+	return &clock{t: time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// connect returns a client talking to sim over a net.Pipe.
+func connect(t *testing.T, sim *Simulator) *companytec.Client {
+	t.Helper()
+	dialer := companytec.DialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		client, device := net.Pipe()
+		go sim.ServeConn(device)
+		return client, nil
+	})
+	c := companytec.New("simulator:2001", companytec.WithDialer(dialer), companytec.WithTimeout(time.Second))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Disconnect)
+	return c
+}
+
+// statusOf returns the status of a nozzle as reported by &S.
+func statusOf(t *testing.T, c *companytec.Client, code string) companytec.StatusCode {
+	t.Helper()
+	statuses, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range statuses {
+		if n.Nozzle == code {
+			return n.Code
+		}
+	}
+	t.Fatalf("nozzle %s missing from status", code)
+	return ""
+}
+
+func TestDispensing(t *testing.T) {
+	clk := newClock()
+	sim := New(Config{Now: clk.Now})
+	c := connect(t, sim)
+
+	if got := statusOf(t, c, "01"); got != companytec.StatusAvailable {
+		t.Fatalf("idle nozzle in status %s", got)
+	}
+	if err := sim.Lift("01"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statusOf(t, c, "01"); got != companytec.StatusWaiting {
+		t.Fatalf("lifted nozzle in status %s, want E", got)
+	}
+	if _, err := c.SetOperatingMode("01", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statusOf(t, c, "01"); got != companytec.StatusRefueling {
+		t.Fatalf("authorized nozzle in status %s, want A", got)
+	}
+
+	// 50 hundredths of a liter per second by default
+	clk.Advance(10 * time.Second)
+	vis, err := c.Visualization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vis) != 1 || vis[0].Nozzle != "01" || vis[0].Value != 500*5890/1000 {
+		t.Errorf("visualization %+v", vis)
+	}
+
+	if err := sim.Hang("01"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statusOf(t, c, "01"); got != companytec.StatusFinished {
+		t.Errorf("hung nozzle in status %s, want C", got)
+	}
+	if got := statusOf(t, c, "01"); got != companytec.StatusAvailable {
+		t.Errorf("status %s after C was reported, want L", got)
+	}
+
+	total, err := c.Total("01", "L")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Value != 500 {
+		t.Errorf("volume totalizer %d, want 500", total.Value)
+	}
+}
+
+func TestSupplyMemory(t *testing.T) {
+	clk := newClock()
+	sim := New(Config{Now: clk.Now, AutoAuthorize: true, MemorySize: 4})
+	c := connect(t, sim)
+
+	for _, volume := range []int{1000, 2000} {
+		if err := sim.Lift("02"); err != nil {
+			t.Fatal(err)
+		}
+		if err := sim.Dispense("02", volume); err != nil {
+			t.Fatal(err)
+		}
+		if err := sim.Hang("02"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := c.Pointers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != (companytec.MemoryPointers{Write: 2, Read: 0}) {
+		t.Errorf("pointers %+v", *p)
+	}
+
+	for i, volume := range []int{1000, 2000} {
+		rec, err := c.Supply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec == nil || rec.Volume != volume || rec.Nozzle != "02" || rec.Record != i+1 {
+			t.Fatalf("supply %d: %+v", i, rec)
+		}
+		if rec.FinalTotal != int64(1000*(i+1)+1000*i) {
+			t.Errorf("supply %d: final total %d", i, rec.FinalTotal)
+		}
+		// The supply is returned until it is acknowledged
+		if again, err := c.Supply(); err != nil || again == nil || again.Record != rec.Record {
+			t.Fatalf("supply %d read again: %+v, %v", i, again, err)
+		}
+		if _, err := c.Increment(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec, err := c.Supply(); err != nil || rec != nil {
+		t.Errorf("empty memory: %+v, %v", rec, err)
+	}
+	if resp, err := c.Increment(); err != nil || resp != "(0)" {
+		t.Errorf("increment with no supply pending: %q, %v", resp, err)
+	}
+
+	// Stored supplies stay readable by position, and &L R rewinds
+	rec, err := c.SupplyAt(0)
+	if err != nil || rec == nil || rec.Volume != 1000 {
+		t.Fatalf("supply at 0: %+v, %v", rec, err)
+	}
+	if rec, err := c.MoveReadPointer(1); err != nil || rec == nil || rec.Volume != 2000 {
+		t.Fatalf("move read pointer: %+v, %v", rec, err)
+	}
+	if pending := sim.Supplies(); len(pending) != 1 || pending[0].Volume != 2000 {
+		t.Errorf("pending after rewind: %+v", pending)
+	}
+}
+
+func TestSupplyMemoryWraps(t *testing.T) {
+	sim := New(Config{AutoAuthorize: true, MemorySize: 2})
+	c := connect(t, sim)
+	for _, volume := range []int{100, 200, 300} {
+		sim.Lift("01")
+		sim.Dispense("01", volume)
+		sim.Hang("01")
+	}
+
+	// The oldest unread supply was overwritten
+	pending := sim.Supplies()
+	if len(pending) != 2 || pending[0].Volume != 200 || pending[1].Volume != 300 {
+		t.Errorf("pending %+v", pending)
+	}
+	rec, err := c.SupplyAt(0)
+	if err != nil || rec == nil || rec.Volume != 300 {
+		t.Errorf("supply at 0: %+v, %v", rec, err)
+	}
+}
+
+func TestWrites(t *testing.T) {
+	clk := newClock()
+	sim := New(Config{Now: clk.Now})
+	c := connect(t, sim)
+
+	if _, err := c.ChangePrice("01", "0", "6199"); err != nil {
+		t.Fatal(err)
+	}
+	price, err := c.Price("01", "U")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price.Levels[0] != 6199 {
+		t.Errorf("price levels %v after &U", price.Levels)
+	}
+
+	// A preset releases the nozzle and stops the supply at its value
+	if _, err := c.SetPreset("01", "1000"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statusOf(t, c, "01"); got != companytec.StatusReady {
+		t.Fatalf("preset nozzle in status %s, want P", got)
+	}
+	sim.Lift("01")
+	clk.Advance(time.Minute)
+	if got := statusOf(t, c, "01"); got != companytec.StatusFinished {
+		t.Fatalf("status %s past the preset, want C", got)
+	}
+	rec, err := c.Supply()
+	if err != nil || rec == nil {
+		t.Fatalf("supply: %+v, %v", rec, err)
+	}
+	if rec.TotalToPay > 1000 || rec.TotalToPay < 990 || rec.Price != 6199 {
+		t.Errorf("preset supply %+v", rec)
+	}
+
+	// A blocked nozzle refuses presets until it is released
+	if _, err := c.SetOperatingMode("02", "B"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statusOf(t, c, "02"); got != companytec.StatusBlocked {
+		t.Errorf("blocked nozzle in status %s", got)
+	}
+	if _, err := c.SetPreset("02", "1000"); err == nil {
+		t.Error("preset accepted on a blocked nozzle")
+	}
+	if _, err := c.SetOperatingMode("02", "L"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetPreset("02", "1000"); err != nil {
+		t.Errorf("preset after release: %v", err)
+	}
+
+	if _, err := c.ChangePrice("0A", "0", "6199"); err == nil {
+		t.Error("price change accepted for a missing nozzle")
+	}
+}
+
+func TestIdentifierMemory(t *testing.T) {
+	sim := New(Config{})
+	c := connect(t, sim)
+
+	// ?LF answers (0) for an empty position
+	if rec, err := c.Identifier(1); rec != nil || err != nil {
+		t.Fatalf("empty memory: %+v, %v", rec, err)
+	}
+	rec := companytec.IdentifierRecord{
+		Control:   "01",
+		Parameter: "G",
+		ID:        "00A1B2C3D4E5F601",
+		ShiftA:    companytec.Shift{Start: "0600", End: "1400"},
+		ShiftB:    companytec.Shift{Start: "1400", End: "2200"},
+	}
+	if _, err := c.RecordIdentifier(rec); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Identifier(1)
+	if err != nil || got == nil || *got != rec {
+		t.Fatalf("recorded identifier %+v, %v", got, err)
+	}
+	if got, err := c.Identifier(2); got != nil || err != nil {
+		t.Errorf("next position: %+v, %v", got, err)
+	}
+
+	if _, err := c.ClearIdentifierMemory(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Identifier(1); got != nil || err != nil {
+		t.Errorf("cleared memory: %+v, %v", got, err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	sim := New(Config{})
+	addr, err := sim.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	c := companytec.New(addr, companytec.WithTimeout(time.Second),
+		companytec.WithReconnectPolicy(companytec.ReconnectPolicy{}))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+	if _, err := c.Status(); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.Close()
+	if _, err := c.Status(); err == nil {
+		t.Error("status succeeded after the simulator closed")
+	}
+}
+
+func TestIdentifiedSupplies(t *testing.T) {
+	sim := New(Config{AutoAuthorize: true, Identified: true})
+	c := connect(t, sim)
+	if err := sim.Identify("03", "aaaa000000000001", "BBBB000000000002", 98765); err != nil {
+		t.Fatal(err)
+	}
+	sim.Lift("03")
+	sim.Dispense("03", 700)
+	sim.Hang("03")
+
+	rec, err := c.Supply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Tag != "AAAA000000000001" || rec.Odometer != 98765 || rec.Record != 1 {
+		t.Errorf("identified supply %+v", rec)
+	}
+	dual, err := c.SupplyDual()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dual.AttendantTag != "AAAA000000000001" || dual.CustomerTag != "BBBB000000000002" ||
+		dual.Odometer != 98765 || dual.Volume != 700 || dual.FinalTotal != 700 {
+		t.Errorf("dual identification supply %+v", dual)
+	}
+}
@@ -0,0 +1,185 @@
+// Package tracing sets up OpenTelemetry tracing for the gateway: a tracer
+// provider exporting to an OTLP collector, stdout or a file, and the gin
+// middleware creating a span per API request.
+//
+// Device commands get their spans from the client (see
+// companytec.WithTracerProvider); since they are started from the request
+// context, a slow request shows whether the time went to the handler, the
+// command queue, the connection lock, a dial or the device round trip.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"companytec-client/pkg/companytec"
+)
+
+// tracerName is the instrumentation scope of the API spans.
+const tracerName = "companytec-client/pkg/api"
+
+// Exporter kinds.
+const (
+	// ExporterOTLP sends spans to an OTLP/HTTP collector.
+	ExporterOTLP = "otlp"
+	// ExporterStdout prints spans as indented JSON, for local debugging.
+	ExporterStdout = "stdout"
+	// ExporterFile appends spans to a file, one JSON object per line, for
+	// offline debugging.
+	ExporterFile = "file"
+)
+
+// Config configures a tracer provider.
+type Config struct {
+	// Exporter is ExporterOTLP, ExporterStdout or ExporterFile.
+	Exporter string
+	// Endpoint is the URL of the OTLP/HTTP collector, for example
+	// http://localhost:4318. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT
+	// environment variable, then to https://localhost:4318.
+	Endpoint string
+	// File is the file spans are appended to with ExporterFile.
+	File string
+	// ServiceName defaults to companytec-client.
+	ServiceName string
+	// SampleRatio is the fraction of traces recorded, unless the caller of
+	// the API already decided. Defaults to 1, every trace.
+	SampleRatio float64
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "companytec-client"
+	}
+	if cfg.SampleRatio <= 0 {
+		cfg.SampleRatio = 1
+	}
+}
+
+// NewProvider creates a tracer provider exporting as configured. Call its
+// Shutdown before exiting to flush the last spans.
+func NewProvider(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	cfg.setDefaults()
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("otlp exporter: %w", err)
+		}
+		exporter = exp
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("file exporter needs a file")
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter = fileExporter{exp, f}
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want %s, %s or %s", cfg.Exporter, ExporterOTLP, ExporterStdout, ExporterFile)
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	), nil
+}
+
+// fileExporter closes the file when the exporter is shut down.
+type fileExporter struct {
+	*stdouttrace.Exporter
+	f *os.File
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Install makes tp the global tracer provider, used by default by the
+// clients, and W3C trace context the global propagator, so API callers can
+// pass their trace in a traceparent header.
+func Install(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Middleware creates a server span for every request of the gin router it is
+// used by, named after the route pattern, and passes it to the handlers in
+// the request context. A trace given by the caller is continued. The span
+// carries the route pattern but not the path, and handler errors with their
+// identifier tags masked, so that no tag reaches the trace backend.
+func Middleware(tp trace.TracerProvider) gin.HandlerFunc {
+	tracer := tp.Tracer(tracerName)
+	return func(c *gin.Context) {
+		r := c.Request
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := c.FullPath()
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+		c.Request = r.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if err := c.Errors.Last(); err != nil {
+			span.AddEvent("exception", trace.WithAttributes(
+				attribute.String("exception.type", fmt.Sprintf("%T", err.Err)),
+				attribute.String("exception.message", companytec.RedactError(err.Err)),
+			))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// spanAttr returns the value of an attribute of span.
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	Install(tp)
+	t.Cleanup(func() { Install(trace.NewNoopTracerProvider()) })
+
+	var handlerSpan trace.SpanContext
+	r := gin.New()
+	r.Use(Middleware(tp))
+	r.GET("/total/:nozzle", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	r.POST("/preset", func(c *gin.Context) {
+		c.Error(errors.New("device unreachable"))
+		c.Status(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest("GET", "/total/01", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/preset", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans, want one per request", len(spans))
+	}
+	get := spans[0]
+	if get.Name() != "GET /total/:nozzle" || get.SpanKind() != trace.SpanKindServer {
+		t.Errorf("span %q of kind %s", get.Name(), get.SpanKind())
+	}
+	if spanAttr(get, "http.route").AsString() != "/total/:nozzle" || spanAttr(get, "url.path").AsString() != "" ||
+		spanAttr(get, "http.response.status_code").AsInt64() != http.StatusOK {
+		t.Errorf("attributes %v", get.Attributes())
+	}
+	// The trace of the caller is continued and passed to the handler
+	if get.SpanContext().TraceID().String() != "0af7651916cd43dd8448eb211c80319c" ||
+		get.Parent().SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("span %s not in the caller trace", get.SpanContext().TraceID())
+	}
+	if handlerSpan.SpanID() != get.SpanContext().SpanID() {
+		t.Error("the handler context does not hold the request span")
+	}
+
+	post := spans[1]
+	if post.Status().Code != codes.Error || len(post.Events()) != 1 {
+		t.Errorf("failed request: status %+v, events %v", post.Status(), post.Events())
+	}
+	if missing := spans[2]; missing.Name() != "GET" || missing.Status().Code == codes.Error {
+		t.Errorf("unmatched request span %q, status %+v", missing.Name(), missing.Status())
+	}
+}
+
+func TestMiddlewareRedacts(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	r := gin.New()
+	r.Use(Middleware(tp))
+	r.PUT("/blacklist/:id", func(c *gin.Context) {
+		c.Error(fmt.Errorf("blacklist %s: device unreachable", c.Param("id")))
+		c.Status(http.StatusBadGateway)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/blacklist/ABCDEF0123456789", nil))
+
+	spans := rec.Ended()
+	if len(spans) != 1 || len(spans[0].Events()) != 1 {
+		t.Fatalf("spans %v", spans)
+	}
+	span := spans[0]
+	exported := fmt.Sprint(span.Name(), span.Attributes(), span.Events(), span.Status())
+	if strings.Contains(exported, "ABCDEF012345") {
+		t.Errorf("tag exported in %s", exported)
+	}
+	if spanAttr(span, "http.route").AsString() != "/blacklist/:id" {
+		t.Errorf("attributes %v", span.Attributes())
+	}
+}
+
+func TestInstall(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	Install(tp)
+	t.Cleanup(func() { Install(trace.NewNoopTracerProvider()) })
+	if otel.GetTracerProvider() != tp {
+		t.Error("tracer provider not installed")
+	}
+	fields := otel.GetTextMapPropagator().Fields()
+	if !strings.Contains(strings.Join(fields, ","), "traceparent") {
+		t.Errorf("propagator fields %v", fields)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewProvider(ctx, Config{Exporter: "zipkin"}); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+	if _, err := NewProvider(ctx, Config{Exporter: ExporterFile}); err == nil {
+		t.Error("file exporter accepted without a file")
+	}
+
+	path := filepath.Join(t.TempDir(), "spans.json")
+	tp, err := NewProvider(ctx, Config{Exporter: ExporterFile, File: path, ServiceName: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tp.Tracer("test").Start(ctx, "companytec dial")
+	span.End()
+	if err := tp.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 ||
+		!strings.Contains(lines[0], `"Name":"companytec dial"`) || !strings.Contains(lines[0], `"gateway"`) {
+		t.Errorf("exported spans:\n%s", data)
+	}
+}